@@ -30,6 +30,7 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/controllers/nodelabelsync"
+	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/controllers/xohealth"
 	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra"
 
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -58,13 +59,13 @@ func main() {
 		},
 		Constructor: nodelabelsync.StartNodeLabelSyncControllerWrapper,
 	}
+	controllerInitializers[xohealth.ControllerName] = app.ControllerInitFuncConstructor{
+		Constructor: xohealth.StartXOHealthControllerWrapper,
+	}
 
 	controllerAliases := names.CCMControllerAliases()
 	controllerAliases[nodelabelsync.ControllerAlias] = nodelabelsync.ControllerName
-	// Here is an example to remove the controller which is not needed.
-	// e.g. remove the cloud-node-lifecycle controller which current cloud provider does not need.
-	delete(controllerInitializers, "service-lb-controller")
-	delete(controllerInitializers, "node-route-controller")
+	controllerAliases[xohealth.ControllerAlias] = xohealth.ControllerName
 
 	fss := cliflag.NamedFlagSets{}
 	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, controllerInitializers, controllerAliases, fss, wait.NeverStop)
@@ -16,19 +16,28 @@ limitations under the License.
 package nodelabelsync
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra"
 	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	cloudproviderapi "k8s.io/cloud-provider/api"
 	cloudnodeutil "k8s.io/cloud-provider/node/helpers"
+	nodeutil "k8s.io/component-helpers/node/util"
 	"k8s.io/klog/v2"
 )
 
+// NodeConditionProviderIDPoolHistorical is true when the VM of the node migrated to another pool than the one of its providerID.
+// The providerID is immutable, its pool is then historical and the topology labels follow the current pool.
+const NodeConditionProviderIDPoolHistorical v1.NodeConditionType = "XenOrchestraProviderIDPoolHistorical"
+
 // updateNodeLabels updates the labels of a single node
 func getNodeLabelUpdate(node *v1.Node, instanceMetadata *cloudprovider.InstanceMetadata) map[string]string {
 	klog.V(5).Infof("NodeLabelSyncController.updateNodeLabels(): sync node %s", node.Name)
@@ -54,27 +63,34 @@ func getNodeLabelUpdate(node *v1.Node, instanceMetadata *cloudprovider.InstanceM
 	}
 
 	/**
-	 * If label "zone" changed: Node has migrated to a new host (vm.$container field)
+	 * If the host changed: Node has migrated to a new host (vm.$container field)
+	 * If the pool changed: The node VM has migrated to another pool
+	 * The zone and region do not map to the host and pool with every topology strategy,
+	 * so the host and pool ID labels are compared when the node has them.
 	 */
+	if originalHost, migrated := previousTopology(nodeLabels, instanceMetadata, xok8s.XOLabelTopologyHostID, v1.LabelTopologyZone, instanceMetadata.Zone); migrated {
+		klog.V(2).Infof("Node %s VM host has changed: old=%s", node.Name, originalHost)
+		if _, exists := nodeLabels[xok8s.XOLabelTopologyOriginalHostID]; !exists {
+			labelsToUpdate[xok8s.XOLabelTopologyOriginalHostID] = originalHost
+		}
+	}
+	if originalPool, migrated := previousTopology(nodeLabels, instanceMetadata, xok8s.XOLabelTopologyPoolID, v1.LabelTopologyRegion, instanceMetadata.Region); migrated {
+		klog.V(2).Infof("Node %s VM pool has changed: old=%s", node.Name, originalPool)
+		if _, exists := nodeLabels[xok8s.XOLabelTopologyOriginalPoolID]; !exists {
+			labelsToUpdate[xok8s.XOLabelTopologyOriginalPoolID] = originalPool
+		}
+	}
+
 	// Check if the existing node label for zone differs from the new instance metadata Zone value
 	existingZone, hasZone := nodeLabels[v1.LabelTopologyZone]
 	if hasZone && instanceMetadata.Zone != "" && existingZone != instanceMetadata.Zone {
-		klog.V(2).Infof("Node %s zone has changed (VM host has changed): old=%s, new=%s", node.Name, existingZone, instanceMetadata.Zone)
-		if _, exists := nodeLabels[xok8s.XOLabelTopologyOriginalHostID]; !exists {
-			labelsToUpdate[xok8s.XOLabelTopologyOriginalHostID] = nodeLabels[v1.LabelTopologyZone]
-		}
+		klog.V(2).Infof("Node %s zone has changed: old=%s, new=%s", node.Name, existingZone, instanceMetadata.Zone)
 		labelsToUpdate[v1.LabelTopologyZone] = instanceMetadata.Zone
 		labelsToUpdate[v1.LabelFailureDomainBetaZone] = instanceMetadata.Zone
 	}
-	/**
-	 * If label "region" changed: The node VM has migrated to another pool
-	 */
 	existingRegion, hasRegion := nodeLabels[v1.LabelTopologyRegion]
 	if hasRegion && instanceMetadata.Region != "" && existingRegion != instanceMetadata.Region {
-		klog.V(2).Infof("Node %s region has changed (VM pool has changed): old=%s, new=%s", node.Name, existingRegion, instanceMetadata.Region)
-		if _, exists := nodeLabels[xok8s.XOLabelTopologyOriginalPoolID]; !exists {
-			labelsToUpdate[xok8s.XOLabelTopologyOriginalPoolID] = nodeLabels[v1.LabelTopologyRegion]
-		}
+		klog.V(2).Infof("Node %s region has changed: old=%s, new=%s", node.Name, existingRegion, instanceMetadata.Region)
 		labelsToUpdate[v1.LabelTopologyRegion] = instanceMetadata.Region
 		labelsToUpdate[v1.LabelFailureDomainBetaRegion] = instanceMetadata.Region
 	}
@@ -104,6 +120,14 @@ func updateNodeLabels(kubeClient clientset.Interface, recorder record.EventRecor
 
 	klog.V(4).InfoS("Updated labels for node %q", "node", node.Name, "labelsToUpdate", labelsToUpdate)
 
+	// The host and pool ID labels are updated, each migration is counted once
+	if _, migrated := previousTopology(node.Labels, instanceMetadata, xok8s.XOLabelTopologyHostID, v1.LabelTopologyZone, instanceMetadata.Zone); migrated {
+		nodeMigrations.WithLabelValues(migrationScopeHost).Inc()
+	}
+	if _, migrated := previousTopology(node.Labels, instanceMetadata, xok8s.XOLabelTopologyPoolID, v1.LabelTopologyRegion, instanceMetadata.Region); migrated {
+		nodeMigrations.WithLabelValues(migrationScopePool).Inc()
+	}
+
 	eventRef := &v1.ObjectReference{
 		APIVersion: "v1",
 		Kind:       "Node",
@@ -114,28 +138,96 @@ func updateNodeLabels(kubeClient clientset.Interface, recorder record.EventRecor
 	// Node Zone has changed
 	if _, exists := labelsToUpdate[v1.LabelTopologyZone]; exists {
 		existingZone := node.Labels[v1.LabelTopologyZone]
-		// Record an event related to the node VM host that has changed
+		// Record an event related to the node zone that has changed
 		recorder.Eventf(eventRef, v1.EventTypeWarning, "NodeZoneChanged",
-			"Node %s zone changed (node VM host changed): old=%s, new=%s", node.Name, existingZone, instanceMetadata.Zone)
+			"Node %s zone changed: old=%s, new=%s", node.Name, existingZone, instanceMetadata.Zone)
 	}
 	// Node Region has changed
 	if _, exists := labelsToUpdate[v1.LabelTopologyRegion]; exists {
 		existingRegion := node.Labels[v1.LabelTopologyRegion]
-		// Record an event related to the node VM pool that has changed
+		// Record an event related to the node region that has changed
 		recorder.Eventf(eventRef, v1.EventTypeWarning, "NodeRegionChanged",
-			"Node %s region changed (node VM pool changed): old=%s, new=%s", node.Name, existingRegion, instanceMetadata.Region)
+			"Node %s region changed: old=%s, new=%s", node.Name, existingRegion, instanceMetadata.Region)
 	}
 	// Instance Type has changed
 	if _, exists := labelsToUpdate[v1.LabelInstanceType]; exists {
 		existVMType := node.Labels[v1.LabelInstanceTypeStable]
 		// Record an event related to the node VM type
 		recorder.Eventf(eventRef, v1.EventTypeNormal, "NodeInstanceTypeHasChanged",
-			"Node %s instance type has changed (node VM memory and/or CPUs changed): old=%s, new=%s", node.Name, existVMType, instanceMetadata.InstanceType)
+			"Node %s instance type has changed (node VM size, tag or template changed): old=%s, new=%s", node.Name, existVMType, instanceMetadata.InstanceType)
 
 	}
 	return true
 }
 
+// updateProviderIDPoolCondition sets the ProviderIDPoolHistorical condition of the node when its VM migrated to
+// another pool than the one of its providerID, and records an event when the condition becomes true.
+// The condition is only added once the VM has migrated, it is set to false when the VM is back in the providerID pool.
+func updateProviderIDPoolCondition(kubeClient clientset.Interface, recorder record.EventRecorder, node *v1.Node, instanceMetadata *cloudprovider.InstanceMetadata) {
+	providerIDPool, err := xenorchestra.ProviderIDPool(node.Spec.ProviderID)
+	if err != nil || providerIDPool.IsNil() {
+		return
+	}
+
+	pool := instanceMetadata.AdditionalLabels[xok8s.XOLabelTopologyPoolID]
+	if pool == "" {
+		return
+	}
+
+	historical := pool != providerIDPool.String()
+	_, existing := nodeutil.GetNodeCondition(&node.Status, NodeConditionProviderIDPoolHistorical)
+
+	if existing == nil && !historical {
+		return
+	}
+
+	condition := v1.NodeCondition{
+		Type:    NodeConditionProviderIDPoolHistorical,
+		Status:  v1.ConditionFalse,
+		Reason:  "VMInProviderIDPool",
+		Message: fmt.Sprintf("The VM is in the pool %s of the providerID", pool),
+	}
+	if historical {
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "VMMigratedToAnotherPool"
+		condition.Message = fmt.Sprintf("The VM migrated from the pool %s of the providerID to the pool %s, the providerID pool is historical", providerIDPool, pool)
+	}
+
+	if existing != nil && existing.Status == condition.Status && existing.Message == condition.Message {
+		return
+	}
+
+	condition.LastTransitionTime = metav1.Now()
+	if existing != nil && existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+
+	if err := nodeutil.SetNodeCondition(kubeClient, types.NodeName(node.Name), condition); err != nil {
+		klog.ErrorS(err, "failed to set the providerID pool condition of the node", "node", klog.KObj(node))
+
+		return
+	}
+
+	if historical && (existing == nil || existing.Status != v1.ConditionTrue) {
+		recorder.Eventf(&v1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: node.Name, UID: node.UID}, v1.EventTypeNormal, "ProviderIDPoolHistorical",
+			"Node %s VM migrated from the pool %s of the providerID to the pool %s, the providerID is kept", node.Name, providerIDPool, pool)
+	}
+}
+
+// previousTopology returns the previous host or pool ID of a node VM that has migrated.
+// Nodes labeled before the ID labels existed fall back to the zone or region label.
+func previousTopology(nodeLabels map[string]string, instanceMetadata *cloudprovider.InstanceMetadata, idLabel, topologyLabel, topology string) (string, bool) {
+	if existing, exists := nodeLabels[idLabel]; exists {
+		current := instanceMetadata.AdditionalLabels[idLabel]
+
+		return existing, current != "" && existing != current
+	}
+
+	existing, exists := nodeLabels[topologyLabel]
+
+	return existing, exists && topology != "" && existing != topology
+}
+
 func getCloudTaint(taints []v1.Taint) *v1.Taint {
 	for _, taint := range taints {
 		if taint.Key == cloudproviderapi.TaintExternalCloudProvider {
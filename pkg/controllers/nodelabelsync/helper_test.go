@@ -150,6 +150,55 @@ func TestGetNodeLabelUpdate_ZoneChangedAddsOriginalHostAndUpdatesLabels(t *testi
 	assert.Equal(t, testZone1, result[xok8s.XOLabelTopologyOriginalHostID], "original host id should be set to previous zone")
 }
 
+func TestGetNodeLabelUpdate_HostChangedWithPoolZoneStrategy(t *testing.T) {
+	// The zone is the pool: a host migration does not change the zone
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-5",
+			Labels: map[string]string{
+				v1.LabelTopologyZone:        testZone1,
+				xok8s.XOLabelTopologyHostID: "host-1",
+			},
+		},
+		Spec: v1.NodeSpec{Taints: []v1.Taint{}},
+	}
+
+	meta := &cloudprovider.InstanceMetadata{
+		Zone:             testZone1,
+		AdditionalLabels: map[string]string{xok8s.XOLabelTopologyHostID: "host-2"},
+	}
+
+	result := getNodeLabelUpdate(node, meta)
+
+	assert.Equal(t, "host-2", result[xok8s.XOLabelTopologyHostID], "host id label should be updated")
+	assert.Equal(t, "host-1", result[xok8s.XOLabelTopologyOriginalHostID], "original host id should be set to previous host id")
+	assert.NotContains(t, result, v1.LabelTopologyZone, "zone label should not change")
+}
+
+func TestGetNodeLabelUpdate_ZoneChangedOnSameHost(t *testing.T) {
+	// The zone is read from a tag: a tag change is not a migration
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-6",
+			Labels: map[string]string{
+				v1.LabelTopologyZone:        testZone1,
+				xok8s.XOLabelTopologyHostID: "host-1",
+			},
+		},
+		Spec: v1.NodeSpec{Taints: []v1.Taint{}},
+	}
+
+	meta := &cloudprovider.InstanceMetadata{
+		Zone:             testZone2,
+		AdditionalLabels: map[string]string{xok8s.XOLabelTopologyHostID: "host-1"},
+	}
+
+	result := getNodeLabelUpdate(node, meta)
+
+	assert.Equal(t, testZone2, result[v1.LabelTopologyZone], "zone label should be updated")
+	assert.NotContains(t, result, xok8s.XOLabelTopologyOriginalHostID, "original host id should not be set")
+}
+
 func TestGetNodeLabelUpdate_ZoneChangedDoesNotOverrideOriginalHostIfExists(t *testing.T) {
 	node := &v1.Node{
 		ObjectMeta: metav1.ObjectMeta{
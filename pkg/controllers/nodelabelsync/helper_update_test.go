@@ -33,6 +33,7 @@ import (
 	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/component-base/metrics/testutil"
 )
 
 var testNode = &v1.Node{
@@ -238,3 +239,130 @@ func TestUpdateNodeLabels_APIFailure(t *testing.T) {
 		assert.NotContains(t, joined, "NodeInstanceTypeHasChanged")
 	}
 }
+
+func TestUpdateNodeLabels_CountsMigrations(t *testing.T) {
+	ctx := context.TODO()
+	client := k8sfake.NewClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-migrated",
+			Labels: map[string]string{
+				xok8s.XOLabelTopologyHostID: "host-1",
+				xok8s.XOLabelTopologyPoolID: "pool-1",
+			},
+		},
+	}
+
+	_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to seed fake node: %v", err)
+	}
+
+	hostMigrations := func() float64 {
+		value, err := testutil.GetCounterMetricValue(nodeMigrations.WithLabelValues(migrationScopeHost))
+		assert.NoError(t, err)
+
+		return value
+	}
+	poolMigrations := func() float64 {
+		value, err := testutil.GetCounterMetricValue(nodeMigrations.WithLabelValues(migrationScopePool))
+		assert.NoError(t, err)
+
+		return value
+	}
+	hosts, pools := hostMigrations(), poolMigrations()
+
+	meta := &cloudprovider.InstanceMetadata{AdditionalLabels: map[string]string{
+		xok8s.XOLabelTopologyHostID: "host-2",
+		xok8s.XOLabelTopologyPoolID: "pool-1",
+	}}
+
+	assert.True(t, updateNodeLabels(client, recorder, node, meta))
+	assert.Equal(t, hosts+1, hostMigrations())
+	assert.Equal(t, pools, poolMigrations(), "the VM stayed in its pool")
+
+	// The migration is counted once
+	got, err := client.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+
+	assert.False(t, updateNodeLabels(client, recorder, got, meta))
+	assert.Equal(t, hosts+1, hostMigrations())
+}
+
+func TestUpdateProviderIDPoolCondition(t *testing.T) {
+	ctx := context.TODO()
+	client := k8sfake.NewClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	const (
+		providerIDPool = "a3c8f86b-9c2f-4c3d-8a7b-2d44e6f77f1d"
+		otherPool      = "b3c8f86b-9c2f-4c3d-8a7b-2d44e6f77f1d"
+	)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-migrated"},
+		Spec:       v1.NodeSpec{ProviderID: xok8s.ProviderName + "://" + providerIDPool + "/550e8400-e29b-41d4-a716-446655440001"},
+	}
+
+	_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to seed fake node: %v", err)
+	}
+
+	getCondition := func() *v1.NodeCondition {
+		got, err := client.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get node: %v", err)
+		}
+
+		for i := range got.Status.Conditions {
+			if got.Status.Conditions[i].Type == NodeConditionProviderIDPoolHistorical {
+				return &got.Status.Conditions[i]
+			}
+		}
+
+		return nil
+	}
+
+	// The VM is in the providerID pool, no condition is added
+	updateProviderIDPoolCondition(client, recorder, node, &cloudprovider.InstanceMetadata{
+		AdditionalLabels: map[string]string{xok8s.XOLabelTopologyPoolID: providerIDPool},
+	})
+	assert.Nil(t, getCondition())
+	assert.Empty(t, drainEvents(recorder, 1, 150*time.Millisecond))
+
+	// The VM migrated to another pool
+	migrated := &cloudprovider.InstanceMetadata{AdditionalLabels: map[string]string{xok8s.XOLabelTopologyPoolID: otherPool}}
+	updateProviderIDPoolCondition(client, recorder, node, migrated)
+
+	condition := getCondition()
+	if condition == nil {
+		t.Fatalf("expected the %s condition", NodeConditionProviderIDPoolHistorical)
+	}
+	assert.Equal(t, v1.ConditionTrue, condition.Status)
+	assert.Equal(t, "VMMigratedToAnotherPool", condition.Reason)
+	assert.Contains(t, condition.Message, otherPool)
+
+	evs := drainEvents(recorder, 1, 500*time.Millisecond)
+	if assert.Len(t, evs, 1) {
+		assert.True(t, strings.HasPrefix(evs[0], "Normal ProviderIDPoolHistorical"), evs[0])
+	}
+
+	// The next syncs do not record the event again
+	node, err = client.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	updateProviderIDPoolCondition(client, recorder, node, migrated)
+	assert.Empty(t, drainEvents(recorder, 1, 150*time.Millisecond))
+
+	// The VM is back in the providerID pool
+	updateProviderIDPoolCondition(client, recorder, node, &cloudprovider.InstanceMetadata{
+		AdditionalLabels: map[string]string{xok8s.XOLabelTopologyPoolID: providerIDPool},
+	})
+	assert.Equal(t, v1.ConditionFalse, getCondition().Status)
+}
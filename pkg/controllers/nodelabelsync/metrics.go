@@ -0,0 +1,63 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package nodelabelsync
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// Scopes of the node VM migrations, a cross-pool migration also changes the host.
+const (
+	migrationScopeHost = "host"
+	migrationScopePool = "pool"
+)
+
+var (
+	labelSyncDuration = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "node_label_sync",
+			Name:           "duration_seconds",
+			Help:           "Duration of the periodic node label sync cycles.",
+			Buckets:        []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	labelSyncUpdatedNodes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "node_label_sync",
+			Name:           "updated_nodes",
+			Help:           "Number of nodes whose labels were updated by the last periodic node label sync cycle.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	nodeMigrations = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "node_label_sync",
+			Name:           "migrations_total",
+			Help:           "Number of node VM migrations detected by the node label sync, by scope: host or pool.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"scope"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(labelSyncDuration, labelSyncUpdatedNodes, nodeMigrations)
+}
@@ -17,8 +17,17 @@ package nodelabelsync
 
 import (
 	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra"
 
 	v1 "k8s.io/api/core/v1"
@@ -46,8 +55,15 @@ const (
 	ControllerAlias string = "cloud-node-label-sync"
 )
 
+// eventActionGetInventory is the action of the events recorded when the inventory of a sync cycle cannot be fetched.
+const eventActionGetInventory = "GetInventory"
+
+// nodeVMIndex indexes the nodes by the ID of their VM, from the providerID and the system UUID.
+const nodeVMIndex = "xenorchestra.vmID"
+
 // Controller periodically syncs node labels from Xen Orchestra
-// based on the logic in InstanceMetadata.
+// based on the logic in InstanceMetadata. The nodes whose VM changed
+// are also synced as soon as Xen Orchestra reports the change.
 type Controller struct {
 	nodeInformer     coreinformers.NodeInformer
 	eventBroadcaster record.EventBroadcaster
@@ -55,12 +71,16 @@ type Controller struct {
 	kubeClient       clientset.Interface
 
 	nodesLister        corelisters.NodeLister
+	nodeIndexer        cache.Indexer
 	nodeInformerSynced cache.InformerSynced
 
 	nodeStatusUpdateFrequency time.Duration
 	workerCount               int32
 	cloud                     cloudprovider.Interface
 	i                         xenorchestra.XOInstances
+
+	// queue holds the names of the nodes to sync after a VM change
+	queue workqueue.TypedRateLimitingInterface[string]
 }
 
 func StartNodeLabelSyncControllerWrapper(initContext app.ControllerInitContext, completedConfig *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
@@ -106,6 +126,10 @@ func NewNodeLabelSyncController(
 ) (*Controller, error) {
 	instances, _ := cloud.InstancesV2()
 
+	if err := addNodeVMIndexer(nodeInformer.Informer()); err != nil {
+		return nil, err
+	}
+
 	eventBroadcaster := record.NewBroadcaster(record.WithContext(ctx))
 
 	return &Controller{
@@ -115,10 +139,15 @@ func NewNodeLabelSyncController(
 		recorder:                  eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: ControllerName}),
 		cloud:                     cloud,
 		nodesLister:               nodeInformer.Lister(),
+		nodeIndexer:               nodeInformer.Informer().GetIndexer(),
 		nodeInformerSynced:        nodeInformer.Informer().HasSynced,
 		i:                         instances.(xenorchestra.XOInstances),
 		nodeStatusUpdateFrequency: nodeStatusUpdateFrequency,
 		workerCount:               workerCount,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: ControllerName},
+		),
 	}, nil
 }
 
@@ -139,6 +168,15 @@ func (c *Controller) Run(ctx context.Context, interval time.Duration) {
 		return
 	}
 
+	defer c.queue.ShutDown()
+
+	// Sync the nodes as soon as their VM changes, the periodic sync below remains as a safety net
+	if c.i.WatchInstances(ctx, c.enqueueVM) {
+		for range c.workerCount {
+			go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		}
+	}
+
 	// Run the controller in a loop, periodically syncing node labels
 	go wait.UntilWithContext(ctx, func(ctx context.Context) {
 		if err := c.UpdateNodeLabels(ctx); err != nil {
@@ -152,34 +190,142 @@ func (c *Controller) Run(ctx context.Context, interval time.Duration) {
 func (c *Controller) UpdateNodeLabels(ctx context.Context) error {
 	klog.V(5).Info("NodeLabelSyncController.UpdateNodeLabels(): Syncing all nodes")
 	start := time.Now()
+
+	ctx, span := otel.Tracer(xenorchestra.TracerName).Start(ctx, "NodeLabelSync")
+	defer span.End()
+
 	nodes, err := c.nodesLister.List(labels.Everything())
 	if err != nil {
 		klog.Errorf("Error monitoring node status: %v", err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
+	var updated atomic.Int32
 	defer func() {
+		labelSyncDuration.Observe(time.Since(start).Seconds())
+		labelSyncUpdatedNodes.Set(float64(updated.Load()))
 		klog.V(2).Infof("Update %d nodes status took %v.", len(nodes), time.Since(start))
 	}()
 
+	// Fetch the VMs, hosts and pools once per cycle instead of once per node
+	inventoryCtx, err := c.i.WithInventory(ctx, nodes)
+	if err != nil {
+		klog.Errorf("Error getting the inventory for node label sync, falling back to per node lookups: %v", err)
+		c.i.ReportFailure(ctx, nil, eventActionGetInventory, err)
+	}
+
 	updateNodeFunc := func(piece int) {
-		node := nodes[piece].DeepCopy()
-		// Do not process nodes that are still tainted, those will be processed by the cloud-node-controller
-		cloudTaint := getCloudTaint(node.Spec.Taints)
-		if cloudTaint != nil {
-			klog.V(5).Infof("This node %s is still tainted. Will not process.", node.Name)
-			return
+		if c.syncNode(inventoryCtx, nodes[piece].DeepCopy()) {
+			updated.Add(1)
 		}
+	}
+
+	workqueue.ParallelizeUntil(inventoryCtx, int(c.workerCount), len(nodes), updateNodeFunc)
+	return nil
+}
+
+// syncNode updates the labels of the node from its instance metadata, and reports whether it updated them.
+func (c *Controller) syncNode(ctx context.Context, node *v1.Node) bool {
+	ctx, span := otel.Tracer(xenorchestra.TracerName).Start(ctx, "NodeLabelSync.syncNode", trace.WithAttributes(
+		semconv.K8SNodeName(node.Name),
+		attribute.String("k8s.node.provider_id", node.Spec.ProviderID),
+	))
+	defer span.End()
+
+	// Do not process nodes that are still tainted, those will be processed by the cloud-node-controller
+	cloudTaint := getCloudTaint(node.Spec.Taints)
+	if cloudTaint != nil {
+		klog.V(5).Infof("This node %s is still tainted. Will not process.", node.Name)
+		return false
+	}
+
+	instanceMetadata, err := c.i.InstanceMetadata(ctx, node)
+	if err != nil {
+		// The failure is recorded as an event on the node by InstanceMetadata
+		klog.Errorf("Error getting instance metadata for node label sync: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return false
+	}
+	updated := updateNodeLabels(c.kubeClient, c.recorder, node, instanceMetadata)
+	updateProviderIDPoolCondition(c.kubeClient, c.recorder, node, instanceMetadata)
+
+	return updated
+}
+
+// addNodeVMIndexer indexes the nodes of the informer by VM ID, so that the VM changes do not scan all the nodes.
+// Xen Orchestra reports all the VMs when the event subscription connects.
+func addNodeVMIndexer(informer cache.SharedIndexInformer) error {
+	if _, ok := informer.GetIndexer().GetIndexers()[nodeVMIndex]; ok {
+		return nil
+	}
+
+	return informer.AddIndexers(cache.Indexers{nodeVMIndex: nodeVMIDs})
+}
+
+// nodeVMIDs returns the VM IDs of the node. The pool part of the providerID is outdated after a cross-pool
+// migration, so only its VM part is indexed.
+func nodeVMIDs(obj any) ([]string, error) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return nil, nil
+	}
+
+	ids := []string{}
+
+	if idx := strings.LastIndex(node.Spec.ProviderID, "/"); idx >= 0 && idx < len(node.Spec.ProviderID)-1 {
+		ids = append(ids, node.Spec.ProviderID[idx+1:])
+	}
+
+	if systemUUID := node.Status.NodeInfo.SystemUUID; systemUUID != "" && !slices.Contains(ids, systemUUID) {
+		ids = append(ids, systemUUID)
+	}
+
+	return ids, nil
+}
+
+// enqueueVM queues the nodes running on the VM.
+func (c *Controller) enqueueVM(vmID string) {
+	nodes, err := c.nodeIndexer.ByIndex(nodeVMIndex, vmID)
+	if err != nil {
+		klog.Errorf("Error listing nodes for VM %s: %v", vmID, err)
+		return
+	}
 
-		instanceMetadata, err := c.i.InstanceMetadata(ctx, node)
-		if err != nil {
-			klog.Errorf("Error getting instance metadata for node label sync: %v", err)
-			return
+	for _, obj := range nodes {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			continue
 		}
-		updateNodeLabels(c.kubeClient, c.recorder, node, instanceMetadata)
+
+		klog.V(4).InfoS("VM changed, syncing node labels", "node", klog.KObj(node), "vmID", vmID)
+		c.queue.Add(node.Name)
 	}
+}
 
-	workqueue.ParallelizeUntil(ctx, int(c.workerCount), len(nodes), updateNodeFunc)
-	return nil
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	name, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	node, err := c.nodesLister.Get(name)
+	if err != nil {
+		// The node was deleted
+		c.queue.Forget(name)
+		return true
+	}
+
+	c.syncNode(ctx, node.DeepCopy())
+	c.queue.Forget(name)
+
+	return true
 }
 
 func (c *Controller) Name() string {
@@ -0,0 +1,168 @@
+/*
+Copyright 2025 Vatesfr.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package nodelabelsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	testVMID      = "550e8400-e29b-41d4-a716-446655440001"
+	testOtherVMID = "550e8400-e29b-41d4-a716-446655440002"
+)
+
+// fakeInstances serves fixed instance metadata and exposes the VM change callback.
+type fakeInstances struct {
+	xenorchestra.XOInstances
+
+	metadata *cloudprovider.InstanceMetadata
+	onChange func(vmID string)
+}
+
+func (f *fakeInstances) InstanceMetadata(_ context.Context, _ *v1.Node) (*cloudprovider.InstanceMetadata, error) {
+	return f.metadata, nil
+}
+
+func (f *fakeInstances) WatchInstances(_ context.Context, onChange func(vmID string)) bool {
+	f.onChange = onChange
+
+	return true
+}
+
+func TestEnqueueVMSyncsOnlyTheAffectedNode(t *testing.T) {
+	ctx := t.Context()
+
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{v1.LabelTopologyZone: testZone1}},
+			Spec:       v1.NodeSpec{ProviderID: "xenorchestra://pool-1/" + testVMID},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{v1.LabelTopologyZone: testZone1}},
+			Spec:       v1.NodeSpec{ProviderID: "xenorchestra://pool-1/" + testOtherVMID},
+		},
+	}
+
+	client := k8sfake.NewClientset()
+	nodeInformer := informers.NewSharedInformerFactory(client, 0).Core().V1().Nodes()
+	require.NoError(t, addNodeVMIndexer(nodeInformer.Informer()))
+
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, nodeInformer.Informer().GetIndexer().Add(node))
+	}
+
+	instances := &fakeInstances{metadata: &cloudprovider.InstanceMetadata{Zone: testZone2, Region: testRegion1}}
+
+	c := &Controller{
+		kubeClient:  client,
+		recorder:    record.NewFakeRecorder(10),
+		nodesLister: nodeInformer.Lister(),
+		nodeIndexer: nodeInformer.Informer().GetIndexer(),
+		i:           instances,
+		workerCount: 1,
+		queue: workqueue.NewTypedRateLimitingQueue(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+		),
+	}
+	defer c.queue.ShutDown()
+
+	require.True(t, c.i.WatchInstances(ctx, c.enqueueVM))
+
+	// The VM of node-1 was migrated
+	instances.onChange(testVMID)
+	assert.Equal(t, 1, c.queue.Len())
+	assert.True(t, c.processNextItem(ctx))
+
+	got, err := client.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, testZone2, got.Labels[v1.LabelTopologyZone])
+
+	got, err = client.CoreV1().Nodes().Get(ctx, "node-2", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, testZone1, got.Labels[v1.LabelTopologyZone], "the other nodes are left to the periodic sync")
+
+	// A VM that is not a node is ignored
+	instances.onChange("550e8400-e29b-41d4-a716-446655440009")
+	assert.Equal(t, 0, c.queue.Len())
+
+	// A deleted node is dropped from the queue
+	require.NoError(t, nodeInformer.Informer().GetIndexer().Delete(nodes[1]))
+	c.queue.Add("node-2")
+	assert.True(t, c.processNextItem(ctx))
+	assert.Eventually(t, func() bool { return c.queue.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestNodeVMIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     *v1.Node
+		expected []string
+	}{
+		{
+			name:     "providerID",
+			node:     &v1.Node{Spec: v1.NodeSpec{ProviderID: "xenorchestra://pool-1/" + testVMID}},
+			expected: []string{testVMID},
+		},
+		{
+			name:     "providerID with endpoint",
+			node:     &v1.Node{Spec: v1.NodeSpec{ProviderID: "xenorchestra://dc1/pool-1/" + testVMID}},
+			expected: []string{testVMID},
+		},
+		{
+			name:     "system UUID before the providerID is set",
+			node:     &v1.Node{Status: v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: testVMID}}},
+			expected: []string{testVMID},
+		},
+		{
+			name: "same providerID and system UUID",
+			node: &v1.Node{
+				Spec:   v1.NodeSpec{ProviderID: "xenorchestra://pool-1/" + testVMID},
+				Status: v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: testVMID}},
+			},
+			expected: []string{testVMID},
+		},
+		{
+			name:     "no VM",
+			node:     &v1.Node{},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := nodeVMIDs(tt.node)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
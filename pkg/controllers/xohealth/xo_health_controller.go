@@ -0,0 +1,65 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xohealth
+
+import (
+	"context"
+
+	"github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra"
+
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	controller "k8s.io/controller-manager/controller"
+	"k8s.io/controller-manager/pkg/healthz"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ControllerName  string = "cloud-xenorchestra-health-controller"
+	ControllerAlias string = "cloud-xenorchestra-health"
+)
+
+// Controller mounts the health check of the connection to Xen Orchestra on the /healthz endpoint
+// of the cloud controller manager, under the controller name. The checks run in the cloud provider.
+type Controller struct {
+	checker healthz.UnnamedHealthChecker
+}
+
+var _ controller.HealthCheckable = &Controller{}
+
+func StartXOHealthControllerWrapper(_ app.ControllerInitContext, _ *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	return func(_ context.Context, _ genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		healthCheckable, ok := cloud.(xenorchestra.HealthCheckable)
+		if !ok {
+			klog.Warningf("the cloud provider does not check the connection to Xen Orchestra")
+			return nil, false, nil
+		}
+
+		klog.InfoS("Starting cloud-xenorchestra-health controller", "controller", ControllerName)
+
+		return &Controller{checker: healthCheckable.HealthChecker()}, true, nil
+	}
+}
+
+func (c *Controller) Name() string {
+	return ControllerName
+}
+
+func (c *Controller) HealthChecker() healthz.UnnamedHealthChecker {
+	return c.checker
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// nodeAddressesConfig is the NodeAddresses section of the cloud config.
+type nodeAddressesConfig struct {
+	// PrimaryIPFamily is listed first (IPv4 or IPv6). Defaults to the family of the VM main IP address.
+	PrimaryIPFamily v1.IPFamily `yaml:"primaryIPFamily,omitempty"`
+	// Rules classify the VM addresses, the first matching rule wins.
+	// Addresses matching no rule are reported as ExternalIP.
+	Rules []nodeAddressRule `yaml:"rules,omitempty"`
+}
+
+// Address types of the node address rules.
+const (
+	NodeAddressTypeInternalIP = "InternalIP"
+	NodeAddressTypeExternalIP = "ExternalIP"
+	NodeAddressTypeExclude    = "Exclude"
+)
+
+// nodeAddressRule maps the VM addresses to a node address type.
+// Every criteria set must match, an address matches a criteria if it matches any of its values.
+type nodeAddressRule struct {
+	// Type is InternalIP, ExternalIP or Exclude.
+	Type string `yaml:"type"`
+	// Networks are XO network UUIDs or name labels.
+	Networks []string `yaml:"networks,omitempty"`
+	// Devices are VIF device indexes.
+	Devices []int `yaml:"devices,omitempty"`
+	// CIDRs are IP ranges.
+	CIDRs []string `yaml:"cidrs,omitempty"`
+}
+
+func (c *nodeAddressesConfig) validate() error {
+	switch c.PrimaryIPFamily {
+	case "", v1.IPv4Protocol, v1.IPv6Protocol:
+	default:
+		return fmt.Errorf("primaryIPFamily must be %s or %s, got %q", v1.IPv4Protocol, v1.IPv6Protocol, c.PrimaryIPFamily)
+	}
+
+	for idx, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rules[%d]: %v", idx, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *nodeAddressRule) validate() error {
+	switch r.Type {
+	case NodeAddressTypeInternalIP, NodeAddressTypeExternalIP, NodeAddressTypeExclude:
+	default:
+		return fmt.Errorf("type must be %s, %s or %s, got %q",
+			NodeAddressTypeInternalIP, NodeAddressTypeExternalIP, NodeAddressTypeExclude, r.Type)
+	}
+
+	for _, cidr := range r.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q: %v", cidr, err)
+		}
+	}
+
+	for _, device := range r.Devices {
+		if device < 0 {
+			return fmt.Errorf("invalid device %d", device)
+		}
+	}
+
+	return nil
+}
+
+// needsNetworks reports whether a rule matches on the XO networks.
+func (c *nodeAddressesConfig) needsNetworks() bool {
+	return slices.ContainsFunc(c.Rules, func(r nodeAddressRule) bool { return len(r.Networks) > 0 })
+}
+
+// matches reports whether the address, attached to the given VIF device and network, matches the rule.
+// The device is -1 and the network is nil when they are unknown.
+func (r *nodeAddressRule) matches(addr netip.Addr, device int, network *client.Network) bool {
+	if len(r.Devices) > 0 && !slices.Contains(r.Devices, device) {
+		return false
+	}
+
+	if len(r.Networks) > 0 && (network == nil ||
+		!slices.ContainsFunc(r.Networks, func(n string) bool { return n == network.Id || n == network.NameLabel })) {
+		return false
+	}
+
+	if len(r.CIDRs) > 0 && !slices.ContainsFunc(r.CIDRs, func(cidr string) bool {
+		prefix, err := netip.ParsePrefix(cidr)
+
+		return err == nil && prefix.Contains(addr)
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// classify returns the type of the address, InternalIP, ExternalIP or Exclude.
+func (c *nodeAddressesConfig) classify(addr netip.Addr, device int, network *client.Network) string {
+	for _, rule := range c.Rules {
+		if rule.matches(addr, device, network) {
+			return rule.Type
+		}
+	}
+
+	return NodeAddressTypeExternalIP
+}
+
+// vmAddress is an address reported by the guest tools.
+type vmAddress struct {
+	addr   netip.Addr
+	device int
+	index  int
+}
+
+// getVMAddresses returns the addresses reported by the guest tools of the VM, from the inventory snapshot of the context first.
+// The REST API does not expose them yet, so they are read with the JSON-RPC client.
+func getVMAddresses(ctx context.Context, endpoint *xoEndpoint, vmRef *payloads.VM) (map[string]string, error) {
+	if snapshot := inventoryFrom(ctx).of(endpoint); snapshot != nil && snapshot.addresses != nil {
+		if addresses, ok := snapshot.addresses[vmRef.ID]; ok {
+			return addresses, nil
+		}
+	}
+
+	addresses, err := endpoint.cache.getVMAddresses(ctx, vmRef.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the addresses of VM %s: %w", vmRef.ID, classifyXOError(err))
+	}
+
+	return addresses, nil
+}
+
+// fetchVMAddresses returns the guest tools addresses of all the VMs.
+// The JSON-RPC API lists all the objects of a type in a single request, as the per VM lookups do.
+func fetchVMAddresses(v1Client client.XOClient) (map[uuid.UUID]map[string]string, error) {
+	if v1Client == nil {
+		return nil, &xoError{class: ErrXOTransient, err: errV1ClientUnavailable}
+	}
+
+	vms := map[string]client.Vm{}
+	if err := v1Client.GetAllObjectsOfType(client.Vm{}, &vms); err != nil {
+		return nil, fmt.Errorf("failed to get the VMs: %w", classifyXOError(err))
+	}
+
+	addresses := map[uuid.UUID]map[string]string{}
+
+	for _, vm := range vms {
+		if id, err := uuid.FromString(vm.Id); err == nil {
+			addresses[id] = vm.Addresses
+		}
+	}
+
+	return addresses, nil
+}
+
+// getVMNetworks returns the XO networks of the VM, by VIF device index, from the inventory snapshot of the context first.
+func getVMNetworks(ctx context.Context, endpoint *xoEndpoint, vmRef *payloads.VM) (map[int]*client.Network, error) {
+	if snapshot := inventoryFrom(ctx).of(endpoint); snapshot != nil && snapshot.networks != nil {
+		if networks, ok := snapshot.networks[vmRef.ID]; ok {
+			return networks, nil
+		}
+	}
+
+	networks, err := endpoint.cache.getVMNetworks(ctx, vmRef.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the networks of VM %s: %w", vmRef.ID, classifyXOError(err))
+	}
+
+	return networks, nil
+}
+
+// fetchVMNetworks returns the XO networks of all the VMs by VIF device, with one request for the VIFs and one for the networks.
+func fetchVMNetworks(v1Client client.XOClient) (map[uuid.UUID]map[int]*client.Network, error) {
+	if v1Client == nil {
+		return nil, &xoError{class: ErrXOTransient, err: errV1ClientUnavailable}
+	}
+
+	vifs := map[string]client.VIF{}
+	if err := v1Client.GetAllObjectsOfType(client.VIF{}, &vifs); err != nil {
+		return nil, fmt.Errorf("failed to get the VIFs: %w", classifyXOError(err))
+	}
+
+	xoNetworks := map[string]client.Network{}
+	if err := v1Client.GetAllObjectsOfType(client.Network{}, &xoNetworks); err != nil {
+		return nil, fmt.Errorf("failed to get the networks: %w", classifyXOError(err))
+	}
+
+	networks := map[uuid.UUID]map[int]*client.Network{}
+
+	for _, vif := range vifs {
+		id, err := uuid.FromString(vif.VmId)
+		if err != nil {
+			continue
+		}
+
+		device, err := strconv.Atoi(vif.Device)
+		if err != nil {
+			continue
+		}
+
+		network := &client.Network{Id: vif.Network}
+		if xoNetwork, ok := xoNetworks[vif.Network]; ok {
+			network = &xoNetwork
+		}
+
+		if networks[id] == nil {
+			networks[id] = map[int]*client.Network{}
+		}
+
+		networks[id][device] = network
+	}
+
+	return networks, nil
+}
+
+// vmAddressDevices returns the VIF device index of the VM addresses.
+func vmAddressDevices(addresses map[string]string) map[netip.Addr]int {
+	devices := map[netip.Addr]int{}
+
+	for key, value := range addresses {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.Split(key, "/")
+		if len(parts) != 3 {
+			continue
+		}
+
+		device, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+
+		if d, ok := devices[addr.Unmap()]; !ok || device < d {
+			devices[addr.Unmap()] = device
+		}
+	}
+
+	return devices
+}
+
+// sortVMAddresses returns the VM addresses in a deterministic order: the main IP address first,
+// then the addresses of the primary family, then the other family, each by device and index.
+// Loopback and link-local addresses are skipped.
+func sortVMAddresses(mainIPAddress string, addresses map[string]string, primary v1.IPFamily) []netip.Addr {
+	main, _ := netip.ParseAddr(mainIPAddress)
+
+	if primary == "" {
+		primary = v1.IPv4Protocol
+		if main.IsValid() {
+			primary = addressFamily(main)
+		}
+	}
+
+	parsed := []vmAddress{}
+
+	for key, value := range addresses {
+		addr, err := netip.ParseAddr(value)
+		if err != nil || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			continue
+		}
+
+		// key has the following format "{device}/(ipv4|ipv6)/{index}"
+		parts := strings.Split(key, "/")
+		if len(parts) != 3 {
+			continue
+		}
+
+		device, _ := strconv.Atoi(parts[0])
+		index, _ := strconv.Atoi(parts[2])
+
+		parsed = append(parsed, vmAddress{addr: addr.Unmap(), device: device, index: index})
+	}
+
+	rank := func(a vmAddress) int {
+		switch {
+		case a.addr == main:
+			return 0
+		case addressFamily(a.addr) == primary:
+			return 1
+		}
+
+		return 2
+	}
+
+	slices.SortFunc(parsed, func(a, b vmAddress) int {
+		if r := rank(a) - rank(b); r != 0 {
+			return r
+		}
+
+		if a.device != b.device {
+			return a.device - b.device
+		}
+
+		if a.index != b.index {
+			return a.index - b.index
+		}
+
+		return a.addr.Compare(b.addr)
+	})
+
+	result := []netip.Addr{}
+	if main.IsValid() {
+		result = append(result, main)
+	}
+
+	for _, a := range parsed {
+		if !slices.Contains(result, a.addr) {
+			result = append(result, a.addr)
+		}
+	}
+
+	return result
+}
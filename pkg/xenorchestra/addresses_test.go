@@ -0,0 +1,264 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSortVMAddresses(t *testing.T) {
+	addresses := map[string]string{
+		"1/ipv6/0": "2001:db8:1::10",
+		"0/ipv6/1": "2001:db8::10",
+		"0/ipv6/0": "fe80::1",
+		"1/ipv4/0": "192.168.1.10",
+		"0/ipv4/1": "10.0.0.11",
+		"0/ipv4/0": "10.0.0.10",
+		"2/ipv4/0": "127.0.0.1",
+		"3/ipv4/0": "not-an-ip",
+	}
+
+	tests := []struct {
+		name     string
+		main     string
+		primary  v1.IPFamily
+		expected []string
+	}{
+		{
+			name:     "ipv4 main address",
+			main:     "192.168.1.10",
+			expected: []string{"192.168.1.10", "10.0.0.10", "10.0.0.11", "2001:db8::10", "2001:db8:1::10"},
+		},
+		{
+			name:     "ipv6 main address",
+			main:     "2001:db8:1::10",
+			expected: []string{"2001:db8:1::10", "2001:db8::10", "10.0.0.10", "10.0.0.11", "192.168.1.10"},
+		},
+		{
+			name:     "primary family overrides the main address family",
+			main:     "10.0.0.10",
+			primary:  v1.IPv6Protocol,
+			expected: []string{"10.0.0.10", "2001:db8::10", "2001:db8:1::10", "10.0.0.11", "192.168.1.10"},
+		},
+		{
+			name:     "no main address",
+			expected: []string{"10.0.0.10", "10.0.0.11", "192.168.1.10", "2001:db8::10", "2001:db8:1::10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := []netip.Addr{}
+			for _, addr := range tt.expected {
+				expected = append(expected, netip.MustParseAddr(addr))
+			}
+
+			assert.Equal(t, expected, sortVMAddresses(tt.main, addresses, tt.primary))
+		})
+	}
+
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, sortVMAddresses("10.0.0.1", nil, ""))
+}
+
+func TestNodeAddressesConfigValidate(t *testing.T) {
+	assert.NoError(t, (&nodeAddressesConfig{Rules: []nodeAddressRule{
+		{Type: NodeAddressTypeInternalIP, CIDRs: []string{"10.0.0.0/8", "fd00::/8"}},
+		{Type: NodeAddressTypeExclude, Devices: []int{2}},
+	}}).validate())
+
+	assert.EqualError(t, (&nodeAddressesConfig{PrimaryIPFamily: "IPv5"}).validate(), `primaryIPFamily must be IPv4 or IPv6, got "IPv5"`)
+	assert.EqualError(t, (&nodeAddressesConfig{Rules: []nodeAddressRule{{Type: "Private"}}}).validate(),
+		`rules[0]: type must be InternalIP, ExternalIP or Exclude, got "Private"`)
+	assert.ErrorContains(t, (&nodeAddressesConfig{Rules: []nodeAddressRule{{Type: NodeAddressTypeExclude, CIDRs: []string{"10.0.0.0"}}}}).validate(),
+		`rules[0]: invalid cidr "10.0.0.0"`)
+}
+
+func TestNodeAddressesClassify(t *testing.T) {
+	storage := &client.Network{Id: "5d3a9d2e-8b4a-4f1c-9e6d-2a7b3c4d5e6f", NameLabel: "storage"}
+	lan := &client.Network{Id: "0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e", NameLabel: "lan"}
+
+	config := &nodeAddressesConfig{Rules: []nodeAddressRule{
+		{Type: NodeAddressTypeExclude, Networks: []string{"storage"}},
+		{Type: NodeAddressTypeInternalIP, Networks: []string{lan.Id}, CIDRs: []string{"10.0.0.0/8"}},
+		{Type: NodeAddressTypeInternalIP, Devices: []int{3}},
+	}}
+
+	tests := []struct {
+		name     string
+		addr     string
+		device   int
+		network  *client.Network
+		expected string
+	}{
+		{name: "network name label", addr: "10.1.0.10", device: 1, network: storage, expected: NodeAddressTypeExclude},
+		{name: "network uuid and cidr", addr: "10.0.0.10", device: 0, network: lan, expected: NodeAddressTypeInternalIP},
+		{name: "network without matching cidr", addr: "192.168.1.10", device: 0, network: lan, expected: NodeAddressTypeExternalIP},
+		{name: "device", addr: "192.168.3.10", device: 3, expected: NodeAddressTypeInternalIP},
+		{name: "unknown network", addr: "10.0.0.10", device: -1, expected: NodeAddressTypeExternalIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, config.classify(netip.MustParseAddr(tt.addr), tt.device, tt.network))
+		})
+	}
+}
+
+func TestVMAddressDevices(t *testing.T) {
+	devices := vmAddressDevices(map[string]string{
+		"0/ipv4/0": "10.0.0.10",
+		"1/ipv4/0": "192.168.1.10",
+		"2/ipv4/0": "10.0.0.10",
+		"bad":      "10.0.0.20",
+	})
+
+	assert.Equal(t, map[netip.Addr]int{
+		netip.MustParseAddr("10.0.0.10"):    0,
+		netip.MustParseAddr("192.168.1.10"): 1,
+	}, devices)
+}
+
+func TestInstanceAddressesRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	v1Client := &fakeV1Client{
+		vms: map[string]*client.Vm{
+			vmPool1Node1ID: {
+				Id: vmPool1Node1ID,
+				Addresses: map[string]string{
+					"0/ipv4/0": "10.0.0.10",
+					"1/ipv4/0": "192.168.1.10",
+					"2/ipv4/0": "172.16.0.10",
+				},
+			},
+		},
+		vifs: map[string][]client.VIF{
+			vmPool1Node1ID: {
+				{Device: "0", Network: "net-lan"},
+				{Device: "1", Network: "net-public"},
+				{Device: "2", Network: "net-storage"},
+			},
+		},
+		networks: map[string]*client.Network{
+			"net-lan":     {Id: "net-lan", NameLabel: "lan"},
+			"net-public":  {Id: "net-public", NameLabel: "public"},
+			"net-storage": {Id: "net-storage", NameLabel: "storage"},
+		},
+	}
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(v1Client).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{NodeAddresses: nodeAddressesConfig{Rules: []nodeAddressRule{
+		{Type: NodeAddressTypeInternalIP, Networks: []string{"lan"}},
+		{Type: NodeAddressTypeExclude, Networks: []string{"net-storage"}},
+	}}})
+
+	vmRef := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID), MainIpAddress: "192.168.1.10"}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: pool1Node1}}
+
+	addresses, err := i.instanceAddresses(t.Context(), i.endpoints.list[0], node, vmRef)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.NodeAddress{
+		{Type: v1.NodeExternalIP, Address: "192.168.1.10"},
+		{Type: v1.NodeInternalIP, Address: "10.0.0.10"},
+		{Type: v1.NodeHostName, Address: pool1Node1},
+	}, addresses)
+
+	// The VMs, the VIFs and the networks are each listed once
+	assert.Equal(t, 3, v1Client.lists)
+	assert.Equal(t, 0, v1Client.lookups)
+}
+
+func TestInstanceAddressesCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	v1Client := &fakeV1Client{vms: map[string]*client.Vm{
+		vmPool1Node1ID: {Id: vmPool1Node1ID, Addresses: map[string]string{"0/ipv4/0": "10.0.0.10"}},
+		vmPool2Node1ID: {Id: vmPool2Node1ID, Addresses: map[string]string{"0/ipv4/0": "10.0.0.11"}},
+	}}
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(v1Client).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{})
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: pool1Node1}}
+
+	// The addresses of all the VMs are fetched with a single request
+	for _, id := range []string{vmPool1Node1ID, vmPool2Node1ID, vmPool1Node1ID} {
+		addresses, err := i.instanceAddresses(t.Context(), i.endpoints.list[0], node, &payloads.VM{ID: uuid.FromStringOrNil(id)})
+		require.NoError(t, err)
+		assert.Equal(t, v1Client.vms[id].Addresses["0/ipv4/0"], addresses[0].Address)
+	}
+
+	assert.Equal(t, 1, v1Client.lists)
+	assert.Equal(t, 0, v1Client.lookups)
+}
+
+func TestInstanceAddressesErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The JSON-RPC client could not connect, the addresses are not replaced with a partial list
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(nil).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{})
+	vmRef := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID), MainIpAddress: "10.0.0.10"}
+
+	_, err := i.instanceAddresses(t.Context(), i.endpoints.list[0], &v1.Node{}, vmRef)
+	assert.ErrorIs(t, err, ErrXOTransient)
+
+	// The VIFs cannot be listed, the network rules are not applied without the networks
+	mockLib = mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(&failingVIFsV1Client{fakeV1Client: fakeV1Client{vms: map[string]*client.Vm{
+		vmPool1Node1ID: {Id: vmPool1Node1ID, Addresses: map[string]string{"0/ipv4/0": "10.0.0.10"}},
+	}}}).AnyTimes()
+
+	i = newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{NodeAddresses: nodeAddressesConfig{Rules: []nodeAddressRule{
+		{Type: NodeAddressTypeInternalIP, Networks: []string{"lan"}},
+	}}})
+
+	_, err = i.instanceAddresses(t.Context(), i.endpoints.list[0], &v1.Node{}, vmRef)
+	assert.ErrorIs(t, err, ErrXOTransient)
+	assert.ErrorContains(t, err, "failed to get the networks of VM")
+}
+
+// failingVIFsV1Client fails to list the VIFs as an unreachable Xen Orchestra.
+type failingVIFsV1Client struct {
+	fakeV1Client
+}
+
+func (c *failingVIFsV1Client) GetAllObjectsOfType(obj client.XoObject, response interface{}) error {
+	if _, ok := obj.(client.VIF); ok {
+		return errUnreachable
+	}
+
+	return c.fakeV1Client.GetAllObjectsOfType(obj, response)
+}
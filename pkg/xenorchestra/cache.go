@@ -0,0 +1,269 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"golang.org/x/sync/singleflight"
+
+	v1 "github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// Default cache TTLs. The hosts and pools rarely change, the VMs are refreshed
+// often so that a deleted or stopped VM is noticed quickly.
+const (
+	defaultCacheVMTTL   = 15 * time.Second
+	defaultCacheHostTTL = 5 * time.Minute
+	defaultCachePoolTTL = 5 * time.Minute
+)
+
+var cacheRequests = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "xenorchestra",
+		Subsystem:      "cache",
+		Name:           "requests_total",
+		Help:           "Number of Xen Orchestra object lookups served by the cache, by object type and result (hit, miss or inventory).",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"type", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(cacheRequests)
+}
+
+// cacheConfig is the Cache section of the cloud config.
+type cacheConfig struct {
+	// Disabled turns the cache off, every lookup reaches Xen Orchestra.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// VMTTL defaults to 15s.
+	VMTTL time.Duration `yaml:"vmTTL,omitempty"`
+	// HostTTL defaults to 5m.
+	HostTTL time.Duration `yaml:"hostTTL,omitempty"`
+	// PoolTTL defaults to 5m.
+	PoolTTL time.Duration `yaml:"poolTTL,omitempty"`
+}
+
+func (c *cacheConfig) validate() error {
+	if c.VMTTL < 0 || c.HostTTL < 0 || c.PoolTTL < 0 {
+		return fmt.Errorf("TTLs must be positive")
+	}
+
+	return nil
+}
+
+// xoCache caches the VM, host and pool lookups of the instances, and the guest tools addresses of the VMs.
+// Concurrent lookups of the same object share a single request, and errors are never cached.
+type xoCache struct {
+	c *xok8s.XoClient
+
+	vms   *ttlCache[*payloads.VM]
+	hosts *ttlCache[*payloads.Host]
+	pools *ttlCache[*payloads.Pool]
+	// addresses are fetched for all the VMs at once, the JSON-RPC API downloads them all for a single VM anyway.
+	addresses *ttlCache[map[string]string]
+	networks  *ttlCache[map[int]*v1.Network]
+	bulk      singleflight.Group
+}
+
+func newXOCache(client *xok8s.XoClient, config cacheConfig) *xoCache {
+	ttl := func(value, def time.Duration) time.Duration {
+		switch {
+		case config.Disabled:
+			return 0
+		case value == 0:
+			return def
+		}
+
+		return value
+	}
+
+	return &xoCache{
+		c:     client,
+		vms:   newTTLCache[*payloads.VM]("vm", ttl(config.VMTTL, defaultCacheVMTTL)),
+		hosts: newTTLCache[*payloads.Host]("host", ttl(config.HostTTL, defaultCacheHostTTL)),
+		pools: newTTLCache[*payloads.Pool]("pool", ttl(config.PoolTTL, defaultCachePoolTTL)),
+		// The addresses change with the VMs
+		addresses: newTTLCache[map[string]string]("addresses", ttl(config.VMTTL, defaultCacheVMTTL)),
+		networks:  newTTLCache[map[int]*v1.Network]("networks", ttl(config.VMTTL, defaultCacheVMTTL)),
+	}
+}
+
+func (x *xoCache) getVM(ctx context.Context, id uuid.UUID) (*payloads.VM, error) {
+	return x.vms.get(ctx, id, x.c.Client.VM().GetByID)
+}
+
+func (x *xoCache) getHost(ctx context.Context, id uuid.UUID) (*payloads.Host, error) {
+	return x.hosts.get(ctx, id, x.c.Client.Host().Get)
+}
+
+func (x *xoCache) getPool(ctx context.Context, id uuid.UUID) (*payloads.Pool, error) {
+	return x.pools.get(ctx, id, x.c.Client.Pool().Get)
+}
+
+// getVMAddresses returns the guest tools addresses of the VM. A miss fetches the addresses of all the VMs
+// of the endpoint, and caches them.
+func (x *xoCache) getVMAddresses(ctx context.Context, id uuid.UUID) (map[string]string, error) {
+	return x.addresses.get(ctx, id, func(context.Context, uuid.UUID) (map[string]string, error) {
+		all, err, _ := x.bulk.Do("addresses", func() (any, error) {
+			return fetchVMAddresses(x.c.Client.V1Client())
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		addresses := all.(map[uuid.UUID]map[string]string)
+		x.addresses.setAll(addresses)
+
+		return addresses[id], nil
+	})
+}
+
+// getVMNetworks returns the XO networks of the VM by VIF device. A miss fetches the networks of all the VMs
+// of the endpoint, and caches them.
+func (x *xoCache) getVMNetworks(ctx context.Context, id uuid.UUID) (map[int]*v1.Network, error) {
+	return x.networks.get(ctx, id, func(context.Context, uuid.UUID) (map[int]*v1.Network, error) {
+		all, err, _ := x.bulk.Do("networks", func() (any, error) {
+			return fetchVMNetworks(x.c.Client.V1Client())
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		networks := all.(map[uuid.UUID]map[int]*v1.Network)
+		x.networks.setAll(networks)
+
+		return networks[id], nil
+	})
+}
+
+// invalidateVM drops the VM, it is used after the CCM changed it.
+func (x *xoCache) invalidateVM(id uuid.UUID) {
+	x.vms.invalidate(id)
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache is a cache of one object type. A zero TTL disables it.
+type ttlCache[V any] struct {
+	name string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	entries   map[uuid.UUID]cacheEntry[V]
+	lastPrune time.Time
+	group     singleflight.Group
+}
+
+func newTTLCache[V any](name string, ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{
+		name:    name,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[uuid.UUID]cacheEntry[V]{},
+	}
+}
+
+// get returns the cached object, or fetches it. Concurrent fetches of the same object are deduplicated.
+func (c *ttlCache[V]) get(ctx context.Context, id uuid.UUID, fetch func(context.Context, uuid.UUID) (V, error)) (V, error) {
+	if c.ttl == 0 {
+		return fetch(ctx, id)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	c.mu.Unlock()
+
+	if ok && c.now().Before(entry.expires) {
+		cacheRequests.WithLabelValues(c.name, "hit").Inc()
+
+		return entry.value, nil
+	}
+
+	cacheRequests.WithLabelValues(c.name, "miss").Inc()
+
+	value, err, _ := c.group.Do(id.String(), func() (any, error) {
+		value, err := fetch(ctx, id)
+		if err != nil {
+			// Do not serve a stale object after a failed lookup, the object may be gone
+			c.invalidate(id)
+
+			return value, err
+		}
+
+		c.set(id, value)
+
+		return value, nil
+	})
+
+	return value.(V), err
+}
+
+func (c *ttlCache[V]) set(id uuid.UUID, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.entries[id] = cacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+
+	// Drop the expired entries, so that deleted objects do not stay in memory
+	if now.Sub(c.lastPrune) > c.ttl {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+
+		c.lastPrune = now
+	}
+}
+
+// setAll caches the objects fetched in bulk.
+func (c *ttlCache[V]) setAll(values map[uuid.UUID]V) {
+	if c.ttl == 0 {
+		return
+	}
+
+	for id, value := range values {
+		c.set(id, value)
+	}
+}
+
+func (c *ttlCache[V]) invalidate(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; ok {
+		klog.V(5).InfoS("cache entry invalidated", "type", c.name, "id", id.String())
+
+		delete(c.entries, id)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	cache := newTTLCache[string]("test-ttl", time.Minute)
+	cache.now = func() time.Time { return now }
+
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+	calls := 0
+	fetch := func(_ context.Context, _ uuid.UUID) (string, error) {
+		calls++
+
+		return "value", nil
+	}
+
+	for range 3 {
+		value, err := cache.get(t.Context(), id, fetch)
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(2 * time.Minute)
+
+	_, err := cache.get(t.Context(), id, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	hits, err := testutil.GetCounterMetricValue(cacheRequests.WithLabelValues("test-ttl", "hit"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), hits)
+
+	misses, err := testutil.GetCounterMetricValue(cacheRequests.WithLabelValues("test-ttl", "miss"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), misses)
+}
+
+func TestTTLCacheErrorsInvalidate(t *testing.T) {
+	now := time.Now()
+	cache := newTTLCache[string]("test-errors", time.Minute)
+	cache.now = func() time.Time { return now }
+
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+
+	_, err := cache.get(t.Context(), id, func(_ context.Context, _ uuid.UUID) (string, error) { return "old", nil })
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+
+	_, err = cache.get(t.Context(), id, func(_ context.Context, _ uuid.UUID) (string, error) {
+		return "", errors.New("API error: 503 Service Unavailable")
+	})
+	assert.Error(t, err)
+	assert.NotContains(t, cache.entries, id, "a failed lookup drops the entry")
+
+	value, err := cache.get(t.Context(), id, func(_ context.Context, _ uuid.UUID) (string, error) { return "new", nil })
+	require.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
+
+func TestTTLCacheSingleflight(t *testing.T) {
+	cache := newTTLCache[string]("test-singleflight", time.Minute)
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+	fetch := func(_ context.Context, _ uuid.UUID) (string, error) {
+		calls.Add(1)
+		<-release
+
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Go(func() {
+			value, err := cache.get(t.Context(), id, fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		})
+	}
+
+	// Let the goroutines join the in-flight lookup
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestTTLCacheDisabled(t *testing.T) {
+	cache := newXOCache(nil, cacheConfig{Disabled: true})
+	assert.Zero(t, cache.vms.ttl)
+
+	cache = newXOCache(nil, cacheConfig{HostTTL: time.Hour})
+	assert.Equal(t, defaultCacheVMTTL, cache.vms.ttl)
+	assert.Equal(t, time.Hour, cache.hosts.ttl)
+
+	calls := 0
+	disabled := newTTLCache[string]("test-disabled", 0)
+
+	for range 2 {
+		_, err := disabled.get(t.Context(), uuid.Nil, func(_ context.Context, _ uuid.UUID) (string, error) {
+			calls++
+
+			return "", nil
+		})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, calls)
+	assert.EqualError(t, (&cacheConfig{VMTTL: -time.Second}).validate(), "TTLs must be positive")
+}
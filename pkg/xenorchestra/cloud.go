@@ -21,10 +21,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"time"
+	"unicode/utf8"
 
 	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 
@@ -33,8 +36,10 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/controller-manager/pkg/healthz"
 	"k8s.io/klog/v2"
 )
 
@@ -50,11 +55,29 @@ const (
 	podUIDEnv                        = "POD_UID"
 	eventActionCheckClient           = "CheckClient"
 	eventReasonFailedToCheckClient   = "FailedToCheckClient"
+	eventReasonRecovered             = "Recovered"
+	eventActionFailover              = "Failover"
+	eventReasonFailedOver            = "FailedOver"
+	eventReasonFailedBack            = "FailedBack"
+
+	// eventNoteLengthLimit is the maximum size of an event note.
+	eventNoteLengthLimit = 1024
 )
 
 type cloud struct {
-	client      *xok8s.XoClient
-	instancesV2 cloudprovider.InstancesV2
+	endpoints    *xoEndpoints
+	instances    *instances
+	loadBalancer loadBalancer
+	routes       *routes
+	clusterID    string
+
+	// checkBackoff paces the client checks while Xen Orchestra is unavailable at startup.
+	checkBackoff wait.Backoff
+	// reloader is nil when the cloud config is not read from a file.
+	reloader *configReloader
+	// tracing is nil when the spans are not exported.
+	tracing *tracingConfig
+	health  *healthChecker
 
 	ctx  context.Context //nolint:containedctx
 	stop func()
@@ -63,38 +86,78 @@ type cloud struct {
 func init() {
 	cloudprovider.RegisterCloudProvider(xok8s.ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
 		if config != nil {
-			cfg, err := xok8s.ReadCloudConfig(config)
+			cfg, err := readCloudConfig(config)
 			if err != nil {
 				klog.ErrorS(err, "failed to read config")
 
 				return nil, err
 			}
 
-			return newCloud(&cfg)
+			c, err := newCloud(&cfg)
+			if err != nil {
+				return nil, err
+			}
+
+			// The cloud config file is reloaded when it changes, for example when the token is rotated
+			if file, ok := config.(*os.File); ok {
+				c.reloader = newConfigReloader(file.Name(), cfg, c.endpoints)
+			}
+
+			return c, nil
 		}
 
-		cfg, err := xok8s.LoadXOConfigFromEnv()
+		cfg, err := loadCloudConfigFromEnv()
 		if err != nil {
 			klog.ErrorS(err, "failed to read config from environment")
 
 			return nil, err
 		}
 
-		return newCloud(&cfg)
+		c, err := newCloud(&cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
 	})
 }
 
-func newCloud(config *xok8s.XoConfig) (cloudprovider.Interface, error) {
-	client, err := xok8s.NewXOClient(config)
+func newCloud(config *cloudConfig) (*cloud, error) {
+	endpoints, err := buildXOEndpoints(config)
 	if err != nil {
 		return nil, err
 	}
 
-	instancesInterface := newInstances(client)
+	instancesInterface := newEndpointInstances(endpoints, config)
+
+	// The load balancer and route VMs are managed through the first endpoint
+	client := endpoints.list[0].c
+
+	lb, err := newLoadBalancer(config.LoadBalancer, client)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRoutes(config.Routes, client)
+	if err != nil {
+		return nil, err
+	}
 
 	return &cloud{
-		client:      client,
-		instancesV2: instancesInterface,
+		endpoints:    endpoints,
+		instances:    instancesInterface,
+		loadBalancer: lb,
+		routes:       r,
+		clusterID:    config.ClusterID,
+		tracing:      config.Tracing,
+		health:       newHealthChecker(endpoints, config.HealthCheck),
+		checkBackoff: wait.Backoff{
+			Duration: 5 * time.Second,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+			Cap:      5 * time.Minute,
+		},
 	}, nil
 }
 
@@ -109,14 +172,43 @@ func (c *cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder,
 	c.ctx = ctx
 	c.stop = cancel
 
-	err := c.client.CheckClient(ctx)
+	if err := startTracing(ctx, c.tracing); err != nil {
+		klog.ErrorS(err, "failed to start tracing, the spans are dropped")
+	}
+
+	kubeClient := clientBuilder.ClientOrDie(cloudControllerManagerClientName)
+
+	c.startFailover(ctx, kubeClient)
+
+	if c.reloader != nil {
+		go c.reloader.run(ctx, kubeClient)
+	}
+
+	go c.health.run(ctx)
+
+	err := c.checkClients(ctx)
 	if err != nil {
-		klog.ErrorS(err, "failed to check Xen Orchestra client")
-		kubeClient := clientBuilder.ClientOrDie(cloudControllerManagerClientName)
+		klog.ErrorS(err, "failed to check Xen Orchestra client, retrying in degraded mode")
 		if eventErr := recordCloudProviderInitializationFailure(ctx, kubeClient, err); eventErr != nil {
 			klog.ErrorS(eventErr, "failed to record Xen Orchestra client check failure event")
 		}
-		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+
+		// The instances are unavailable until the connection succeeds, instead of crash looping during a Xen Orchestra maintenance
+		c.instances.setAvailable(false)
+
+		go c.waitForClients(ctx, kubeClient)
+	} else {
+		go c.checkPermissions(ctx, kubeClient)
+	}
+
+	c.instances.initialize(kubeClient)
+
+	if c.loadBalancer != nil {
+		c.loadBalancer.initialize(kubeClient)
+	}
+
+	if c.routes != nil {
+		c.routes.initialize(kubeClient)
 	}
 
 	// Broadcast the upstream stop signal to all provider-level goroutines
@@ -130,25 +222,116 @@ func (c *cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder,
 	klog.InfoS("Xen Orchestra client initialized")
 }
 
+// waitForClients checks the Xen Orchestra clients with an exponential backoff until the connection succeeds,
+// then makes the instances available again and verifies the token permissions.
+func (c *cloud) waitForClients(ctx context.Context, kubeClient clientset.Interface) {
+	err := wait.ExponentialBackoffWithContext(ctx, c.checkBackoff, func(ctx context.Context) (bool, error) {
+		if err := c.checkClients(ctx); err != nil {
+			klog.V(2).InfoS("Xen Orchestra is still unavailable", "err", err)
+
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return
+	}
+
+	c.instances.setAvailable(true)
+
+	klog.InfoS("Xen Orchestra client recovered")
+
+	if eventErr := recordCloudProviderEvent(ctx, kubeClient, corev1.EventTypeNormal, eventActionCheckClient, eventReasonRecovered,
+		"Xen Orchestra client recovered"); eventErr != nil {
+		klog.ErrorS(eventErr, "failed to record Xen Orchestra client recovery event")
+	}
+
+	c.checkPermissions(ctx, kubeClient)
+}
+
+// checkClients verifies the connection to each Xen Orchestra endpoint.
+func (c *cloud) checkClients(ctx context.Context) error {
+	for _, endpoint := range c.endpoints.list {
+		start := time.Now()
+		err := endpoint.c.CheckClient(ctx)
+		observeAPIRequest(endpoint.String(), operationCheck, start, err)
+
+		if err != nil {
+			if len(c.endpoints.list) > 1 {
+				return fmt.Errorf("endpoint %s: %v", endpoint, err)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startFailover records an event when an endpoint switches to another URL, and starts the health probes of the URLs.
+func (c *cloud) startFailover(ctx context.Context, kubeClient clientset.Interface) {
+	for _, endpoint := range c.endpoints.list {
+		endpoint.startFailover(ctx, func(from, to string, err error) {
+			eventType, reason := corev1.EventTypeNormal, eventReasonFailedBack
+			note := fmt.Sprintf("Endpoint %s failed back from %s to %s", endpoint, from, to)
+
+			if err != nil {
+				eventType, reason = corev1.EventTypeWarning, eventReasonFailedOver
+				note = fmt.Sprintf("Endpoint %s failed over from %s to %s: %v", endpoint, from, to, err)
+			}
+
+			if eventErr := recordCloudProviderEvent(ctx, kubeClient, eventType, eventActionFailover, reason, note); eventErr != nil {
+				klog.ErrorS(eventErr, "failed to record Xen Orchestra failover event", "endpoint", endpoint)
+			}
+		})
+	}
+}
+
+// recordCloudProviderInitializationFailure records a failed client check, or the missing permissions of the token.
 func recordCloudProviderInitializationFailure(ctx context.Context, kubeClient clientset.Interface, err error) error {
+	var missingPermissions *missingPermissionsError
+	if errors.As(err, &missingPermissions) {
+		return recordCloudProviderEvent(ctx, kubeClient, corev1.EventTypeWarning, eventActionCheckPermissions, eventReasonMissingPermissions,
+			fmt.Sprintf("Xen Orchestra token is missing permissions: %v", err))
+	}
+
+	return recordCloudProviderEvent(ctx, kubeClient, corev1.EventTypeWarning, eventActionCheckClient, eventReasonFailedToCheckClient,
+		fmt.Sprintf("Failed to check Xen Orchestra client: %v", err))
+}
+
+// recordCloudProviderEvent records an event about the cloud provider itself.
+// The events with the same action and reason are aggregated into a series.
+func recordCloudProviderEvent(ctx context.Context, kubeClient clientset.Interface, eventType, action, reason, note string) error {
+	return recordEvent(ctx, kubeClient, cloudProviderEventObjectReference(), cloudProviderEventNamespace(), 1, eventType, action, reason, note)
+}
+
+// recordEvent records an event regarding an object, the occurrences are added to the series of the previous event
+// with the same action and reason.
+func recordEvent(ctx context.Context, kubeClient clientset.Interface, regarding corev1.ObjectReference, namespace string,
+	occurrences int32, eventType, action, reason, note string,
+) error {
 	eventTime := metav1.MicroTime{Time: time.Now()}
-	regarding := cloudProviderEventObjectReference()
 	event := &eventsv1.Event{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cloudProviderEventName(regarding),
-			Namespace: cloudProviderEventNamespace(),
+			Name:      cloudProviderEventName(regarding, action, reason),
+			Namespace: namespace,
 		},
 		EventTime:           eventTime,
 		ReportingController: ProviderName,
 		ReportingInstance:   cloudProviderEventReportingInstance(),
-		Action:              eventActionCheckClient,
-		Reason:              eventReasonFailedToCheckClient,
+		Action:              action,
+		Reason:              reason,
 		Regarding:           regarding,
-		Note:                fmt.Sprintf("Failed to check Xen Orchestra client: %v", err),
-		Type:                corev1.EventTypeWarning,
+		Note:                truncateEventNote(note),
+		Type:                eventType,
+	}
+
+	if occurrences > 1 {
+		event.Series = &eventsv1.EventSeries{Count: occurrences, LastObservedTime: eventTime}
 	}
 
-	eventsClient := kubeClient.EventsV1().Events(cloudProviderEventNamespace())
+	eventsClient := kubeClient.EventsV1().Events(namespace)
 	if _, createErr := eventsClient.Create(ctx, event, metav1.CreateOptions{}); createErr != nil {
 		if !apierrors.IsAlreadyExists(createErr) {
 			return createErr
@@ -162,11 +345,11 @@ func recordCloudProviderInitializationFailure(ctx context.Context, kubeClient cl
 		event.ResourceVersion = existingEvent.ResourceVersion
 		event.EventTime = existingEvent.EventTime
 		event.Series = &eventsv1.EventSeries{
-			Count:            2,
+			Count:            1 + occurrences,
 			LastObservedTime: eventTime,
 		}
 		if existingEvent.Series != nil {
-			event.Series.Count = existingEvent.Series.Count + 1
+			event.Series.Count = existingEvent.Series.Count + occurrences
 		}
 
 		_, updateErr := eventsClient.Update(ctx, event, metav1.UpdateOptions{})
@@ -176,14 +359,30 @@ func recordCloudProviderInitializationFailure(ctx context.Context, kubeClient cl
 	return nil
 }
 
-func cloudProviderEventName(regarding corev1.ObjectReference) string {
+// truncateEventNote shortens the note to the size accepted by the events API.
+func truncateEventNote(note string) string {
+	if len(note) <= eventNoteLengthLimit {
+		return note
+	}
+
+	note = note[:eventNoteLengthLimit-len("...")]
+
+	// Do not cut a multi-byte character
+	for !utf8.ValidString(note) {
+		note = note[:len(note)-1]
+	}
+
+	return note + "..."
+}
+
+func cloudProviderEventName(regarding corev1.ObjectReference, action, reason string) string {
 	hashInput := fmt.Sprintf("%s/%s/%s/%s/%s/%s",
 		regarding.Namespace,
 		regarding.Kind,
 		regarding.Name,
 		regarding.UID,
-		eventActionCheckClient,
-		eventReasonFailedToCheckClient,
+		action,
+		reason,
 	)
 	hash := sha256.Sum256([]byte(hashInput))
 
@@ -228,13 +427,21 @@ func cloudProviderEventReportingInstance() string {
 // LoadBalancer returns a balancer interface.
 // Also returns true if the interface is supported, false otherwise.
 func (c *cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
-	return nil, false
+	if c.loadBalancer == nil {
+		return nil, false
+	}
+
+	return c.loadBalancer, true
 }
 
 // Instances returns an instances interface.
 // Also returns true if the interface is supported, false otherwise.
 func (c *cloud) Instances() (cloudprovider.Instances, bool) {
-	return nil, false
+	if c.instances == nil {
+		return nil, false
+	}
+
+	return c.instances, true
 }
 
 // InstancesV2 is an implementation for instances and should only be implemented by external cloud providers.
@@ -242,13 +449,21 @@ func (c *cloud) Instances() (cloudprovider.Instances, bool) {
 // API calls to the cloud provider when registering and syncing nodes.
 // Also returns true if the interface is supported, false otherwise.
 func (c *cloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
-	return c.instancesV2, c.instancesV2 != nil
+	if c.instances == nil {
+		return nil, false
+	}
+
+	return c.instances, true
 }
 
 // Zones returns a zones interface.
 // Also returns true if the interface is supported, false otherwise.
 func (c *cloud) Zones() (cloudprovider.Zones, bool) {
-	return nil, false
+	if c.instances == nil {
+		return nil, false
+	}
+
+	return c.instances, true
 }
 
 // Clusters is not implemented.
@@ -256,9 +471,14 @@ func (c *cloud) Clusters() (cloudprovider.Clusters, bool) {
 	return nil, false
 }
 
-// Routes is not implemented.
+// Routes returns a routes interface.
+// Also returns true if the interface is supported, false otherwise.
 func (c *cloud) Routes() (cloudprovider.Routes, bool) {
-	return nil, false
+	if c.routes == nil {
+		return nil, false
+	}
+
+	return c.routes, true
 }
 
 // ProviderName returns the cloud provider ID.
@@ -266,7 +486,12 @@ func (c *cloud) ProviderName() string {
 	return xok8s.ProviderName
 }
 
-// HasClusterID is not implemented.
+// HasClusterID returns true if a ClusterID is configured, the VMs of the cluster are tagged with it.
 func (c *cloud) HasClusterID() bool {
-	return true
+	return c.clusterID != ""
+}
+
+// HealthChecker returns the health check of the connection to Xen Orchestra.
+func (c *cloud) HealthChecker() healthz.UnnamedHealthChecker {
+	return c.health
 }
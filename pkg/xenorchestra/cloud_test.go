@@ -20,25 +20,31 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
 
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
 	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestNewCloudError(t *testing.T) {
-	cloud, err := newCloud(&xok8s.XoConfig{})
+	cloud, err := newCloud(&cloudConfig{})
 	assert.NotNil(t, err)
 	assert.Nil(t, cloud)
 	assert.EqualError(t, err, "url is required")
 }
 
 func TestCloud(t *testing.T) {
-	cfg, err := xok8s.ReadCloudConfig(strings.NewReader(`
+	cfg, err := readCloudConfig(strings.NewReader(`
 url: https://example.com
 insecure: false
 token: "12ABC"
@@ -55,16 +61,16 @@ token: "12ABC"
 	assert.Equal(t, res, false)
 
 	ins, res := cloud.Instances()
-	assert.Nil(t, ins)
-	assert.Equal(t, res, false)
+	assert.NotNil(t, ins)
+	assert.Equal(t, res, true)
 
 	ins2, res := cloud.InstancesV2()
 	assert.NotNil(t, ins2)
 	assert.Equal(t, res, true)
 
 	zone, res := cloud.Zones()
-	assert.Nil(t, zone)
-	assert.Equal(t, res, false)
+	assert.NotNil(t, zone)
+	assert.Equal(t, res, true)
 
 	cl, res := cloud.Clusters()
 	assert.Nil(t, cl)
@@ -78,7 +84,98 @@ token: "12ABC"
 	assert.Equal(t, pName, xok8s.ProviderName)
 
 	clID := cloud.HasClusterID()
-	assert.Equal(t, clID, true)
+	assert.Equal(t, clID, false)
+}
+
+func TestCloudClusterID(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+clusterID: prod-1
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "prod-1", cfg.ClusterID)
+
+	cloud, err := newCloud(&cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, cloud.HasClusterID(), true)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+clusterID: prod=1
+`))
+	assert.EqualError(t, err, `clusterID must not contain spaces, commas or equal signs, got "prod=1"`)
+
+	t.Setenv("XOA_URL", "https://example.com")
+	t.Setenv("XOA_TOKEN", "12ABC")
+	t.Setenv("XOA_CLUSTER_ID", "prod-2")
+
+	cfg, err = loadCloudConfigFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "prod-2", cfg.ClusterID)
+}
+
+func TestCloudLoadBalancer(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+loadBalancer:
+  mode: ipam
+  ipPools:
+    - name: default
+      network: lan
+      ranges:
+        - 10.0.0.100-10.0.0.150
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, LoadBalancerModeIPAM, cfg.LoadBalancer.Mode)
+	assert.Equal(t, "lan", cfg.LoadBalancer.IPPools[0].Network)
+
+	cloud, err := newCloud(&cfg)
+	assert.Nil(t, err)
+
+	lb, res := cloud.LoadBalancer()
+	assert.NotNil(t, lb)
+	assert.Equal(t, res, true)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+loadBalancer:
+  mode: ipam
+`))
+	assert.EqualError(t, err, `loadBalancer: at least one ipPool is required in "ipam" mode`)
+}
+
+func TestCloudRoutes(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+routes:
+  backend: file
+  file:
+    path: /var/lib/xenorchestra-ccm/routes.json
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, RoutesBackendFile, cfg.Routes.Backend)
+
+	cloud, err := newCloud(&cfg)
+	assert.Nil(t, err)
+
+	route, res := cloud.Routes()
+	assert.NotNil(t, route)
+	assert.Equal(t, res, true)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+routes:
+  backend: vm
+  vm:
+    id: router
+`))
+	assert.EqualError(t, err, `routes: vm.id must be a UUID, got "router"`)
 }
 
 func TestRecordCloudProviderInitializationFailure(t *testing.T) {
@@ -145,3 +242,52 @@ func TestRecordCloudProviderInitializationFailureUpdatesExistingEventSeries(t *t
 	assert.NotNil(t, events.Items[0].Series)
 	assert.Equal(t, int32(2), events.Items[0].Series.Count)
 }
+
+func TestWaitForClients(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	gomock.InOrder(
+		mockVM.EXPECT().GetAll(gomock.Any(), 1, "").Return(nil, errUnreachable).Times(2),
+		mockVM.EXPECT().GetAll(gomock.Any(), 1, "").Return([]*payloads.VM{}, nil),
+	)
+
+	// The token permissions are verified once Xen Orchestra recovers
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{}, nil)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+
+	config := &cloudConfig{Events: eventsConfig{Disabled: true}}
+	endpoints := newXOEndpoints([]*xoEndpoint{newXOEndpoint("", &xok8s.XoClient{Client: mockLib}, xok8s.XoConfig{}, config)}, nil)
+
+	c := &cloud{
+		endpoints:    endpoints,
+		instances:    newEndpointInstances(endpoints, config),
+		checkBackoff: wait.Backoff{Duration: time.Millisecond, Steps: 10},
+	}
+
+	// The instances calls are retried later while Xen Orchestra is unavailable
+	c.instances.setAvailable(false)
+
+	_, err := c.instances.InstanceExists(t.Context(), nodeFromProviderID(providerURIPool1Node1))
+	assert.ErrorIs(t, err, ErrXOTransient)
+
+	client := fake.NewClientset()
+	c.waitForClients(t.Context(), client)
+
+	assert.NoError(t, c.instances.checkAvailable())
+
+	events, err := client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, corev1.EventTypeNormal, events.Items[0].Type)
+	assert.Equal(t, eventActionCheckClient, events.Items[0].Action)
+	assert.Equal(t, eventReasonRecovered, events.Items[0].Reason)
+
+	status, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(t.Context(), statusConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, permissionsOK, status.Data["status"])
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/klog/v2"
+)
+
+// clusterIDTagPrefix tags the VMs that belong to a Kubernetes cluster.
+const clusterIDTagPrefix = "k8s-cluster="
+
+// vmClusterID returns the cluster ID the VM is tagged with.
+func vmClusterID(vm *payloads.VM) (string, bool) {
+	for _, tag := range vm.Tags {
+		if clusterID, ok := strings.CutPrefix(tag, clusterIDTagPrefix); ok {
+			return clusterID, true
+		}
+	}
+
+	return "", false
+}
+
+// checkVMCluster returns an error if the VM is tagged for another cluster.
+// Untagged VMs are accepted, they are adopted by tagVMCluster.
+func checkVMCluster(vm *payloads.VM, clusterID string) error {
+	if clusterID == "" {
+		return nil
+	}
+
+	if vmCluster, ok := vmClusterID(vm); ok && vmCluster != clusterID {
+		return fmt.Errorf("vm %s belongs to cluster %q, not %q", vm.ID, vmCluster, clusterID)
+	}
+
+	return nil
+}
+
+// tagVMCluster tags the VM with the cluster ID if it is not tagged yet.
+func tagVMCluster(ctx context.Context, client *xok8s.XoClient, vm *payloads.VM, clusterID string) error {
+	if clusterID == "" {
+		return nil
+	}
+
+	if _, ok := vmClusterID(vm); ok {
+		return nil
+	}
+
+	tag := clusterIDTagPrefix + clusterID
+	if err := client.Client.VM().AddTag(ctx, vm.ID, tag); err != nil {
+		return fmt.Errorf("failed to tag vm %s with %q: %v", vm.ID, tag, err)
+	}
+
+	vm.Tags = append(slices.Clip(vm.Tags), tag)
+
+	klog.V(2).InfoS("Tagged VM with the cluster ID", "vm", vm.NameLabel, "vmID", vm.ID.String(), "clusterID", clusterID)
+
+	return nil
+}
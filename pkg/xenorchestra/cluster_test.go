@@ -0,0 +1,100 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testClusterID = "prod-1"
+
+func TestCheckVMCluster(t *testing.T) {
+	untagged := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID)}
+	owned := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID), Tags: []string{"env=prod", clusterIDTagPrefix + testClusterID}}
+	foreign := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID), Tags: []string{clusterIDTagPrefix + "staging"}}
+
+	assert.NoError(t, checkVMCluster(untagged, testClusterID))
+	assert.NoError(t, checkVMCluster(owned, testClusterID))
+	assert.NoError(t, checkVMCluster(foreign, ""))
+	assert.EqualError(t, checkVMCluster(foreign, testClusterID), `vm `+vmPool1Node1ID+` belongs to cluster "staging", not "prod-1"`)
+}
+
+func TestInstancesClusterID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetByID(gomock.Any(), uuid.FromStringOrNil(vmPool1Node1ID)).Return(&payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool1Node1ID),
+		NameLabel: pool1Node1,
+		PoolID:    uuid.FromStringOrNil(pool1ID),
+		Container: uuid.FromStringOrNil(host1ID),
+	}, nil).AnyTimes()
+	mockVM.EXPECT().GetByID(gomock.Any(), uuid.FromStringOrNil(vmPool2Node1ID)).Return(&payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool2Node1ID),
+		NameLabel: pool2Node1,
+		PoolID:    uuid.FromStringOrNil(pool2ID),
+		Tags:      []string{clusterIDTagPrefix + "staging"},
+	}, nil).AnyTimes()
+	mockVM.EXPECT().AddTag(gomock.Any(), uuid.FromStringOrNil(vmPool1Node1ID), clusterIDTagPrefix+testClusterID).Return(nil).Times(1)
+
+	mockHost := mock_library.NewMockHost(ctrl)
+	mockHost.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&payloads.Host{NameLabel: testHost1}, nil).AnyTimes()
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&payloads.Pool{NameLabel: testPool1}, nil).AnyTimes()
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(&fakeV1Client{}).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{ClusterID: testClusterID})
+
+	// An untagged VM is adopted by the cluster
+	owned := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: pool1Node1}, Spec: v1.NodeSpec{ProviderID: providerURIPool1Node1}}
+	meta, err := i.InstanceMetadata(t.Context(), owned)
+	require.NoError(t, err)
+	assert.Equal(t, providerURIPool1Node1, meta.ProviderID)
+
+	// A VM of another cluster is neither adopted nor reported as deleted
+	foreign := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: pool2Node1}, Spec: v1.NodeSpec{ProviderID: providerURIPool2Node1}}
+	_, err = i.InstanceMetadata(t.Context(), foreign)
+	assert.ErrorContains(t, err, `belongs to cluster "staging"`)
+
+	exists, err := i.InstanceExists(t.Context(), foreign)
+	assert.ErrorContains(t, err, `belongs to cluster "staging"`)
+	assert.False(t, exists)
+
+	foreignByUUID := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: pool2Node1},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: vmPool2Node1ID}},
+	}
+	_, err = i.InstanceMetadata(t.Context(), foreignByUUID)
+	assert.ErrorContains(t, err, "refusing to adopt instance of node "+pool2Node1)
+}
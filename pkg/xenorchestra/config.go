@@ -0,0 +1,175 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+)
+
+// cloudConfig is the CCM configuration. It extends the Xen Orchestra
+// connection settings shared with the other XO components with the
+// sections that are specific to the cloud controller manager.
+type cloudConfig struct {
+	xok8s.XoConfig `yaml:",inline"`
+
+	// ClusterID scopes the VMs managed by the CCM, it is stored as the k8s-cluster=<id> VM tag.
+	ClusterID string `yaml:"clusterID,omitempty"`
+
+	// FailoverURLs are equivalent URLs of the Xen Orchestra instance, for example an XO proxy, used when the url is unreachable.
+	FailoverURLs []string `yaml:"failoverURLs,omitempty"`
+
+	// Endpoints are several Xen Orchestra instances, they replace the top level connection settings.
+	Endpoints []xoEndpointConfig `yaml:"endpoints,omitempty"`
+
+	NodeAddresses  nodeAddressesConfig  `yaml:"nodeAddresses,omitempty"`
+	InstanceType   instanceTypeConfig   `yaml:"instanceType,omitempty"`
+	Topology       topologyConfig       `yaml:"topology,omitempty"`
+	Cache          cacheConfig          `yaml:"cache,omitempty"`
+	Events         eventsConfig         `yaml:"events,omitempty"`
+	RateLimit      rateLimitConfig      `yaml:"rateLimit,omitempty"`
+	CircuitBreaker circuitBreakerConfig `yaml:"circuitBreaker,omitempty"`
+	LoadBalancer   loadBalancerConfig   `yaml:"loadBalancer,omitempty"`
+	Routes         routesConfig         `yaml:"routes,omitempty"`
+	HealthCheck    healthCheckConfig    `yaml:"healthCheck,omitempty"`
+	DeletionGuard  deletionGuardConfig  `yaml:"deletionGuard,omitempty"`
+	Tracing        *tracingConfig       `yaml:"tracing,omitempty"`
+}
+
+// readCloudConfig reads the CCM configuration from a reader.
+// The connection settings are validated by the shared XO config reader.
+func readCloudConfig(config io.Reader) (cloudConfig, error) {
+	data, err := io.ReadAll(config)
+	if err != nil {
+		return cloudConfig{}, err
+	}
+
+	cfg := cloudConfig{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cloudConfig{}, err
+	}
+
+	// The connection settings of the endpoints are validated with the other sections
+	if len(cfg.Endpoints) == 0 {
+		xoConfig, err := xok8s.ReadCloudConfig(bytes.NewReader(data))
+		if err != nil {
+			return cloudConfig{}, err
+		}
+
+		cfg.XoConfig = xoConfig
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cloudConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+// loadCloudConfigFromEnv reads the CCM configuration from the environment.
+// XOA_CLUSTER_ID sets the cluster ID, XOA_FAILOVER_URLS the comma separated failover URLs, the other variables are read by the shared XO config loader.
+func loadCloudConfigFromEnv() (cloudConfig, error) {
+	xoConfig, err := xok8s.LoadXOConfigFromEnv()
+	if err != nil {
+		return cloudConfig{}, err
+	}
+
+	cfg := cloudConfig{
+		XoConfig:  xoConfig,
+		ClusterID: os.Getenv("XOA_CLUSTER_ID"),
+	}
+
+	if urls := os.Getenv("XOA_FAILOVER_URLS"); urls != "" {
+		cfg.FailoverURLs = strings.Split(urls, ",")
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cloudConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+func (c *cloudConfig) validate() error {
+	if strings.ContainsAny(c.ClusterID, " \t\n,=") {
+		return fmt.Errorf("clusterID must not contain spaces, commas or equal signs, got %q", c.ClusterID)
+	}
+
+	if len(c.Endpoints) > 0 && (c.XoConfig != (xok8s.XoConfig{}) || len(c.FailoverURLs) > 0) {
+		return fmt.Errorf("endpoints replace the url and credentials, they must not be set at the top level")
+	}
+
+	if err := validateFailoverURLs(c.URL, c.FailoverURLs); err != nil {
+		return err
+	}
+
+	if err := validateEndpoints(c.Endpoints); err != nil {
+		return fmt.Errorf("endpoints%v", err)
+	}
+
+	if err := c.NodeAddresses.validate(); err != nil {
+		return fmt.Errorf("nodeAddresses: %v", err)
+	}
+
+	if err := c.InstanceType.validate(); err != nil {
+		return fmt.Errorf("instanceType: %v", err)
+	}
+
+	if err := c.Topology.validate(); err != nil {
+		return fmt.Errorf("topology: %v", err)
+	}
+
+	if err := c.Cache.validate(); err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+
+	if err := c.RateLimit.validate(); err != nil {
+		return fmt.Errorf("rateLimit: %v", err)
+	}
+
+	if err := c.CircuitBreaker.validate(); err != nil {
+		return fmt.Errorf("circuitBreaker: %v", err)
+	}
+
+	if err := c.LoadBalancer.validate(); err != nil {
+		return fmt.Errorf("loadBalancer: %v", err)
+	}
+
+	if err := c.Routes.validate(); err != nil {
+		return fmt.Errorf("routes: %v", err)
+	}
+
+	if err := c.HealthCheck.validate(); err != nil {
+		return fmt.Errorf("healthCheck: %v", err)
+	}
+
+	if err := c.DeletionGuard.validate(); err != nil {
+		return fmt.Errorf("deletionGuard: %v", err)
+	}
+
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultDeletionGuardConfirmationPeriod is how long the VM of a node must stay not found before it is reported as deleted.
+	defaultDeletionGuardConfirmationPeriod = 5 * time.Minute
+	// defaultDeletionGuardWindow is how long a node reported not found is counted as missing.
+	defaultDeletionGuardWindow = 10 * time.Minute
+	// defaultDeletionGuardMaxMissingFraction is the fraction of the nodes or of the pools that can be missing.
+	defaultDeletionGuardMaxMissingFraction = 0.3
+	// defaultDeletionGuardMinMissingNodes is the number of missing nodes below which the guard does not trip.
+	defaultDeletionGuardMinMissingNodes = 3
+)
+
+const (
+	// AnnotationAcknowledgeDeletion set to "true" on a node confirms that its VM is deleted while the deletion guard is tripped.
+	AnnotationAcknowledgeDeletion = "node." + xok8s.XOLabelNamespace + "/acknowledge_deletion"
+
+	eventActionGuardDeletions        = "GuardDeletions"
+	eventReasonDeletionGuardTripped  = "DeletionGuardTripped"
+	eventReasonDeletionGuardReleased = "DeletionGuardReleased"
+	eventReasonDeletionBlocked       = "DeletionBlocked"
+)
+
+// deletionGuardConfig is the DeletionGuard section of the cloud config.
+type deletionGuardConfig struct {
+	// Disabled turns the deletion guard off, the nodes whose VM is not found are always reported as deleted.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// ConfirmationPeriod is how long the VM of a node must stay not found before it is reported as deleted, it defaults to 5m.
+	// The other nodes of an outage are not found meanwhile, so that the guard trips before any node is deleted.
+	ConfirmationPeriod time.Duration `yaml:"confirmationPeriod,omitempty"`
+	// Window is how long a node reported not found is counted as missing, it defaults to 10m.
+	Window time.Duration `yaml:"window,omitempty"`
+	// MaxMissingFraction is the fraction of the nodes or of the pools that can be missing, it defaults to 0.3.
+	// A pool is missing when the VMs of all its nodes are not found.
+	MaxMissingFraction float64 `yaml:"maxMissingFraction,omitempty"`
+	// MinMissingNodes is the number of missing nodes below which the guard does not trip, it defaults to 3.
+	MinMissingNodes int `yaml:"minMissingNodes,omitempty"`
+}
+
+func (c *deletionGuardConfig) validate() error {
+	if c.ConfirmationPeriod < 0 || c.Window < 0 || c.MinMissingNodes < 0 {
+		return fmt.Errorf("confirmationPeriod, window and minMissingNodes must be positive")
+	}
+
+	if c.MaxMissingFraction < 0 || c.MaxMissingFraction >= 1 {
+		return fmt.Errorf("maxMissingFraction must be between 0 and 1, got %v", c.MaxMissingFraction)
+	}
+
+	return nil
+}
+
+// deletionGuard refuses to report the VMs as deleted when many are not found at once, for example when a pool is
+// disconnected from Xen Orchestra or the token lost permissions: the node lifecycle controller would delete their nodes.
+type deletionGuard struct {
+	kubeClient         clientset.Interface
+	confirmationPeriod time.Duration
+	window             time.Duration
+	maxMissingFraction float64
+	minMissingNodes    int
+	now                func() time.Time
+
+	mu sync.Mutex
+	// missing is the pool, the first and the last time of the nodes reported not found during the window, by providerID.
+	missing map[string]missingNode
+	tripped bool
+}
+
+type missingNode struct {
+	pool  string
+	since time.Time
+	at    time.Time
+}
+
+// newDeletionGuard returns the deletion guard, or nil when it is disabled.
+func newDeletionGuard(kubeClient clientset.Interface, config deletionGuardConfig) *deletionGuard {
+	if config.Disabled {
+		return nil
+	}
+
+	g := &deletionGuard{
+		kubeClient:         kubeClient,
+		confirmationPeriod: config.ConfirmationPeriod,
+		window:             config.Window,
+		maxMissingFraction: config.MaxMissingFraction,
+		minMissingNodes:    config.MinMissingNodes,
+		now:                time.Now,
+		missing:            map[string]missingNode{},
+	}
+
+	if g.confirmationPeriod == 0 {
+		g.confirmationPeriod = defaultDeletionGuardConfirmationPeriod
+	}
+
+	if g.window == 0 {
+		g.window = defaultDeletionGuardWindow
+	}
+
+	if g.maxMissingFraction == 0 {
+		g.maxMissingFraction = defaultDeletionGuardMaxMissingFraction
+	}
+
+	if g.minMissingNodes == 0 {
+		g.minMissingNodes = defaultDeletionGuardMinMissingNodes
+	}
+
+	return g
+}
+
+// checkNotFound records that the VM of the node is not found, and returns an ErrDeletionBlocked error when
+// too many nodes or pools are missing, or an ErrDeletionUnconfirmed error until the VM has stayed not found
+// for the confirmation period, unless the deletion of the node is acknowledged.
+// The guard is nil when it is disabled or the cloud provider is not initialized.
+func (g *deletionGuard) checkNotFound(ctx context.Context, node *corev1.Node) error {
+	if g == nil {
+		return nil
+	}
+
+	// The nodes are served from the watch cache of the API server
+	nodes, err := g.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return fmt.Errorf("%w: failed to list the nodes: %v", ErrDeletionBlocked, err)
+	}
+
+	g.mu.Lock()
+
+	now := g.now()
+
+	for providerID, missing := range g.missing {
+		if now.Sub(missing.at) > g.window {
+			delete(g.missing, providerID)
+		}
+	}
+
+	since := now
+	if missing, ok := g.missing[node.Spec.ProviderID]; ok {
+		since = missing.since
+	}
+
+	g.missing[node.Spec.ProviderID] = missingNode{pool: nodePool(node), since: since, at: now}
+
+	// The fraction is checked against all the nodes of the provider. The nodes not found are counted before their
+	// deletion is confirmed, and the deleted nodes are still counted: the guard must not be released while the nodes
+	// are deleted one by one.
+	total := len(g.missing)
+	poolMissing := map[string]bool{}
+
+	for _, missing := range g.missing {
+		if missing.pool != "" {
+			poolMissing[missing.pool] = true
+		}
+	}
+
+	for i := range nodes.Items {
+		existing := &nodes.Items[i]
+		if !strings.HasPrefix(existing.Spec.ProviderID, ProviderName) {
+			continue
+		}
+
+		if _, ok := g.missing[existing.Spec.ProviderID]; ok {
+			continue
+		}
+
+		total++
+
+		if pool := nodePool(existing); pool != "" {
+			poolMissing[pool] = false
+		}
+	}
+
+	missingPools := 0
+
+	for _, missing := range poolMissing {
+		if missing {
+			missingPools++
+		}
+	}
+
+	summary := fmt.Sprintf("%d of %d nodes and %d of %d pools not found within %s", len(g.missing), total, missingPools, len(poolMissing), g.window)
+
+	anomaly := len(g.missing) >= g.minMissingNodes &&
+		(float64(len(g.missing)) > g.maxMissingFraction*float64(total) || float64(missingPools) > g.maxMissingFraction*float64(len(poolMissing)))
+
+	transition := anomaly != g.tripped
+	g.tripped = anomaly
+
+	g.mu.Unlock()
+
+	if transition {
+		g.recordTransition(ctx, anomaly, summary)
+	}
+
+	if node.Annotations[AnnotationAcknowledgeDeletion] == "true" {
+		klog.InfoS("The deletion of the node is acknowledged, reporting its VM as deleted", "node", klog.KObj(node), "providerID", node.Spec.ProviderID)
+
+		return nil
+	}
+
+	if anomaly {
+		deletionGuardBlocked.Inc()
+
+		return fmt.Errorf("%w: %s, annotate the node with %s=true to confirm its deletion", ErrDeletionBlocked, summary, AnnotationAcknowledgeDeletion)
+	}
+
+	if notFound := now.Sub(since); notFound < g.confirmationPeriod {
+		return fmt.Errorf("%w: the VM is not found since %s, it is reported as deleted once not found for %s",
+			ErrDeletionUnconfirmed, notFound.Round(time.Second), g.confirmationPeriod)
+	}
+
+	return nil
+}
+
+// observeFound forgets the node whose VM is found again.
+func (g *deletionGuard) observeFound(ctx context.Context, node *corev1.Node) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+
+	delete(g.missing, node.Spec.ProviderID)
+
+	// The next node not found evaluates the guard again, it is released once no node is missing
+	released := g.tripped && len(g.missing) == 0
+	if released {
+		g.tripped = false
+	}
+
+	g.mu.Unlock()
+
+	if released {
+		g.recordTransition(ctx, false, "all the missing nodes were found again")
+	}
+}
+
+// recordTransition logs, counts and records an event when the guard trips or is released.
+func (g *deletionGuard) recordTransition(ctx context.Context, tripped bool, summary string) {
+	eventType, reason := corev1.EventTypeNormal, eventReasonDeletionGuardReleased
+	note := fmt.Sprintf("Mass-deletion guard released: %s", summary)
+
+	if tripped {
+		deletionGuardTripped.Set(1)
+
+		eventType, reason = corev1.EventTypeWarning, eventReasonDeletionGuardTripped
+		note = fmt.Sprintf("Mass-deletion guard tripped: %s, the VMs are not reported as deleted. "+
+			"Check the pools and the token permissions in Xen Orchestra, then annotate the nodes to delete with %s=true", summary, AnnotationAcknowledgeDeletion)
+
+		klog.ErrorS(ErrDeletionBlocked, "Mass-deletion guard tripped, the VMs are not reported as deleted", "summary", summary)
+	} else {
+		deletionGuardTripped.Set(0)
+
+		klog.InfoS("Mass-deletion guard released", "summary", summary)
+	}
+
+	if eventErr := recordCloudProviderEvent(ctx, g.kubeClient, eventType, eventActionGuardDeletions, reason, note); eventErr != nil {
+		klog.ErrorS(eventErr, "failed to record Xen Orchestra deletion guard event")
+	}
+}
+
+// nodePool returns the current pool of the node, from its pool label or from its providerID.
+func nodePool(node *corev1.Node) string {
+	if pool := node.Labels[xok8s.XOLabelTopologyPoolID]; pool != "" {
+		return pool
+	}
+
+	if pool, err := ProviderIDPool(node.Spec.ProviderID); err == nil && !pool.IsNil() {
+		return pool.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func guardedNode(name, pool string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: xenorchestraProviderScheme + pool + "/" + name},
+	}
+}
+
+func TestDeletionGuard(t *testing.T) {
+	// Pool 1 has 3 nodes and pool 2 has 2 nodes, the foreign node is not counted
+	nodes := []*v1.Node{
+		guardedNode("550e8400-e29b-41d4-a716-446655440011", pool1ID),
+		guardedNode("550e8400-e29b-41d4-a716-446655440012", pool1ID),
+		guardedNode("550e8400-e29b-41d4-a716-446655440013", pool1ID),
+		guardedNode("550e8400-e29b-41d4-a716-446655440021", pool2ID),
+		guardedNode("550e8400-e29b-41d4-a716-446655440022", pool2ID),
+		{ObjectMeta: metav1.ObjectMeta{Name: testNode1}, Spec: v1.NodeSpec{ProviderID: nodeForeignProviderURI}},
+	}
+
+	client := fake.NewClientset()
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(t.Context(), node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	now := time.Now()
+	g := newDeletionGuard(client, deletionGuardConfig{})
+	g.now = func() time.Time { return now }
+
+	// The whole pool 2 is missing, but less than 3 nodes are: the deletions are confirmed after the confirmation period
+	require.ErrorIs(t, g.checkNotFound(t.Context(), nodes[3]), ErrDeletionUnconfirmed)
+	require.ErrorIs(t, g.checkNotFound(t.Context(), nodes[4]), ErrDeletionUnconfirmed)
+
+	now = now.Add(defaultDeletionGuardConfirmationPeriod)
+	require.NoError(t, g.checkNotFound(t.Context(), nodes[3]))
+
+	// The node lifecycle controller deleted a node, it is still counted
+	require.NoError(t, client.CoreV1().Nodes().Delete(t.Context(), nodes[3].Name, metav1.DeleteOptions{}))
+
+	err := g.checkNotFound(t.Context(), nodes[0])
+	require.ErrorIs(t, err, ErrDeletionBlocked)
+	assert.ErrorContains(t, err, "3 of 5 nodes and 1 of 2 pools not found within 10m0s")
+
+	tripped, err := testutil.GetGaugeMetricValue(deletionGuardTripped)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), tripped)
+
+	events, err := client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, v1.EventTypeWarning, events.Items[0].Type)
+	assert.Equal(t, eventReasonDeletionGuardTripped, events.Items[0].Reason)
+
+	// The operator confirms the deletion of the node
+	acknowledged := nodes[0].DeepCopy()
+	acknowledged.Annotations = map[string]string{AnnotationAcknowledgeDeletion: "true"}
+	require.NoError(t, g.checkNotFound(t.Context(), acknowledged))
+	require.ErrorIs(t, g.checkNotFound(t.Context(), nodes[4]), ErrDeletionBlocked)
+
+	// The missing nodes are forgotten after the window, the confirmation period starts again
+	now = now.Add(defaultDeletionGuardWindow + time.Second)
+	require.ErrorIs(t, g.checkNotFound(t.Context(), nodes[1]), ErrDeletionUnconfirmed)
+
+	tripped, err = testutil.GetGaugeMetricValue(deletionGuardTripped)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), tripped)
+
+	events, err = client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, events.Items, 2, "the release is recorded")
+
+	// A disabled guard does not block
+	var disabled *deletionGuard
+	assert.Nil(t, newDeletionGuard(client, deletionGuardConfig{Disabled: true}))
+	assert.NoError(t, disabled.checkNotFound(t.Context(), nodes[0]))
+	disabled.observeFound(t.Context(), nodes[0])
+}
+
+func TestDeletionGuardPoolOutage(t *testing.T) {
+	// The cluster has 10 nodes in each pool
+	client := fake.NewClientset()
+	pool1Nodes := []*v1.Node{}
+
+	for idx := range 20 {
+		pool := pool2ID
+		if idx < 10 {
+			pool = pool1ID
+		}
+
+		node := guardedNode(fmt.Sprintf("550e8400-e29b-41d4-a716-4466554400%02d", idx), pool)
+		_, err := client.CoreV1().Nodes().Create(t.Context(), node, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		if pool == pool1ID {
+			pool1Nodes = append(pool1Nodes, node)
+		}
+	}
+
+	now := time.Now()
+	g := newDeletionGuard(client, deletionGuardConfig{})
+	g.now = func() time.Time { return now }
+
+	// Pool 1 is disconnected: the first nodes not found are not reported as deleted, before the guard trips
+	for idx, node := range pool1Nodes {
+		err := g.checkNotFound(t.Context(), node)
+		require.Error(t, err, "node %d is reported as deleted", idx)
+
+		if idx < 2 {
+			require.ErrorIs(t, err, ErrDeletionUnconfirmed)
+		}
+
+		now = now.Add(5 * time.Second)
+	}
+
+	assert.True(t, g.tripped)
+
+	// The first nodes are still not found after the confirmation period
+	now = now.Add(defaultDeletionGuardConfirmationPeriod)
+
+	for _, node := range pool1Nodes[:2] {
+		require.ErrorIs(t, g.checkNotFound(t.Context(), node), ErrDeletionBlocked)
+	}
+
+	for _, node := range pool1Nodes {
+		g.observeFound(t.Context(), node)
+	}
+
+	assert.False(t, g.tripped)
+}
+
+func TestDeletionGuardReleasedWhenFound(t *testing.T) {
+	nodes := []*v1.Node{
+		guardedNode("550e8400-e29b-41d4-a716-446655440011", pool1ID),
+		guardedNode("550e8400-e29b-41d4-a716-446655440012", pool1ID),
+	}
+
+	client := fake.NewClientset(nodes[0], nodes[1])
+	g := newDeletionGuard(client, deletionGuardConfig{MinMissingNodes: 1})
+
+	require.ErrorIs(t, g.checkNotFound(t.Context(), nodes[0]), ErrDeletionBlocked)
+
+	// The pool is connected again
+	g.observeFound(t.Context(), nodes[0])
+	assert.False(t, g.tripped)
+	assert.Empty(t, g.missing)
+}
+
+func TestDeletionGuardConfig(t *testing.T) {
+	assert.NoError(t, (&deletionGuardConfig{ConfirmationPeriod: time.Minute, Window: time.Minute, MaxMissingFraction: 0.5, MinMissingNodes: 2}).validate())
+	assert.Error(t, (&deletionGuardConfig{ConfirmationPeriod: -time.Minute}).validate())
+	assert.Error(t, (&deletionGuardConfig{Window: -time.Minute}).validate())
+	assert.Error(t, (&deletionGuardConfig{MaxMissingFraction: 1}).validate())
+	assert.Error(t, (&deletionGuardConfig{MinMissingNodes: -1}).validate())
+}
+
+func (ts *ccmTestSuite) TestInstanceExistsDeletionGuard() {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: pool1Node500},
+		Spec:       v1.NodeSpec{ProviderID: providerURIMissingVM},
+	}
+
+	client := fake.NewClientset(node)
+	ts.i.initialize(client)
+
+	// The deletion is not reported until it is confirmed, the wait is not recorded on the node
+	ts.i.deletionGuard = newDeletionGuard(client, deletionGuardConfig{})
+
+	exists, err := ts.i.InstanceExists(context.Background(), node)
+	ts.Require().ErrorIs(err, ErrDeletionUnconfirmed)
+	ts.False(exists)
+
+	ts.i.deletionGuard = newDeletionGuard(client, deletionGuardConfig{MinMissingNodes: 1})
+
+	exists, err = ts.i.InstanceExists(context.Background(), node)
+	ts.Require().ErrorIs(err, ErrDeletionBlocked)
+	ts.False(exists)
+
+	// The blocked deletion is recorded on the node
+	events, err := client.EventsV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	ts.Require().NoError(err)
+	ts.Require().Len(events.Items, 1)
+	ts.Equal(eventReasonDeletionBlocked, events.Items[0].Reason)
+}
+
+func (ts *ccmTestSuite) TestInstanceExistsByProviderIDDeletionGuard() {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: pool1Node500, Annotations: map[string]string{AnnotationAcknowledgeDeletion: "true"}},
+		Spec:       v1.NodeSpec{ProviderID: providerURIMissingVM},
+	}
+
+	client := fake.NewClientset(node)
+	ts.i.initialize(client)
+	ts.i.deletionGuard = newDeletionGuard(client, deletionGuardConfig{MinMissingNodes: 1})
+
+	// The legacy lookup reads the acknowledgement of the registered node
+	exists, err := ts.i.InstanceExistsByProviderID(context.Background(), providerURIMissingVM)
+	ts.Require().NoError(err)
+	ts.False(exists)
+
+	ts.Require().NoError(client.CoreV1().Nodes().Delete(context.Background(), node.Name, metav1.DeleteOptions{}))
+
+	_, err = ts.i.InstanceExistsByProviderID(context.Background(), providerURIMissingVM)
+	ts.Require().ErrorIs(err, ErrDeletionBlocked)
+}
@@ -0,0 +1,546 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"golang.org/x/sync/singleflight"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// poolDiscoveryInterval bounds how often the pools of the endpoints are listed when a pool is unknown.
+const poolDiscoveryInterval = time.Minute
+
+// endpointProviderIDRegexp matches the providerIDs with an endpoint segment, "xenorchestra://<endpoint>/<pool>/<vm>".
+var endpointProviderIDRegexp = regexp.MustCompile(`^` + xok8s.ProviderName + `://([^/]+)/([^/]*/[^/]+)$`)
+
+var endpointNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// xoEndpointConfig is a Xen Orchestra instance of the Endpoints section of the cloud config.
+type xoEndpointConfig struct {
+	xok8s.XoConfig `yaml:",inline"`
+
+	// Name identifies the endpoint, it can be set in the providerID: xenorchestra://<name>/<pool>/<vm>.
+	Name string `yaml:"name"`
+	// FailoverURLs are equivalent URLs of the Xen Orchestra instance, for example an XO proxy, used when the url is unreachable.
+	FailoverURLs []string `yaml:"failoverURLs,omitempty"`
+	// Pools are the pool UUIDs managed by the endpoint. The pools are discovered when they are not listed.
+	Pools []string `yaml:"pools,omitempty"`
+}
+
+func (c *xoEndpointConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if !endpointNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("name must only contain alphanumerics, '.', '_' or '-', got %q", c.Name)
+	}
+
+	// The connection settings are validated by the shared XO config reader
+	data, err := yaml.Marshal(c.XoConfig)
+	if err != nil {
+		return err
+	}
+
+	if _, err := xok8s.ReadCloudConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	if err := validateFailoverURLs(c.URL, c.FailoverURLs); err != nil {
+		return err
+	}
+
+	for _, pool := range c.Pools {
+		if _, err := uuid.FromString(pool); err != nil {
+			return fmt.Errorf("pools must be UUIDs, got %q", pool)
+		}
+	}
+
+	return nil
+}
+
+func validateEndpoints(endpoints []xoEndpointConfig) error {
+	names := map[string]bool{}
+	pools := map[string]string{}
+
+	for idx := range endpoints {
+		endpoint := &endpoints[idx]
+		if err := endpoint.validate(); err != nil {
+			return fmt.Errorf("[%d]: %v", idx, err)
+		}
+
+		if names[endpoint.Name] {
+			return fmt.Errorf("[%d]: duplicate name %q", idx, endpoint.Name)
+		}
+
+		names[endpoint.Name] = true
+
+		for _, pool := range endpoint.Pools {
+			if owner, ok := pools[pool]; ok {
+				return fmt.Errorf("[%d]: pool %s is already managed by %q", idx, pool, owner)
+			}
+
+			pools[pool] = endpoint.Name
+		}
+	}
+
+	return nil
+}
+
+// xoEndpoint is a Xen Orchestra instance with its client and cache.
+type xoEndpoint struct {
+	// name is empty for the Xen Orchestra instance configured at the top level of the cloud config.
+	name  string
+	c     *xok8s.XoClient
+	cache *xoCache
+	// watcher is nil when the event subscription is disabled.
+	watcher *vmWatcher
+	// lib serves the requests of the client, it is replaced when the connection settings are reloaded.
+	lib *reloadableLibrary
+
+	mu       sync.Mutex
+	xoConfig xok8s.XoConfig
+	// failover is nil when the endpoint has a single URL.
+	failover *failoverClient
+	// failoverCtx and onSwitch are set once the health probes of the failover URLs are started.
+	failoverCtx  context.Context //nolint:containedctx
+	onSwitch     func(from, to string, err error)
+	stopFailover context.CancelFunc
+}
+
+func newXOEndpoint(name string, client *xok8s.XoClient, xoConfig xok8s.XoConfig, config *cloudConfig) *xoEndpoint {
+	endpoint := &xoEndpoint{
+		name:     name,
+		lib:      newReloadableLibrary(client.Client),
+		xoConfig: xoConfig,
+	}
+
+	// The guard throttles the requests of the endpoint, whichever client serves them
+	guard := newRequestGuard(endpoint.String(), config.RateLimit, config.CircuitBreaker)
+	endpoint.c = &xok8s.XoClient{Client: &guardedLibrary{Library: endpoint.lib, g: guard}}
+	endpoint.cache = newXOCache(endpoint.c, config.Cache)
+	endpoint.failover, _ = client.Client.(*failoverClient)
+
+	if !config.Events.Disabled {
+		endpoint.watcher = newVMWatcher(newWSEventSource(endpoint.eventsConfig))
+	}
+
+	return endpoint
+}
+
+// eventsConfig returns the connection settings of the event subscription, and a channel closed when
+// the endpoint fails over to another URL. The channel is nil when the endpoint has a single URL.
+func (e *xoEndpoint) eventsConfig() (xok8s.XoConfig, <-chan struct{}) {
+	e.mu.Lock()
+	failover, xoConfig := e.failover, e.xoConfig
+	e.mu.Unlock()
+
+	if failover != nil {
+		return failover.watchConfig()
+	}
+
+	return xoConfig, nil
+}
+
+// startFailover starts the health probes of the failover URLs until the context is done.
+func (e *xoEndpoint) startFailover(ctx context.Context, onSwitch func(from, to string, err error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failoverCtx, e.onSwitch = ctx, onSwitch
+	e.runFailover()
+}
+
+// runFailover starts the health probes of the current failover client, e.mu must be held.
+func (e *xoEndpoint) runFailover() {
+	if e.failover == nil || e.failoverCtx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(e.failoverCtx)
+	e.stopFailover = cancel
+
+	e.failover.setOnSwitch(e.onSwitch)
+
+	go e.failover.run(ctx)
+}
+
+// setClient replaces the client of the endpoint, the requests in flight complete with the previous client.
+func (e *xoEndpoint) setClient(client *xok8s.XoClient, xoConfig xok8s.XoConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stopFailover != nil {
+		e.stopFailover()
+		e.stopFailover = nil
+	}
+
+	e.xoConfig = xoConfig
+	e.failover, _ = client.Client.(*failoverClient)
+	e.lib.set(client.Client)
+
+	e.runFailover()
+}
+
+// The lookups are served from the inventory snapshot of the endpoint in the context first, see WithInventory.
+
+func (e *xoEndpoint) getVM(ctx context.Context, id uuid.UUID) (*payloads.VM, error) {
+	if snapshot := inventoryFrom(ctx).of(e); snapshot != nil {
+		if vm, ok := snapshot.vms[id]; ok {
+			cacheRequests.WithLabelValues(e.cache.vms.name, "inventory").Inc()
+
+			return vm, nil
+		}
+	}
+
+	return e.cache.getVM(ctx, id)
+}
+
+func (e *xoEndpoint) getHost(ctx context.Context, id uuid.UUID) (*payloads.Host, error) {
+	if snapshot := inventoryFrom(ctx).of(e); snapshot != nil {
+		if host, ok := snapshot.hosts[id]; ok {
+			cacheRequests.WithLabelValues(e.cache.hosts.name, "inventory").Inc()
+
+			return host, nil
+		}
+	}
+
+	return e.cache.getHost(ctx, id)
+}
+
+func (e *xoEndpoint) getPool(ctx context.Context, id uuid.UUID) (*payloads.Pool, error) {
+	if snapshot := inventoryFrom(ctx).of(e); snapshot != nil {
+		if pool, ok := snapshot.pools[id]; ok {
+			cacheRequests.WithLabelValues(e.cache.pools.name, "inventory").Inc()
+
+			return pool, nil
+		}
+	}
+
+	return e.cache.getPool(ctx, id)
+}
+
+// String returns the endpoint name for the logs.
+func (e *xoEndpoint) String() string {
+	if e.name == "" {
+		return "default"
+	}
+
+	return e.name
+}
+
+// xoEndpoints routes the lookups to the Xen Orchestra instance managing the VM: the endpoint
+// named in the providerID, or the endpoint managing the pool of the providerID. The lookups are
+// sent to all the endpoints when the providerID has neither.
+type xoEndpoints struct {
+	list []*xoEndpoint
+
+	mu          sync.Mutex
+	pools       map[uuid.UUID]*xoEndpoint
+	static      map[uuid.UUID]bool
+	lastRefresh time.Time
+	now         func() time.Time
+
+	// discovery shares the pool discovery between the concurrent lookups, it runs without holding mu.
+	discovery singleflight.Group
+}
+
+func newXOEndpoints(list []*xoEndpoint, pools map[uuid.UUID]*xoEndpoint) *xoEndpoints {
+	e := &xoEndpoints{
+		list:   list,
+		pools:  map[uuid.UUID]*xoEndpoint{},
+		static: map[uuid.UUID]bool{},
+		now:    time.Now,
+	}
+
+	for pool, endpoint := range pools {
+		e.pools[pool] = endpoint
+		e.static[pool] = true
+	}
+
+	return e
+}
+
+// endpointConfigs returns the Xen Orchestra instances of the cloud config, the instance configured
+// at the top level has no name.
+func endpointConfigs(config *cloudConfig) []xoEndpointConfig {
+	if len(config.Endpoints) == 0 {
+		return []xoEndpointConfig{{XoConfig: config.XoConfig, FailoverURLs: config.FailoverURLs}}
+	}
+
+	return config.Endpoints
+}
+
+// buildXOEndpoints creates the clients of the Xen Orchestra instances of the cloud config.
+func buildXOEndpoints(config *cloudConfig) (*xoEndpoints, error) {
+	list := []*xoEndpoint{}
+	pools := map[uuid.UUID]*xoEndpoint{}
+
+	for _, endpointConfig := range endpointConfigs(config) {
+		client, err := newEndpointClient(endpointConfig.Name, endpointConfig.XoConfig, endpointConfig.FailoverURLs)
+		if err != nil {
+			if endpointConfig.Name == "" {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("endpoint %s: %v", endpointConfig.Name, err)
+		}
+
+		endpoint := newXOEndpoint(endpointConfig.Name, client, endpointConfig.XoConfig, config)
+		list = append(list, endpoint)
+
+		for _, pool := range endpointConfig.Pools {
+			pools[uuid.FromStringOrNil(pool)] = endpoint
+		}
+	}
+
+	return newXOEndpoints(list, pools), nil
+}
+
+// newEndpointClient creates the client of a Xen Orchestra instance, it fails over between the URLs
+// when failover URLs are set.
+func newEndpointClient(name string, config xok8s.XoConfig, failoverURLs []string) (*xok8s.XoClient, error) {
+	if len(failoverURLs) == 0 {
+		return xok8s.NewXOClient(&config)
+	}
+
+	failover, err := newFailoverClient(cmp.Or(name, "default"), config, failoverURLs, newXOLibrary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xok8s.XoClient{Client: failover}, nil
+}
+
+// parseProviderID returns the endpoint name, the VM reference and the pool of the providerID.
+// The endpoint name is empty for the "xenorchestra://<pool>/<vm>" providerIDs.
+func parseProviderID(providerID string) (string, *payloads.VM, uuid.UUID, error) {
+	name := ""
+
+	if match := endpointProviderIDRegexp.FindStringSubmatch(providerID); match != nil {
+		name = match[1]
+		providerID = xok8s.ProviderName + "://" + match[2]
+	}
+
+	vmRef, poolID, err := xok8s.ParseProviderID(providerID)
+	if err != nil {
+		return "", nil, uuid.Nil, err
+	}
+
+	return name, vmRef, poolID, nil
+}
+
+// ProviderIDPool returns the pool of the providerID. It is the pool of the VM when the node registered,
+// the VM may have migrated to another pool since.
+func ProviderIDPool(providerID string) (uuid.UUID, error) {
+	_, _, poolID, err := parseProviderID(providerID)
+
+	return poolID, err
+}
+
+// byName returns the endpoint with the name.
+func (e *xoEndpoints) byName(name string) (*xoEndpoint, error) {
+	for _, endpoint := range e.list {
+		if endpoint.name == name {
+			return endpoint, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown Xen Orchestra endpoint %q", name)
+}
+
+// route returns the endpoint of the VM, or nil when it is unknown and all the endpoints must be queried.
+func (e *xoEndpoints) route(ctx context.Context, name string, poolID uuid.UUID) (*xoEndpoint, error) {
+	if name != "" {
+		return e.byName(name)
+	}
+
+	if len(e.list) == 1 {
+		return e.list[0], nil
+	}
+
+	if poolID.IsNil() {
+		return nil, nil
+	}
+
+	return e.poolEndpoint(ctx, poolID), nil
+}
+
+// poolEndpoint returns the endpoint managing the pool, the pools are discovered again when it is unknown.
+func (e *xoEndpoints) poolEndpoint(ctx context.Context, poolID uuid.UUID) *xoEndpoint {
+	e.mu.Lock()
+	endpoint, ok := e.pools[poolID]
+	e.mu.Unlock()
+
+	if ok {
+		return endpoint
+	}
+
+	e.discovery.Do("pools", func() (any, error) { //nolint:errcheck
+		e.discoverPools(ctx)
+
+		return nil, nil
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.pools[poolID]
+}
+
+// discoverPools lists the pools of all the endpoints, at most once per poolDiscoveryInterval.
+// A slow endpoint does not block the lookups of the pools already known.
+func (e *xoEndpoints) discoverPools(ctx context.Context) {
+	e.mu.Lock()
+	if e.now().Sub(e.lastRefresh) < poolDiscoveryInterval {
+		e.mu.Unlock()
+
+		return
+	}
+
+	e.lastRefresh = e.now()
+	e.mu.Unlock()
+
+	discovered := map[uuid.UUID]*xoEndpoint{}
+	failed := map[*xoEndpoint]bool{}
+
+	for _, endpoint := range e.list {
+		pools, err := endpoint.c.Client.Pool().GetAll(ctx, 0, "")
+		if err != nil {
+			klog.ErrorS(err, "failed to discover the pools of the Xen Orchestra endpoint", "endpoint", endpoint)
+
+			failed[endpoint] = true
+
+			continue
+		}
+
+		for _, pool := range pools {
+			discovered[pool.ID] = endpoint
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// The static pools and the pools of the endpoints that could not be listed are kept
+	pools := map[uuid.UUID]*xoEndpoint{}
+
+	for pool, endpoint := range e.pools {
+		if e.static[pool] || failed[endpoint] {
+			pools[pool] = endpoint
+		}
+	}
+
+	for pool, endpoint := range discovered {
+		if !e.static[pool] {
+			pools[pool] = endpoint
+		}
+	}
+
+	e.pools = pools
+
+	klog.V(4).InfoS("Discovered the pools of the Xen Orchestra endpoints", "pools", len(e.pools))
+}
+
+// getVM returns the VM and its endpoint. The VM is looked up on all the endpoints when its endpoint is unknown.
+func (e *xoEndpoints) getVM(ctx context.Context, endpoint *xoEndpoint, id uuid.UUID) (*payloads.VM, *xoEndpoint, error) {
+	if endpoint != nil {
+		vm, err := endpoint.getVM(ctx, id)
+
+		return vm, endpoint, err
+	}
+
+	return fanOut(e.list, func(endpoint *xoEndpoint) (*payloads.VM, error) {
+		return endpoint.getVM(ctx, id)
+	})
+}
+
+// findVMByNode returns the VM of the node and its endpoint, it is looked up on all the endpoints.
+func (e *xoEndpoints) findVMByNode(ctx context.Context, node *v1.Node) (*payloads.VM, *xoEndpoint, error) {
+	return fanOut(e.list, func(endpoint *xoEndpoint) (*payloads.VM, error) {
+		vm, _, err := endpoint.c.FindVMByNode(ctx, node)
+		if err != nil {
+			// The lookup error is flattened into the message, a VM not found keeps its 404 status there
+			return nil, classifyXOError(err)
+		}
+
+		return vm, nil
+	})
+}
+
+// findVMByName returns the VM with the name_label and its endpoint, it is looked up on all the endpoints.
+func (e *xoEndpoints) findVMByName(ctx context.Context, name string) (*payloads.VM, *xoEndpoint, error) {
+	return fanOut(e.list, func(endpoint *xoEndpoint) (*payloads.VM, error) {
+		vms, err := endpoint.c.Client.VM().GetAll(ctx, 0, "name_label:"+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get list of VMs: %w", classifyXOError(err))
+		}
+
+		for _, vm := range vms {
+			if vm.NameLabel == name {
+				return vm, nil
+			}
+		}
+
+		return nil, &xoError{class: ErrXONotFound, err: fmt.Errorf("vm %q not found", name)}
+	})
+}
+
+// fanOut returns the VM of the first endpoint that has it. The VM is not found only when every endpoint
+// reported it as not found, any other error of an endpoint is returned first.
+func fanOut(endpoints []*xoEndpoint, lookup func(*xoEndpoint) (*payloads.VM, error)) (*payloads.VM, *xoEndpoint, error) {
+	var notFound, failed error
+
+	for _, endpoint := range endpoints {
+		vm, err := lookup(endpoint)
+		if err == nil {
+			return vm, endpoint, nil
+		}
+
+		err = classifyXOError(err)
+		if len(endpoints) > 1 {
+			err = fmt.Errorf("endpoint %s: %w", endpoint, err)
+		}
+
+		if errors.Is(err, ErrXONotFound) {
+			notFound = err
+		} else if failed == nil {
+			failed = err
+		}
+	}
+
+	if failed != nil {
+		return nil, nil, failed
+	}
+
+	return nil, nil, notFound
+}
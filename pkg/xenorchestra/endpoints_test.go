@@ -0,0 +1,338 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestEndpointsConfig(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+endpoints:
+  - name: dc1
+    url: https://xoa-dc1.example.com
+    token: "12ABC"
+    pools: [` + pool1ID + `]
+  - name: dc2
+    url: https://xoa-dc2.example.com
+    token: "34DEF"
+    insecure: true
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Endpoints, 2)
+	assert.Equal(t, "https://xoa-dc1.example.com", cfg.Endpoints[0].URL)
+	assert.Equal(t, []string{pool1ID}, cfg.Endpoints[0].Pools)
+	assert.True(t, cfg.Endpoints[1].Insecure)
+
+	cloud, err := newCloud(&cfg)
+	require.NoError(t, err)
+	assert.NotNil(t, cloud)
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name: "top level url",
+			config: `
+url: https://example.com
+token: "12ABC"
+endpoints:
+  - name: dc1
+    url: https://xoa-dc1.example.com
+    token: "12ABC"
+`,
+			err: "endpoints replace the url and credentials, they must not be set at the top level",
+		},
+		{
+			name: "missing credentials",
+			config: `
+endpoints:
+  - name: dc1
+    url: https://xoa-dc1.example.com
+`,
+			err: "endpoints[0]: either token or username/password are required for authentication",
+		},
+		{
+			name: "duplicate name",
+			config: `
+endpoints:
+  - name: dc1
+    url: https://xoa-dc1.example.com
+    token: "12ABC"
+  - name: dc1
+    url: https://xoa-dc2.example.com
+    token: "12ABC"
+`,
+			err: `endpoints[1]: duplicate name "dc1"`,
+		},
+		{
+			name: "shared pool",
+			config: `
+endpoints:
+  - name: dc1
+    url: https://xoa-dc1.example.com
+    token: "12ABC"
+    pools: [` + pool1ID + `]
+  - name: dc2
+    url: https://xoa-dc2.example.com
+    token: "12ABC"
+    pools: [` + pool1ID + `]
+`,
+			err: "endpoints[1]: pool " + pool1ID + ` is already managed by "dc1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readCloudConfig(strings.NewReader(tt.config))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestParseProviderID(t *testing.T) {
+	name, vmRef, poolID, err := parseProviderID(providerURIPool1Node1)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+	assert.Equal(t, vmPool1Node1ID, vmRef.ID.String())
+	assert.Equal(t, pool1ID, poolID.String())
+
+	name, vmRef, poolID, err = parseProviderID(xenorchestraProviderScheme + "dc2/" + pool2ID + "/" + vmPool2Node1ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dc2", name)
+	assert.Equal(t, vmPool2Node1ID, vmRef.ID.String())
+	assert.Equal(t, pool2ID, poolID.String())
+
+	name, vmRef, poolID, err = parseProviderID(xenorchestraProviderScheme + "dc2//" + vmPool2Node1ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dc2", name)
+	assert.Equal(t, vmPool2Node1ID, vmRef.ID.String())
+	assert.True(t, poolID.IsNil())
+
+	_, _, _, err = parseProviderID(xenorchestraProviderScheme + "dc2/" + pool2ID + "/not-a-uuid")
+	assert.Error(t, err)
+}
+
+// endpointMocks returns the mocked library of an endpoint serving the VMs.
+func endpointMocks(ctrl *gomock.Controller, poolID string, vms ...*payloads.VM) *mock_library.MockLibrary {
+	mockVM := mock_library.NewMockVM(ctrl)
+	for _, vm := range vms {
+		mockVM.EXPECT().GetByID(gomock.Any(), vm.ID).Return(vm, nil).AnyTimes()
+	}
+
+	mockVM.EXPECT().GetByID(gomock.Any(), gomock.Any()).Return(nil, errors.New("API error: 404 Not Found - {}")).AnyTimes()
+
+	mockHost := mock_library.NewMockHost(ctrl)
+	mockHost.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&payloads.Host{NameLabel: testHost1}, nil).AnyTimes()
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().Get(gomock.Any(), gomock.Any()).Return(&payloads.Pool{NameLabel: testPool1}, nil).AnyTimes()
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{{ID: uuid.FromStringOrNil(poolID)}}, nil).AnyTimes()
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(&fakeV1Client{}).AnyTimes()
+
+	return mockLib
+}
+
+func TestEndpointsRouting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	vm1 := &payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool1Node1ID),
+		NameLabel: pool1Node1,
+		PoolID:    uuid.FromStringOrNil(pool1ID),
+		Container: uuid.FromStringOrNil(host1ID),
+	}
+	vm2 := &payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool2Node1ID),
+		NameLabel: pool2Node1,
+		PoolID:    uuid.FromStringOrNil(pool2ID),
+		Container: uuid.FromStringOrNil(host2ID),
+	}
+
+	config := &cloudConfig{Events: eventsConfig{Disabled: true}}
+	dc1 := newXOEndpoint("dc1", &xok8s.XoClient{Client: endpointMocks(ctrl, pool1ID, vm1)}, xok8s.XoConfig{}, config)
+	dc2 := newXOEndpoint("dc2", &xok8s.XoClient{Client: endpointMocks(ctrl, pool2ID, vm2)}, xok8s.XoConfig{}, config)
+
+	endpoints := newXOEndpoints([]*xoEndpoint{dc1, dc2}, map[uuid.UUID]*xoEndpoint{uuid.FromStringOrNil(pool1ID): dc1})
+	i := newEndpointInstances(endpoints, config)
+
+	tests := []struct {
+		name       string
+		providerID string
+		endpoint   *xoEndpoint
+		vm         *payloads.VM
+	}{
+		{name: "static pool", providerID: providerURIPool1Node1, endpoint: dc1, vm: vm1},
+		{name: "discovered pool", providerID: providerURIPool2Node1, endpoint: dc2, vm: vm2},
+		{name: "endpoint segment", providerID: xenorchestraProviderScheme + "dc2/" + pool2ID + "/" + vmPool2Node1ID, endpoint: dc2, vm: vm2},
+		{name: "migrated to a pool of another endpoint", providerID: xenorchestraProviderScheme + pool1ID + "/" + vmPool2Node1ID, endpoint: dc2, vm: vm2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, endpoint, err := i.getInstance(t.Context(), nodeFromProviderID(tt.providerID))
+			require.NoError(t, err)
+			assert.Equal(t, tt.vm.ID, vm.ID)
+			assert.Same(t, tt.endpoint, endpoint)
+		})
+	}
+
+	assert.Same(t, dc2, endpoints.pools[uuid.FromStringOrNil(pool2ID)], "the pool of dc2 was discovered")
+
+	_, err := i.GetInstance(t.Context(), nodeFromProviderID(xenorchestraProviderScheme+"dc3/"+pool2ID+"/"+vmPool2Node1ID))
+	assert.ErrorIs(t, err, ErrInvalidProviderID)
+
+	// The endpoint named by the providerID is authoritative
+	_, err = i.GetInstance(t.Context(), nodeFromProviderID(xenorchestraProviderScheme+"dc1/"+pool1ID+"/"+vmPool2Node1ID))
+	assert.ErrorIs(t, err, cloudprovider.InstanceNotFound)
+
+	// A node without providerID is looked up on all the endpoints
+	meta, err := i.InstanceMetadata(t.Context(), &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: pool2Node1},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: vmPool2Node1ID}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, providerURIPool2Node1, meta.ProviderID)
+	assert.Equal(t, host2ID, meta.Zone)
+
+	_, err = i.InstanceMetadata(t.Context(), &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeNotExists},
+		Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: vmMissingID}},
+	})
+	assert.ErrorIs(t, err, ErrXONotFound)
+}
+
+func TestFanOutErrors(t *testing.T) {
+	dc1 := &xoEndpoint{name: "dc1"}
+	dc2 := &xoEndpoint{name: "dc2"}
+
+	_, _, err := fanOut([]*xoEndpoint{dc1, dc2}, func(endpoint *xoEndpoint) (*payloads.VM, error) {
+		if endpoint == dc1 {
+			return nil, errors.New("API error: 503 Service Unavailable - {}")
+		}
+
+		return nil, errors.New("API error: 404 Not Found - {}")
+	})
+	assert.ErrorIs(t, err, ErrXOTransient, "the VM may be on the endpoint that failed")
+	assert.ErrorContains(t, err, "endpoint dc1")
+
+	// An error of an unknown class is not a VM not found
+	_, _, err = fanOut([]*xoEndpoint{dc1, dc2}, func(endpoint *xoEndpoint) (*payloads.VM, error) {
+		if endpoint == dc1 {
+			return nil, context.Canceled
+		}
+
+		return nil, errors.New("API error: 404 Not Found - {}")
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrXONotFound)
+}
+
+func TestGetInstanceUnclassifiedError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// dc1 returns a response that cannot be decoded, dc2 does not have the VM
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetByID(gomock.Any(), gomock.Any()).Return(nil, errors.New("invalid character '<' looking for beginning of value")).AnyTimes()
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{{ID: uuid.FromStringOrNil(pool1ID)}}, nil).AnyTimes()
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+
+	config := &cloudConfig{Events: eventsConfig{Disabled: true}}
+	dc1 := newXOEndpoint("dc1", &xok8s.XoClient{Client: mockLib}, xok8s.XoConfig{}, config)
+	dc2 := newXOEndpoint("dc2", &xok8s.XoClient{Client: endpointMocks(ctrl, pool2ID)}, xok8s.XoConfig{}, config)
+
+	i := newEndpointInstances(newXOEndpoints([]*xoEndpoint{dc1, dc2}, nil), config)
+
+	// The pool of the providerID is unknown, the VM is looked up on both endpoints
+	_, err := i.GetInstance(t.Context(), nodeFromProviderID(xenorchestraProviderScheme+"ffffffff-ffff-4fff-afff-ffffffffffff/"+vmPool1Node1ID))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, cloudprovider.InstanceNotFound, "the VM may be on dc1")
+	assert.ErrorContains(t, err, "endpoint dc1")
+}
+
+func TestPoolDiscoveryDoesNotBlockLookups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	unblock := make(chan struct{})
+	slowPool := mock_library.NewMockPool(ctrl)
+	slowPool.EXPECT().GetAll(gomock.Any(), 0, "").DoAndReturn(func(_ any, _ int, _ string) ([]*payloads.Pool, error) {
+		<-unblock
+
+		return nil, errors.New("API error: 504 Gateway Timeout - {}")
+	}).Times(1)
+
+	slowLib := mock_library.NewMockLibrary(ctrl)
+	slowLib.EXPECT().Pool().Return(slowPool).AnyTimes()
+
+	config := &cloudConfig{Events: eventsConfig{Disabled: true}}
+	dc1 := newXOEndpoint("dc1", &xok8s.XoClient{Client: slowLib}, xok8s.XoConfig{}, config)
+	dc2 := newXOEndpoint("dc2", &xok8s.XoClient{Client: endpointMocks(ctrl, pool2ID)}, xok8s.XoConfig{}, config)
+
+	endpoints := newXOEndpoints([]*xoEndpoint{dc1, dc2}, map[uuid.UUID]*xoEndpoint{uuid.FromStringOrNil(pool1ID): dc1})
+
+	discovered := make(chan *xoEndpoint)
+	go func() {
+		discovered <- endpoints.poolEndpoint(t.Context(), uuid.FromStringOrNil(pool2ID))
+	}()
+
+	// The known pools are served while dc1 is slow to list its pools
+	known := make(chan *xoEndpoint)
+	go func() {
+		known <- endpoints.poolEndpoint(t.Context(), uuid.FromStringOrNil(pool1ID))
+	}()
+
+	select {
+	case endpoint := <-known:
+		assert.Same(t, dc1, endpoint)
+	case <-time.After(time.Second):
+		t.Fatal("the lookup of a known pool waited for the pool discovery")
+	}
+
+	close(unblock)
+	assert.Same(t, dc2, <-discovered)
+
+	// The pool discovery is not run again before poolDiscoveryInterval
+	assert.Nil(t, endpoints.poolEndpoint(t.Context(), uuid.FromStringOrNil(pool3ID)))
+}
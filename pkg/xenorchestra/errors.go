@@ -0,0 +1,156 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+)
+
+// Classes of the errors returned by Xen Orchestra, match them with errors.Is.
+var (
+	// ErrXONotFound is returned when the object does not exist.
+	ErrXONotFound = errors.New("xo: not found")
+	// ErrXOUnauthorized is returned when the API token is missing or expired.
+	ErrXOUnauthorized = errors.New("xo: unauthorized")
+	// ErrXOForbidden is returned when the API token lacks a permission.
+	ErrXOForbidden = errors.New("xo: forbidden")
+	// ErrXOTransient is returned on timeouts, connection errors and server errors, the request can be retried.
+	ErrXOTransient = errors.New("xo: transient error")
+	// ErrXORateLimited is returned when Xen Orchestra throttles the requests, the request can be retried later.
+	ErrXORateLimited = errors.New("xo: rate limited")
+	// ErrXOCircuitOpen is returned without reaching Xen Orchestra while its requests are failing, it is also an ErrXOTransient.
+	ErrXOCircuitOpen = errors.New("xo: circuit breaker open")
+	// ErrInvalidProviderID is returned when a node providerID cannot be parsed.
+	ErrInvalidProviderID = errors.New("invalid providerID")
+	// ErrDeletionBlocked is returned instead of reporting a VM as deleted while too many VMs are not found.
+	ErrDeletionBlocked = errors.New("deletion blocked by the mass-deletion guard")
+	// ErrDeletionUnconfirmed is returned instead of reporting a VM as deleted until it has stayed not found for the confirmation period.
+	ErrDeletionUnconfirmed = errors.New("deletion not confirmed yet")
+)
+
+// errWaitingForXO is returned while Xen Orchestra could not be reached since the startup, it is also an ErrXOTransient.
+var errWaitingForXO = errors.New("waiting for the connection to Xen Orchestra")
+
+// apiErrorStatus matches the status code of the REST API errors, for example "API error: 404 Not Found - ...".
+var apiErrorStatus = regexp.MustCompile(`API error: (\d{3})`)
+
+// xoError is an error returned by Xen Orchestra with its class.
+type xoError struct {
+	class error
+	err   error
+}
+
+func (e *xoError) Error() string {
+	return e.err.Error()
+}
+
+func (e *xoError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// classifyXOError wraps the error with its class. Errors of an unknown class are returned unchanged.
+func classifyXOError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *xoError
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	if class := xoErrorClass(err); class != nil {
+		return &xoError{class: class, err: err}
+	}
+
+	return err
+}
+
+// invalidProviderIDError wraps a providerID parsing error.
+func invalidProviderIDError(err error) error {
+	return &xoError{class: ErrInvalidProviderID, err: err}
+}
+
+// xoErrorClass returns the class of the error, or nil if it is unknown.
+// The REST client does not return typed errors, so the HTTP status code is read from the message.
+func xoErrorClass(err error) error {
+	var classified *xoError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+
+	var notFound client.NotFound
+	if errors.As(err, &notFound) {
+		return ErrXONotFound
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrXOTransient
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrXOTransient
+	}
+
+	if match := apiErrorStatus.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+
+		return httpStatusClass(status)
+	}
+
+	// The REST client flattens the transport errors into the message
+	if msg := err.Error(); strings.Contains(msg, "failed to do request") || strings.Contains(msg, "context deadline exceeded") {
+		return ErrXOTransient
+	}
+
+	return nil
+}
+
+func httpStatusClass(status int) error {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrXONotFound
+	case status == http.StatusUnauthorized:
+		return ErrXOUnauthorized
+	case status == http.StatusForbidden:
+		return ErrXOForbidden
+	case status == http.StatusTooManyRequests:
+		return ErrXORateLimited
+	case status == http.StatusRequestTimeout, status >= http.StatusInternalServerError:
+		return ErrXOTransient
+	}
+
+	return nil
+}
+
+// isXORetryable reports whether the request failed for a reason that can go away by itself.
+func isXORetryable(err error) bool {
+	return errors.Is(err, ErrXOTransient) || errors.Is(err, ErrXORateLimited)
+}
+
+// isXOAuthError reports whether the request was refused because of the API token.
+func isXOAuthError(err error) bool {
+	return errors.Is(err, ErrXOUnauthorized) || errors.Is(err, ErrXOForbidden)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/config"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/services/vm"
+	v2client "github.com/vatesfr/xenorchestra-go-sdk/v2/client"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const testXOURL = "http://xo.example.test"
+
+func TestXOErrorClass(t *testing.T) {
+	restClient, err := v2client.New(&config.Config{Url: testXOURL, Token: "token"})
+	require.NoError(t, err)
+
+	httpmock.ActivateNonDefault(restClient.HttpClient)
+	defer httpmock.DeactivateAndReset()
+
+	vmURL := testXOURL + "/rest/v0/vms/" + vmPool1Node1ID
+
+	tests := []struct {
+		name      string
+		responder httpmock.Responder
+		expected  error
+		retryable bool
+	}{
+		{
+			name:      "not found",
+			responder: httpmock.NewStringResponder(http.StatusNotFound, `{"error": "no such VM"}`),
+			expected:  ErrXONotFound,
+		},
+		{
+			name:      "unauthorized",
+			responder: httpmock.NewStringResponder(http.StatusUnauthorized, `{"error": "invalid token"}`),
+			expected:  ErrXOUnauthorized,
+		},
+		{
+			name:      "forbidden",
+			responder: httpmock.NewStringResponder(http.StatusForbidden, `{"error": "not enough permissions"}`),
+			expected:  ErrXOForbidden,
+		},
+		{
+			name:      "rate limited",
+			responder: httpmock.NewStringResponder(http.StatusTooManyRequests, ""),
+			expected:  ErrXORateLimited,
+			retryable: true,
+		},
+		{
+			name:      "server error",
+			responder: httpmock.NewStringResponder(http.StatusServiceUnavailable, ""),
+			expected:  ErrXOTransient,
+			retryable: true,
+		},
+		{
+			name:      "connection error",
+			responder: httpmock.NewErrorResponder(errors.New("connection refused")),
+			expected:  ErrXOTransient,
+			retryable: true,
+		},
+	}
+
+	vmService := vm.New(restClient, nil, nil, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.RegisterResponder(http.MethodGet, vmURL, tt.responder)
+
+			_, err := vmService.GetByID(t.Context(), uuid.FromStringOrNil(vmPool1Node1ID))
+			require.Error(t, err)
+
+			err = classifyXOError(err)
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Equal(t, tt.retryable, isXORetryable(err))
+		})
+	}
+
+	assert.NoError(t, classifyXOError(nil))
+	assert.ErrorIs(t, classifyXOError(client.NotFound{Query: client.Vm{}}), ErrXONotFound)
+
+	unknown := errors.New("unexpected response")
+	assert.Equal(t, unknown, classifyXOError(unknown))
+
+	wrapped := fmt.Errorf("get vm: %w", invalidProviderIDError(errors.New("bad uuid")))
+	assert.ErrorIs(t, classifyXOError(wrapped), ErrInvalidProviderID)
+}
+
+func TestInstancesErrorClasses(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectExistsErr error
+	}{
+		{
+			name: "not found deletes the node",
+			err:  errors.New("API error: 404 Not Found - {}"),
+		},
+		{
+			name:            "unauthorized keeps the node",
+			err:             errors.New("API error: 401 Unauthorized - {}"),
+			expectExistsErr: ErrXOUnauthorized,
+		},
+		{
+			name:            "server error keeps the node",
+			err:             errors.New("API error: 502 Bad Gateway - {}"),
+			expectExistsErr: ErrXOTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			mockVM := mock_library.NewMockVM(ctrl)
+			mockVM.EXPECT().GetByID(gomock.Any(), gomock.Any()).Return(nil, tt.err).AnyTimes()
+
+			mockLib := mock_library.NewMockLibrary(ctrl)
+			mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+			i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{})
+			node := nodeFromProviderID(providerURIPool1Node1)
+
+			exists, err := i.InstanceExists(t.Context(), node)
+			assert.False(t, exists)
+
+			_, shutdownErr := i.InstanceShutdown(t.Context(), node)
+
+			if tt.expectExistsErr != nil {
+				assert.ErrorIs(t, err, tt.expectExistsErr)
+				assert.ErrorIs(t, shutdownErr, tt.expectExistsErr)
+			} else {
+				assert.NoError(t, err)
+				assert.EqualError(t, shutdownErr, "vm not found: "+providerURIPool1Node1)
+			}
+		})
+	}
+
+	_, err := newInstances(&xok8s.XoClient{}, &cloudConfig{}).GetInstance(t.Context(), &v1.Node{
+		Spec: v1.NodeSpec{ProviderID: xok8s.ProviderName + "://bad/id"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidProviderID)
+	assert.NotErrorIs(t, err, cloudprovider.InstanceNotFound)
+}
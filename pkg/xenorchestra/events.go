@@ -0,0 +1,278 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sourcegraph/jsonrpc2"
+	jsonrpc2ws "github.com/sourcegraph/jsonrpc2/websocket"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// eventsStableConnection is the duration after which a connection is considered stable,
+// the reconnection backoff is then reset.
+const eventsStableConnection = time.Minute
+
+// eventsConfig is the Events section of the cloud config.
+type eventsConfig struct {
+	// Disabled turns the Xen Orchestra event subscription off, the node labels are then only synced periodically.
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// vmEvent is a VM change reported by Xen Orchestra.
+type vmEvent struct {
+	ID uuid.UUID
+	// Removed is set when the VM was deleted.
+	Removed bool
+
+	Container  string
+	PoolID     string
+	PowerState string
+	Tags       []string
+}
+
+// eventSource streams the VM changes of Xen Orchestra.
+type eventSource interface {
+	// watch calls the handler for each VM change until the stream fails or the context is done.
+	watch(ctx context.Context, handler func(vmEvent)) error
+}
+
+// vmWatcher filters the VM changes down to the ones that affect the node labels,
+// and reconnects to the event source with a backoff.
+type vmWatcher struct {
+	source  eventSource
+	backoff wait.Backoff
+
+	mu     sync.Mutex
+	states map[uuid.UUID]vmEvent
+}
+
+func newVMWatcher(source eventSource) *vmWatcher {
+	return &vmWatcher{
+		source: source,
+		backoff: wait.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+			Cap:      5 * time.Minute,
+		},
+		states: map[uuid.UUID]vmEvent{},
+	}
+}
+
+// run calls onChange with the UUID of each VM whose host, pool, power state or tags changed, until the context is done.
+func (w *vmWatcher) run(ctx context.Context, onChange func(uuid.UUID)) {
+	backoff := w.backoff
+
+	for {
+		start := time.Now()
+		err := w.source.watch(ctx, func(event vmEvent) {
+			if w.changed(event) {
+				onChange(event.ID)
+			}
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The changes were missed while disconnected, the next event of each VM is reported
+		w.mu.Lock()
+		w.states = map[uuid.UUID]vmEvent{}
+		w.mu.Unlock()
+
+		if time.Since(start) > eventsStableConnection {
+			backoff = w.backoff
+		}
+
+		delay := backoff.Step()
+		klog.ErrorS(err, "Xen Orchestra event stream disconnected, reconnecting", "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// changed records the VM state and reports whether it changed since the previous event.
+func (w *vmWatcher) changed(event vmEvent) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if event.Removed {
+		delete(w.states, event.ID)
+
+		return true
+	}
+
+	previous, ok := w.states[event.ID]
+	w.states[event.ID] = event
+
+	return !ok ||
+		previous.Container != event.Container ||
+		previous.PoolID != event.PoolID ||
+		previous.PowerState != event.PowerState ||
+		!slices.Equal(previous.Tags, event.Tags)
+}
+
+// wsEventSource subscribes to the object changes of the Xen Orchestra JSON-RPC API.
+type wsEventSource struct {
+	// config returns the connection settings, and a channel closed when the endpoint fails over to another URL.
+	config func() (xok8s.XoConfig, <-chan struct{})
+}
+
+func newWSEventSource(config func() (xok8s.XoConfig, <-chan struct{})) *wsEventSource {
+	return &wsEventSource{config: config}
+}
+
+// xoObject is the part of an XO object used by the events.
+type xoObject struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Container  string   `json:"$container"`
+	PoolID     string   `json:"$pool"`
+	PowerState string   `json:"power_state"`
+	Tags       []string `json:"tags"`
+}
+
+// xoObjectsNotification is the "all" notification sent by Xen Orchestra when objects are added, updated or removed.
+type xoObjectsNotification struct {
+	Type  string                     `json:"type"`
+	Items map[string]json.RawMessage `json:"items"`
+}
+
+func (s *wsEventSource) watch(ctx context.Context, handler func(vmEvent)) error {
+	config, switched := s.config()
+
+	url := strings.TrimSuffix(config.URL, "/")
+	if rest, ok := strings.CutPrefix(url, "http"); ok {
+		url = "ws" + rest
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: config.Insecure}, // #nosec G402 -- opt-in through the insecure setting
+	}
+
+	ws, _, err := dialer.DialContext(ctx, url+"/api/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Xen Orchestra API: %w", classifyXOError(err))
+	}
+
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2ws.NewObjectStream(ws), jsonrpc2.HandlerWithError(
+		func(_ context.Context, _ *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
+			if req.Method == "all" && req.Params != nil {
+				handleObjectsNotification(*req.Params, handler)
+			}
+
+			return nil, nil
+		},
+	))
+	defer conn.Close() //nolint:errcheck
+
+	params := map[string]any{"token": config.Token}
+	if config.Token == "" {
+		params = map[string]any{"email": config.Username, "password": config.Password}
+	}
+
+	if err := conn.Call(ctx, "session.signIn", params, nil); err != nil {
+		return fmt.Errorf("failed to sign in to the Xen Orchestra API: %v", err)
+	}
+
+	klog.V(2).InfoS("Subscribed to the Xen Orchestra events")
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-conn.DisconnectNotify():
+		return fmt.Errorf("event stream closed: %w", ErrXOTransient)
+	case <-switched:
+		return fmt.Errorf("endpoint switched to another URL: %w", ErrXOTransient)
+	}
+}
+
+func handleObjectsNotification(params json.RawMessage, handler func(vmEvent)) {
+	notification := xoObjectsNotification{}
+	if err := json.Unmarshal(params, &notification); err != nil {
+		klog.ErrorS(err, "failed to decode the Xen Orchestra event")
+
+		return
+	}
+
+	for _, item := range notification.Items {
+		object := xoObject{}
+		if err := json.Unmarshal(item, &object); err != nil || object.Type != "VM" {
+			continue
+		}
+
+		id, err := uuid.FromString(object.ID)
+		if err != nil {
+			continue
+		}
+
+		handler(vmEvent{
+			ID:         id,
+			Removed:    notification.Type == "exit",
+			Container:  object.Container,
+			PoolID:     object.PoolID,
+			PowerState: object.PowerState,
+			Tags:       object.Tags,
+		})
+	}
+}
+
+// WatchInstances calls onChange with the UUID of each VM whose host, pool, power state or tags changed,
+// until the context is done. It returns false when the event subscription is disabled.
+func (i *instances) WatchInstances(ctx context.Context, onChange func(vmID string)) bool {
+	watching := false
+
+	for _, endpoint := range i.endpoints.list {
+		if endpoint.watcher == nil {
+			continue
+		}
+
+		go endpoint.watcher.run(ctx, func(id uuid.UUID) {
+			// Serve the next lookup from Xen Orchestra, the cached VM is outdated
+			endpoint.cache.invalidateVM(id)
+
+			onChange(id.String())
+		})
+
+		watching = true
+	}
+
+	return watching
+}
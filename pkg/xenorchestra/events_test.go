@@ -0,0 +1,231 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeEventSource replays a list of event streams, one per connection.
+type fakeEventSource struct {
+	mu      sync.Mutex
+	streams [][]vmEvent
+	calls   int
+}
+
+func (s *fakeEventSource) watch(ctx context.Context, handler func(vmEvent)) error {
+	s.mu.Lock()
+	s.calls++
+
+	if len(s.streams) == 0 {
+		s.mu.Unlock()
+		<-ctx.Done()
+
+		return nil
+	}
+
+	stream := s.streams[0]
+	s.streams = s.streams[1:]
+	s.mu.Unlock()
+
+	for _, event := range stream {
+		handler(event)
+	}
+
+	return errors.New("connection reset")
+}
+
+func TestVMWatcher(t *testing.T) {
+	vm1 := uuid.FromStringOrNil(vmPool1Node1ID)
+	vm2 := uuid.FromStringOrNil(vmPool2Node1ID)
+
+	running := vmEvent{ID: vm1, Container: host1ID, PoolID: pool1ID, PowerState: "Running", Tags: []string{"env=prod"}}
+	migrated := running
+	migrated.Container = host2ID
+	retagged := migrated
+	retagged.Tags = []string{"env=prod", "rack=R12"}
+
+	source := &fakeEventSource{streams: [][]vmEvent{
+		{
+			running,
+			running, // unchanged
+			migrated,
+			retagged,
+			{ID: vm2, Removed: true},
+		},
+		{
+			retagged, // reported again after the reconnection
+		},
+	}}
+
+	watcher := newVMWatcher(source)
+	watcher.backoff = wait.Backoff{Duration: time.Millisecond, Steps: 10}
+
+	var (
+		mu      sync.Mutex
+		changed []uuid.UUID
+	)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	go watcher.run(ctx, func(id uuid.UUID) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		changed = append(changed, id)
+	})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(changed) == 5
+	}, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []uuid.UUID{vm1, vm1, vm1, vm2, vm1}, changed)
+	mu.Unlock()
+
+	source.mu.Lock()
+	assert.Equal(t, 3, source.calls, "the watcher reconnects after each disconnection")
+	source.mu.Unlock()
+}
+
+func TestWSEventSource(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/", r.URL.Path)
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ws.Close() //nolint:errcheck
+
+		signIn := map[string]any{}
+		if !assert.NoError(t, ws.ReadJSON(&signIn)) {
+			return
+		}
+
+		assert.Equal(t, "session.signIn", signIn["method"])
+		assert.Equal(t, map[string]any{"token": "token"}, signIn["params"])
+
+		assert.NoError(t, ws.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": signIn["id"], "result": map[string]any{"id": "user"}}))
+		assert.NoError(t, ws.WriteJSON(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "all",
+			"params": map[string]any{
+				"type": "enter",
+				"items": map[string]any{
+					vmPool1Node1ID: map[string]any{
+						"type":        "VM",
+						"id":          vmPool1Node1ID,
+						"$container":  host2ID,
+						"$pool":       pool1ID,
+						"power_state": "Running",
+						"tags":        []string{"env=prod"},
+					},
+					host1ID: map[string]any{"type": "host", "id": host1ID},
+				},
+			},
+		}))
+		assert.NoError(t, ws.WriteJSON(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "all",
+			"params":  json.RawMessage(`{"type": "exit", "items": {"` + vmPool2Node1ID + `": {"type": "VM", "id": "` + vmPool2Node1ID + `"}}}`),
+		}))
+	}))
+	defer server.Close()
+
+	source := newWSEventSource(func() (xok8s.XoConfig, <-chan struct{}) {
+		return xok8s.XoConfig{URL: server.URL, Token: "token"}, nil
+	})
+
+	events := []vmEvent{}
+	err := source.watch(t.Context(), func(event vmEvent) {
+		events = append(events, event)
+	})
+	assert.ErrorIs(t, err, ErrXOTransient, "a closed stream is reconnected")
+
+	require.Len(t, events, 2)
+	assert.Equal(t, vmEvent{
+		ID:         uuid.FromStringOrNil(vmPool1Node1ID),
+		Container:  host2ID,
+		PoolID:     pool1ID,
+		PowerState: "Running",
+		Tags:       []string{"env=prod"},
+	}, events[0])
+	assert.Equal(t, vmEvent{ID: uuid.FromStringOrNil(vmPool2Node1ID), Removed: true}, events[1])
+}
+
+func TestWSEventSourceSwitch(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ws.Close() //nolint:errcheck
+
+		signIn := map[string]any{}
+		if !assert.NoError(t, ws.ReadJSON(&signIn)) {
+			return
+		}
+
+		assert.NoError(t, ws.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": signIn["id"], "result": map[string]any{"id": "user"}}))
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	switched := make(chan struct{})
+	source := newWSEventSource(func() (xok8s.XoConfig, <-chan struct{}) {
+		return xok8s.XoConfig{URL: server.URL, Token: "token"}, switched
+	})
+
+	close(switched)
+
+	// The subscription is reconnected to the new active URL
+	err := source.watch(t.Context(), func(vmEvent) {})
+	assert.ErrorIs(t, err, ErrXOTransient)
+	assert.ErrorContains(t, err, "switched to another URL")
+}
+
+func TestWatchInstancesDisabled(t *testing.T) {
+	i := newInstances(&xok8s.XoClient{}, &cloudConfig{Events: eventsConfig{Disabled: true}})
+
+	assert.False(t, i.WatchInstances(t.Context(), func(string) {}))
+}
@@ -0,0 +1,561 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	v1 "github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/services/library"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// failoverProbeInterval is the delay between two health probes of the failover URLs.
+	failoverProbeInterval = 15 * time.Second
+	// failoverProbeTimeout bounds each health probe.
+	failoverProbeTimeout = 10 * time.Second
+)
+
+var (
+	endpointActiveURL = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "endpoint",
+			Name:           "active_url",
+			Help:           "Whether the Xen Orchestra URL serves the requests of the endpoint (1) or not (0).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint", "url"},
+	)
+	endpointURLUp = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "endpoint",
+			Name:           "url_up",
+			Help:           "Whether the last health probe of the Xen Orchestra URL succeeded (1) or not (0).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint", "url"},
+	)
+	endpointFailovers = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "endpoint",
+			Name:           "failovers_total",
+			Help:           "Number of times the endpoint switched to another Xen Orchestra URL.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(endpointActiveURL, endpointURLUp, endpointFailovers)
+}
+
+// validateFailoverURLs checks the failover URLs of the Xen Orchestra instance with the url.
+func validateFailoverURLs(url string, failoverURLs []string) error {
+	seen := map[string]bool{url: true}
+
+	for _, failoverURL := range failoverURLs {
+		if !strings.HasPrefix(failoverURL, "http") {
+			return fmt.Errorf("failoverURLs must be http or https URLs, got %q", failoverURL)
+		}
+
+		if seen[failoverURL] {
+			return fmt.Errorf("failoverURLs: duplicate URL %q", failoverURL)
+		}
+
+		seen[failoverURL] = true
+	}
+
+	return nil
+}
+
+// failoverURL is one of the equivalent URLs of a Xen Orchestra instance.
+type failoverURL struct {
+	config xok8s.XoConfig
+	// client is nil until the client could be created, the prober retries it.
+	client  library.Library
+	healthy bool
+}
+
+// failoverClient is a Xen Orchestra client with equivalent URLs, for example XOA and an XO proxy.
+// The requests are sent to the active URL: the client fails over to the next healthy URL when the
+// active one is unreachable, and the prober fails back once a preferred URL recovers.
+type failoverClient struct {
+	endpoint  string
+	newClient func(*xok8s.XoConfig) (library.Library, error)
+	probe     func(context.Context, library.Library) error
+
+	mu     sync.RWMutex
+	urls   []*failoverURL
+	active int
+	// switched is closed when the active URL changes, the event subscription then reconnects.
+	switched chan struct{}
+	// onSwitch is called when the active URL changes, it is nil until the cloud provider is initialized.
+	onSwitch func(from, to string, err error)
+}
+
+func newXOLibrary(config *xok8s.XoConfig) (library.Library, error) {
+	client, err := xok8s.NewXOClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Client, nil
+}
+
+func probeXOLibrary(ctx context.Context, client library.Library) error {
+	_, err := client.VM().GetAll(ctx, 1, "")
+
+	return err
+}
+
+// newFailoverClient creates the clients of the URLs, the first URL that has a client is active.
+func newFailoverClient(
+	endpoint string,
+	config xok8s.XoConfig,
+	failoverURLs []string,
+	newClient func(*xok8s.XoConfig) (library.Library, error),
+) (*failoverClient, error) {
+	f := &failoverClient{
+		endpoint:  endpoint,
+		newClient: newClient,
+		probe:     probeXOLibrary,
+		active:    -1,
+		switched:  make(chan struct{}),
+	}
+
+	var firstErr error
+
+	for idx, url := range append([]string{config.URL}, failoverURLs...) {
+		urlConfig := config
+		urlConfig.URL = url
+
+		client, err := newClient(&urlConfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to create the Xen Orchestra client", "endpoint", endpoint, "url", url)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else if f.active < 0 {
+			f.active = idx
+		}
+
+		f.urls = append(f.urls, &failoverURL{config: urlConfig, client: client, healthy: err == nil})
+		endpointURLUp.WithLabelValues(endpoint, url).Set(boolGauge(err == nil))
+	}
+
+	if f.active < 0 {
+		return nil, firstErr
+	}
+
+	for idx, url := range f.urls {
+		endpointActiveURL.WithLabelValues(endpoint, url.config.URL).Set(boolGauge(idx == f.active))
+	}
+
+	return f, nil
+}
+
+func boolGauge(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// config returns the connection settings of the active URL.
+func (f *failoverClient) config() xok8s.XoConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.urls[f.active].config
+}
+
+// watchConfig returns the connection settings of the active URL, and a channel closed when the active URL changes.
+func (f *failoverClient) watchConfig() (xok8s.XoConfig, <-chan struct{}) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.urls[f.active].config, f.switched
+}
+
+// current returns the active URL and its client.
+func (f *failoverClient) current() (int, library.Library) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.active, f.urls[f.active].client
+}
+
+// setOnSwitch sets the function called when the active URL changes.
+func (f *failoverClient) setOnSwitch(onSwitch func(from, to string, err error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onSwitch = onSwitch
+}
+
+// failover marks the URL as unhealthy and switches to the next healthy URL.
+// It returns the client to retry the request with, or false when no other URL is healthy.
+func (f *failoverClient) failover(from int, err error) (library.Library, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.urls[from].healthy = false
+	endpointURLUp.WithLabelValues(f.endpoint, f.urls[from].config.URL).Set(0)
+
+	// A concurrent request already switched
+	if f.active != from {
+		return f.urls[f.active].client, true
+	}
+
+	for offset := 1; offset < len(f.urls); offset++ {
+		idx := (from + offset) % len(f.urls)
+		if f.urls[idx].healthy && f.urls[idx].client != nil {
+			f.switchTo(idx, err)
+
+			return f.urls[idx].client, true
+		}
+	}
+
+	return nil, false
+}
+
+// switchTo makes the URL active, f.mu must be held.
+func (f *failoverClient) switchTo(idx int, err error) {
+	from, to := f.urls[f.active].config.URL, f.urls[idx].config.URL
+
+	endpointActiveURL.WithLabelValues(f.endpoint, from).Set(0)
+	endpointActiveURL.WithLabelValues(f.endpoint, to).Set(1)
+	endpointFailovers.WithLabelValues(f.endpoint).Inc()
+
+	if err != nil {
+		klog.ErrorS(err, "Xen Orchestra URL is unreachable, failing over", "endpoint", f.endpoint, "from", from, "to", to)
+	} else {
+		klog.InfoS("Xen Orchestra URL recovered, failing back", "endpoint", f.endpoint, "from", from, "to", to)
+	}
+
+	f.active = idx
+
+	close(f.switched)
+	f.switched = make(chan struct{})
+
+	if f.onSwitch != nil {
+		go f.onSwitch(from, to, err)
+	}
+}
+
+// run probes the URLs until the context is done.
+func (f *failoverClient) run(ctx context.Context) {
+	wait.UntilWithContext(ctx, f.probeAll, failoverProbeInterval)
+}
+
+// probeAll checks each URL and activates the first healthy one, so that the client fails back
+// to the preferred URL once it recovers.
+func (f *failoverClient) probeAll(ctx context.Context) {
+	for idx := range f.urls {
+		f.mu.RLock()
+		url := *f.urls[idx]
+		f.mu.RUnlock()
+
+		err := f.probeURL(ctx, &url)
+		if err != nil {
+			klog.V(2).InfoS("Xen Orchestra URL health probe failed", "endpoint", f.endpoint, "url", url.config.URL, "err", err)
+		}
+
+		f.mu.Lock()
+		f.urls[idx].client = url.client
+		f.urls[idx].healthy = err == nil
+		f.mu.Unlock()
+
+		endpointURLUp.WithLabelValues(f.endpoint, url.config.URL).Set(boolGauge(err == nil))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx, url := range f.urls {
+		if url.healthy {
+			if idx != f.active {
+				f.switchTo(idx, nil)
+			}
+
+			return
+		}
+	}
+}
+
+// probeURL creates the client of the URL when it is missing, and checks the connection.
+func (f *failoverClient) probeURL(ctx context.Context, url *failoverURL) error {
+	if url.client == nil {
+		client, err := f.newClient(&url.config)
+		if err != nil {
+			return err
+		}
+
+		url.client = client
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, failoverProbeTimeout)
+	defer cancel()
+
+	return f.probe(ctx, url.client)
+}
+
+// failoverDo runs the request on the active URL, and once more on the next healthy URL when
+// the active one is unreachable.
+func failoverDo[T any](ctx context.Context, f *failoverClient, request func(library.Library) (T, error)) (T, error) {
+	idx, client := f.current()
+
+	result, err := request(client)
+	if err == nil || ctx.Err() != nil || !errors.Is(classifyXOError(err), ErrXOTransient) {
+		return result, err
+	}
+
+	next, ok := f.failover(idx, err)
+	if !ok {
+		return result, err
+	}
+
+	return request(next)
+}
+
+func (f *failoverClient) VM() library.VM {
+	_, client := f.current()
+
+	return &failoverVM{VM: client.VM(), f: f}
+}
+
+func (f *failoverClient) Host() library.Host {
+	_, client := f.current()
+
+	return &failoverHost{Host: client.Host(), f: f}
+}
+
+func (f *failoverClient) Pool() library.Pool {
+	_, client := f.current()
+
+	return &failoverPool{Pool: client.Pool(), f: f}
+}
+
+func (f *failoverClient) Task() library.Task {
+	_, client := f.current()
+
+	return client.Task()
+}
+
+func (f *failoverClient) VDI() library.VDI {
+	_, client := f.current()
+
+	return client.VDI()
+}
+
+func (f *failoverClient) VBD() library.VBD {
+	_, client := f.current()
+
+	return client.VBD()
+}
+
+func (f *failoverClient) PBD() library.PBD {
+	_, client := f.current()
+
+	return client.PBD()
+}
+
+func (f *failoverClient) SR() library.SR {
+	_, client := f.current()
+
+	return client.SR()
+}
+
+// V1Client returns the JSON-RPC client of the active URL. The requests fail over to the next URL when the
+// client of the active URL could not connect.
+func (f *failoverClient) V1Client() v1.XOClient {
+	_, client := f.current()
+
+	return &failoverV1Client{XOClient: client.V1Client(), f: f}
+}
+
+// failoverVM fails over the VM lookups, the other requests are sent to the active URL.
+type failoverVM struct {
+	library.VM
+
+	f *failoverClient
+}
+
+func (s *failoverVM) GetByID(ctx context.Context, id uuid.UUID) (*payloads.VM, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) (*payloads.VM, error) {
+		return client.VM().GetByID(ctx, id)
+	})
+}
+
+func (s *failoverVM) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.VM, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) ([]*payloads.VM, error) {
+		return client.VM().GetAll(ctx, limit, filter)
+	})
+}
+
+// failoverHost fails over the host lookups.
+type failoverHost struct {
+	library.Host
+
+	f *failoverClient
+}
+
+func (s *failoverHost) Get(ctx context.Context, id uuid.UUID) (*payloads.Host, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) (*payloads.Host, error) {
+		return client.Host().Get(ctx, id)
+	})
+}
+
+func (s *failoverHost) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.Host, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) ([]*payloads.Host, error) {
+		return client.Host().GetAll(ctx, limit, filter)
+	})
+}
+
+// failoverPool fails over the pool lookups.
+type failoverPool struct {
+	library.Pool
+
+	f *failoverClient
+}
+
+func (s *failoverPool) Get(ctx context.Context, id uuid.UUID) (*payloads.Pool, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) (*payloads.Pool, error) {
+		return client.Pool().Get(ctx, id)
+	})
+}
+
+func (s *failoverPool) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.Pool, error) {
+	return failoverDo(ctx, s.f, func(client library.Library) ([]*payloads.Pool, error) {
+		return client.Pool().GetAll(ctx, limit, filter)
+	})
+}
+
+// errV1ClientUnavailable is returned when the JSON-RPC client of a URL could not connect, the SDK does not retry it.
+var errV1ClientUnavailable = errors.New("the Xen Orchestra JSON-RPC client is not connected")
+
+// v1ClientOf returns the JSON-RPC client of the URL, or a transient error so that the request fails over
+// to the next URL when the client could not connect.
+func v1ClientOf(client library.Library) (v1.XOClient, error) {
+	v1Client := client.V1Client()
+	if v1Client == nil {
+		return nil, &xoError{class: ErrXOTransient, err: errV1ClientUnavailable}
+	}
+
+	return v1Client, nil
+}
+
+// failoverV1Client fails over the JSON-RPC requests of the CCM, the JSON-RPC client has no context.
+type failoverV1Client struct {
+	v1.XOClient
+
+	f *failoverClient
+}
+
+func (c *failoverV1Client) GetVm(vmReq v1.Vm) (*v1.Vm, error) {
+	return failoverDo(context.Background(), c.f, func(client library.Library) (*v1.Vm, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return nil, err
+		}
+
+		return v1Client.GetVm(vmReq)
+	})
+}
+
+func (c *failoverV1Client) GetVIFs(vm *v1.Vm) ([]v1.VIF, error) {
+	return failoverDo(context.Background(), c.f, func(client library.Library) ([]v1.VIF, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return nil, err
+		}
+
+		return v1Client.GetVIFs(vm)
+	})
+}
+
+func (c *failoverV1Client) GetNetwork(netReq v1.Network) (*v1.Network, error) {
+	return failoverDo(context.Background(), c.f, func(client library.Library) (*v1.Network, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return nil, err
+		}
+
+		return v1Client.GetNetwork(netReq)
+	})
+}
+
+func (c *failoverV1Client) GetAllObjectsOfType(obj v1.XoObject, response interface{}) error {
+	_, err := failoverDo(context.Background(), c.f, func(client library.Library) (struct{}, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		return struct{}{}, v1Client.GetAllObjectsOfType(obj, response)
+	})
+
+	return err
+}
+
+func (c *failoverV1Client) GetTemplate(template v1.Template) ([]v1.Template, error) {
+	return failoverDo(context.Background(), c.f, func(client library.Library) ([]v1.Template, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return nil, err
+		}
+
+		return v1Client.GetTemplate(template)
+	})
+}
+
+func (c *failoverV1Client) Call(method string, params, result interface{}) error {
+	_, err := failoverDo(context.Background(), c.f, func(client library.Library) (struct{}, error) {
+		v1Client, err := v1ClientOf(client)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		caller, ok := v1Client.(jsonRPCCaller)
+		if !ok {
+			return struct{}{}, fmt.Errorf("xen Orchestra client does not support JSON-RPC calls")
+		}
+
+		return struct{}{}, caller.Call(method, params, result)
+	})
+
+	return err
+}
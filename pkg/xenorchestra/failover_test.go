@@ -0,0 +1,313 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/services/library"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testPrimaryURL = "https://xoa.example.com"
+	testProxyURL   = "https://xo-proxy.example.com"
+)
+
+var errUnreachable = errors.New("failed to do request: dial tcp 192.0.2.10:443: connect: connection refused")
+
+type failoverSwitch struct {
+	from, to string
+	err      error
+}
+
+// failoverMocks returns a failover client over the mocked libraries of the URLs.
+func failoverMocks(t *testing.T, libraries map[string]library.Library) (*failoverClient, chan failoverSwitch) {
+	t.Helper()
+
+	f, err := newFailoverClient("dc1", xok8s.XoConfig{URL: testPrimaryURL, Token: "12ABC"}, []string{testProxyURL},
+		func(config *xok8s.XoConfig) (library.Library, error) {
+			return libraries[config.URL], nil
+		},
+	)
+	require.NoError(t, err)
+
+	switches := make(chan failoverSwitch, 10)
+	f.setOnSwitch(func(from, to string, err error) {
+		switches <- failoverSwitch{from: from, to: to, err: err}
+	})
+
+	return f, switches
+}
+
+func vmLibrary(ctrl *gomock.Controller, vm *payloads.VM, err error) *mock_library.MockLibrary {
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetByID(gomock.Any(), gomock.Any()).Return(vm, err).AnyTimes()
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	return mockLib
+}
+
+func TestFailoverClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	vm := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID)}
+	primary := vmLibrary(ctrl, nil, errUnreachable)
+	proxy := vmLibrary(ctrl, vm, nil)
+
+	f, switches := failoverMocks(t, map[string]library.Library{testPrimaryURL: primary, testProxyURL: proxy})
+	assert.Equal(t, testPrimaryURL, f.config().URL)
+
+	// The request is retried on the proxy
+	got, err := f.VM().GetByID(t.Context(), vm.ID)
+	require.NoError(t, err)
+	assert.Equal(t, vm, got)
+	assert.Equal(t, testProxyURL, f.config().URL)
+
+	event := <-switches
+	assert.Equal(t, testPrimaryURL, event.from)
+	assert.Equal(t, testProxyURL, event.to)
+	assert.ErrorIs(t, event.err, errUnreachable)
+
+	// The primary is not preferred until it recovers
+	f.probe = func(_ context.Context, client library.Library) error {
+		if client == primary {
+			return errUnreachable
+		}
+
+		return nil
+	}
+	f.probeAll(t.Context())
+	assert.Equal(t, testProxyURL, f.config().URL)
+
+	f.probe = func(context.Context, library.Library) error { return nil }
+	f.probeAll(t.Context())
+	assert.Equal(t, testPrimaryURL, f.config().URL, "the client fails back to the primary")
+
+	event = <-switches
+	assert.Equal(t, failoverSwitch{from: testProxyURL, to: testPrimaryURL}, event)
+}
+
+func TestFailoverClientErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// A missing VM is not a reason to fail over
+	notFound := errors.New("API error: 404 Not Found - {}")
+	f, _ := failoverMocks(t, map[string]library.Library{
+		testPrimaryURL: vmLibrary(ctrl, nil, notFound),
+		testProxyURL:   vmLibrary(ctrl, &payloads.VM{}, nil),
+	})
+
+	_, err := f.VM().GetByID(t.Context(), uuid.FromStringOrNil(vmPool1Node1ID))
+	assert.ErrorIs(t, err, notFound)
+	assert.Equal(t, testPrimaryURL, f.config().URL)
+
+	// The error is returned when no URL is reachable
+	f, _ = failoverMocks(t, map[string]library.Library{
+		testPrimaryURL: vmLibrary(ctrl, nil, errUnreachable),
+		testProxyURL:   vmLibrary(ctrl, nil, errUnreachable),
+	})
+
+	_, err = f.VM().GetByID(t.Context(), uuid.FromStringOrNil(vmPool1Node1ID))
+	assert.ErrorIs(t, err, errUnreachable)
+
+	_, err = f.VM().GetByID(t.Context(), uuid.FromStringOrNil(vmPool1Node1ID))
+	assert.ErrorIs(t, err, errUnreachable)
+}
+
+// unreachableV1Client fails the JSON-RPC requests as an unreachable Xen Orchestra.
+type unreachableV1Client struct {
+	fakeV1Client
+}
+
+func (c *unreachableV1Client) GetVm(client.Vm) (*client.Vm, error) {
+	return nil, errUnreachable
+}
+
+func (c *unreachableV1Client) Call(string, interface{}, interface{}) error {
+	return errUnreachable
+}
+
+func TestFailoverV1Client(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	primary := mock_library.NewMockLibrary(ctrl)
+	primary.EXPECT().V1Client().Return(&unreachableV1Client{}).AnyTimes()
+
+	proxyV1 := &fakeV1Client{vms: map[string]*client.Vm{vmPool1Node1ID: {Id: vmPool1Node1ID}}}
+	proxy := mock_library.NewMockLibrary(ctrl)
+	proxy.EXPECT().V1Client().Return(proxyV1).AnyTimes()
+
+	f, switches := failoverMocks(t, map[string]library.Library{testPrimaryURL: primary, testProxyURL: proxy})
+	_, switched := f.watchConfig()
+
+	// The JSON-RPC requests are retried on the proxy, the event subscription is notified of the switch
+	vm, err := f.V1Client().GetVm(client.Vm{Id: vmPool1Node1ID})
+	require.NoError(t, err)
+	assert.Equal(t, vmPool1Node1ID, vm.Id)
+	assert.Equal(t, testProxyURL, (<-switches).to)
+	assert.Equal(t, testProxyURL, f.config().URL)
+
+	select {
+	case <-switched:
+	default:
+		assert.Fail(t, "the event subscription is not notified of the switch")
+	}
+
+	config, switched := f.watchConfig()
+	assert.Equal(t, testProxyURL, config.URL)
+
+	// The xenstore writes are sent to the active URL
+	require.NoError(t, setXenstoreData(&xok8s.XoClient{Client: f}, uuid.FromStringOrNil(vmPool1Node1ID), map[string]any{"key": "value"}))
+	require.Len(t, proxyV1.calls, 1)
+
+	select {
+	case <-switched:
+		assert.Fail(t, "the active URL did not change")
+	default:
+	}
+}
+
+func TestFailoverV1ClientNotConnected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The JSON-RPC client of the proxy could not connect, the SDK returns a nil client
+	primary := mock_library.NewMockLibrary(ctrl)
+	primary.EXPECT().V1Client().Return(&unreachableV1Client{}).AnyTimes()
+
+	proxy := mock_library.NewMockLibrary(ctrl)
+	proxy.EXPECT().V1Client().Return(nil).AnyTimes()
+
+	f, _ := failoverMocks(t, map[string]library.Library{testPrimaryURL: primary, testProxyURL: proxy})
+
+	_, err := f.V1Client().GetVm(client.Vm{Id: vmPool1Node1ID})
+	require.ErrorIs(t, err, ErrXOTransient)
+	assert.ErrorIs(t, err, errV1ClientUnavailable)
+	assert.Equal(t, testProxyURL, f.config().URL)
+
+	assert.ErrorIs(t, setXenstoreData(&xok8s.XoClient{Client: f}, uuid.FromStringOrNil(vmPool1Node1ID), map[string]any{}), ErrXOTransient)
+
+	// The primary JSON-RPC client could not connect, the requests fail over to the proxy
+	proxyV1 := &fakeV1Client{vms: map[string]*client.Vm{vmPool1Node1ID: {Id: vmPool1Node1ID}}}
+
+	primary = mock_library.NewMockLibrary(ctrl)
+	primary.EXPECT().V1Client().Return(nil).AnyTimes()
+
+	proxy = mock_library.NewMockLibrary(ctrl)
+	proxy.EXPECT().V1Client().Return(proxyV1).AnyTimes()
+
+	f, _ = failoverMocks(t, map[string]library.Library{testPrimaryURL: primary, testProxyURL: proxy})
+
+	vm, err := f.V1Client().GetVm(client.Vm{Id: vmPool1Node1ID})
+	require.NoError(t, err)
+	assert.Equal(t, vmPool1Node1ID, vm.Id)
+	assert.Equal(t, testProxyURL, f.config().URL)
+}
+
+func TestNewFailoverClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	proxy := vmLibrary(ctrl, nil, nil)
+
+	// The primary client cannot be created when username/password authentication fails
+	f, err := newFailoverClient("dc1", xok8s.XoConfig{URL: testPrimaryURL}, []string{testProxyURL},
+		func(config *xok8s.XoConfig) (library.Library, error) {
+			if config.URL == testPrimaryURL {
+				return nil, errUnreachable
+			}
+
+			return proxy, nil
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, testProxyURL, f.config().URL)
+
+	f.probe = func(context.Context, library.Library) error { return nil }
+	f.probeAll(t.Context())
+	assert.Equal(t, testProxyURL, f.config().URL, "the primary client is still missing")
+
+	_, err = newFailoverClient("dc1", xok8s.XoConfig{URL: testPrimaryURL}, []string{testProxyURL},
+		func(*xok8s.XoConfig) (library.Library, error) {
+			return nil, errUnreachable
+		},
+	)
+	assert.ErrorIs(t, err, errUnreachable)
+}
+
+func TestFailoverConfig(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://xoa.example.com
+token: "12ABC"
+failoverURLs:
+  - https://xo-proxy.example.com
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{testProxyURL}, cfg.FailoverURLs)
+
+	endpoints, err := buildXOEndpoints(&cfg)
+	require.NoError(t, err)
+
+	endpoint := endpoints.list[0]
+	require.NotNil(t, endpoint.failover)
+	assert.Equal(t, testPrimaryURL, endpoint.failover.config().URL)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://xoa.example.com
+token: "12ABC"
+failoverURLs:
+  - https://xoa.example.com
+`))
+	assert.EqualError(t, err, `failoverURLs: duplicate URL "https://xoa.example.com"`)
+
+	_, err = readCloudConfig(strings.NewReader(`
+endpoints:
+  - name: dc1
+    url: https://xoa.example.com
+    token: "12ABC"
+    failoverURLs: [xo-proxy.example.com]
+`))
+	assert.EqualError(t, err, `endpoints[0]: failoverURLs must be http or https URLs, got "xo-proxy.example.com"`)
+}
+
+func TestRecordCloudProviderEvent(t *testing.T) {
+	client := fake.NewClientset()
+
+	require.NoError(t, recordCloudProviderEvent(t.Context(), client, corev1.EventTypeWarning, eventActionFailover, eventReasonFailedOver, "failed over"))
+	require.NoError(t, recordCloudProviderEvent(t.Context(), client, corev1.EventTypeNormal, eventActionFailover, eventReasonFailedBack, "failed back"))
+
+	events, err := client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 2, "the events with another reason are not aggregated")
+
+	reasons := []string{events.Items[0].Reason, events.Items[1].Reason}
+	assert.ElementsMatch(t, []string{eventReasonFailedOver, eventReasonFailedBack}, reasons)
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/attribute"
+
+	v1 "github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/services/library"
+
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultCircuitBreakerFailureThreshold is the number of consecutive failures that opens the circuit breaker.
+	defaultCircuitBreakerFailureThreshold = 5
+	// defaultCircuitBreakerOpenDuration is how long the circuit breaker stays open before letting a probe request through.
+	defaultCircuitBreakerOpenDuration = 30 * time.Second
+)
+
+// circuitState is the state of a circuit breaker, it is the value of the state metric.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+var (
+	clientInFlightRequests = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "client",
+			Name:           "in_flight_requests",
+			Help:           "Number of Xen Orchestra requests in flight, by endpoint.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint"},
+	)
+	clientRateLimiterWait = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "client",
+			Name:           "rate_limiter_wait_seconds",
+			Help:           "Time the Xen Orchestra requests waited for the rate limiter and the concurrency cap, by endpoint.",
+			Buckets:        []float64{0.001, 0.01, 0.1, 0.5, 1, 2, 5, 10, 30},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint"},
+	)
+	clientCircuitBreakerState = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "client",
+			Name:           "circuit_breaker_state",
+			Help:           "State of the circuit breaker of the Xen Orchestra endpoint: 0 closed, 1 half-open, 2 open.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint"},
+	)
+	clientCircuitBreakerRejected = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "client",
+			Name:           "circuit_breaker_rejected_requests_total",
+			Help:           "Number of Xen Orchestra requests failed fast by the open circuit breaker, by endpoint.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(clientInFlightRequests, clientRateLimiterWait, clientCircuitBreakerState, clientCircuitBreakerRejected)
+}
+
+// rateLimitConfig is the RateLimit section of the cloud config.
+type rateLimitConfig struct {
+	// QPS is the sustained rate of Xen Orchestra requests of each endpoint, 0 disables the rate limiting.
+	QPS float32 `yaml:"qps,omitempty"`
+	// Burst is the number of requests allowed at once above the QPS, it defaults to twice the QPS.
+	Burst int `yaml:"burst,omitempty"`
+	// MaxInFlight caps the concurrent Xen Orchestra requests of each endpoint, 0 disables the cap.
+	MaxInFlight int `yaml:"maxInFlight,omitempty"`
+}
+
+func (c *rateLimitConfig) validate() error {
+	if c.QPS < 0 || c.Burst < 0 || c.MaxInFlight < 0 {
+		return fmt.Errorf("qps, burst and maxInFlight must be positive")
+	}
+
+	return nil
+}
+
+// circuitBreakerConfig is the CircuitBreaker section of the cloud config.
+type circuitBreakerConfig struct {
+	// Disabled turns the circuit breaker off, the requests always reach Xen Orchestra.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// FailureThreshold is the number of consecutive failures that opens the circuit, it defaults to 5.
+	FailureThreshold int `yaml:"failureThreshold,omitempty"`
+	// OpenDuration is how long the requests fail fast before a probe request is let through, it defaults to 30s.
+	OpenDuration time.Duration `yaml:"openDuration,omitempty"`
+}
+
+func (c *circuitBreakerConfig) validate() error {
+	if c.FailureThreshold < 0 || c.OpenDuration < 0 {
+		return fmt.Errorf("failureThreshold and openDuration must be positive")
+	}
+
+	return nil
+}
+
+// requestGuard throttles the requests of an endpoint and fails them fast while Xen Orchestra is failing.
+type requestGuard struct {
+	endpoint string
+	// limiter, inFlight and breaker are nil when they are disabled.
+	limiter  flowcontrol.RateLimiter
+	inFlight chan struct{}
+	breaker  *circuitBreaker
+}
+
+func newRequestGuard(endpoint string, rateLimit rateLimitConfig, circuitBreaker circuitBreakerConfig) *requestGuard {
+	g := &requestGuard{endpoint: endpoint}
+
+	if rateLimit.QPS > 0 {
+		burst := rateLimit.Burst
+		if burst == 0 {
+			burst = max(1, int(2*rateLimit.QPS))
+		}
+
+		g.limiter = flowcontrol.NewTokenBucketRateLimiter(rateLimit.QPS, burst)
+	}
+
+	if rateLimit.MaxInFlight > 0 {
+		g.inFlight = make(chan struct{}, rateLimit.MaxInFlight)
+	}
+
+	if !circuitBreaker.Disabled {
+		g.breaker = newCircuitBreaker(endpoint, circuitBreaker)
+	}
+
+	return g
+}
+
+// guardDo runs the request once the circuit breaker, the rate limiter and the concurrency cap let it through,
+// and records its duration and error class under the operation. The request is traced with the attributes.
+func guardDo[T any](
+	ctx context.Context,
+	g *requestGuard,
+	operation string,
+	request func(context.Context) (T, error),
+	attributes ...attribute.KeyValue,
+) (result T, err error) {
+	ctx, span := startSpan(ctx, "xenorchestra."+operation,
+		append(attributes, attributeEndpoint.String(g.endpoint), attributeOperation.String(operation))...)
+	defer func() { endSpan(span, err) }()
+
+	var zero T
+
+	probe, err := g.breaker.allow()
+	if err != nil {
+		clientCircuitBreakerRejected.WithLabelValues(g.endpoint).Inc()
+
+		return zero, err
+	}
+
+	start := time.Now()
+
+	if g.limiter != nil {
+		if err := g.limiter.Wait(ctx); err != nil {
+			g.breaker.done(probe, context.Canceled)
+
+			return zero, err
+		}
+	}
+
+	if g.inFlight != nil {
+		select {
+		case g.inFlight <- struct{}{}:
+			defer func() { <-g.inFlight }()
+		case <-ctx.Done():
+			g.breaker.done(probe, context.Canceled)
+
+			return zero, ctx.Err()
+		}
+	}
+
+	if g.limiter != nil || g.inFlight != nil {
+		clientRateLimiterWait.WithLabelValues(g.endpoint).Observe(time.Since(start).Seconds())
+	}
+
+	clientInFlightRequests.WithLabelValues(g.endpoint).Inc()
+	defer clientInFlightRequests.WithLabelValues(g.endpoint).Dec()
+
+	requestStart := time.Now()
+	result, err = request(ctx)
+	observeAPIRequest(g.endpoint, operation, requestStart, err)
+	g.breaker.done(probe, err)
+
+	return result, err
+}
+
+// circuitBreaker opens after consecutive failures: the requests fail fast until the open duration
+// elapsed, then a single probe request is let through and closes the circuit when it succeeds.
+type circuitBreaker struct {
+	endpoint     string
+	threshold    int
+	openDuration time.Duration
+	now          func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(endpoint string, config circuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{
+		endpoint:     endpoint,
+		threshold:    config.FailureThreshold,
+		openDuration: config.OpenDuration,
+		now:          time.Now,
+	}
+
+	if b.threshold == 0 {
+		b.threshold = defaultCircuitBreakerFailureThreshold
+	}
+
+	if b.openDuration == 0 {
+		b.openDuration = defaultCircuitBreakerOpenDuration
+	}
+
+	clientCircuitBreakerState.WithLabelValues(endpoint).Set(float64(circuitClosed))
+
+	return b
+}
+
+// allow returns an error when the request must fail fast, and whether the request is the probe of a half-open circuit.
+func (b *circuitBreaker) allow() (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitClosed:
+		return false, nil
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false, b.openError()
+		}
+
+		b.setState(circuitHalfOpen)
+	}
+
+	if b.probing {
+		return false, b.openError()
+	}
+
+	b.probing = true
+
+	return true, nil
+}
+
+// done records the result of a request. Only the failures that can go away by themselves count,
+// Xen Orchestra answered the other requests.
+func (b *circuitBreaker) done(probe bool, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The request was abandoned, a later request probes the circuit
+	case err != nil && isXORetryable(classifyXOError(err)):
+		b.failures++
+
+		if probe || (b.state == circuitClosed && b.failures >= b.threshold) {
+			klog.ErrorS(err, "Xen Orchestra requests are failing, opening the circuit breaker", "endpoint", b.endpoint, "failures", b.failures, "duration", b.openDuration)
+
+			b.openedAt = b.now()
+			b.setState(circuitOpen)
+		}
+	default:
+		b.failures = 0
+
+		if probe {
+			klog.InfoS("Xen Orchestra requests succeed again, closing the circuit breaker", "endpoint", b.endpoint)
+
+			b.setState(circuitClosed)
+		}
+	}
+}
+
+// setState changes the state of the circuit, b.mu must be held.
+func (b *circuitBreaker) setState(state circuitState) {
+	b.state = state
+	clientCircuitBreakerState.WithLabelValues(b.endpoint).Set(float64(state))
+}
+
+func (b *circuitBreaker) openError() error {
+	return &xoError{class: ErrXOTransient, err: fmt.Errorf("endpoint %s: %w", b.endpoint, ErrXOCircuitOpen)}
+}
+
+// guardedLibrary guards the Xen Orchestra requests of the CCM, the other requests are sent as is.
+type guardedLibrary struct {
+	library.Library
+
+	g *requestGuard
+}
+
+func (l *guardedLibrary) VM() library.VM {
+	return &guardedVM{VM: l.Library.VM(), g: l.g}
+}
+
+func (l *guardedLibrary) Host() library.Host {
+	return &guardedHost{Host: l.Library.Host(), g: l.g}
+}
+
+func (l *guardedLibrary) Pool() library.Pool {
+	return &guardedPool{Pool: l.Library.Pool(), g: l.g}
+}
+
+func (l *guardedLibrary) V1Client() v1.XOClient {
+	client := l.Library.V1Client()
+	if client == nil {
+		return nil
+	}
+
+	return &guardedV1Client{XOClient: client, g: l.g}
+}
+
+type guardedVM struct {
+	library.VM
+
+	g *requestGuard
+}
+
+func (s *guardedVM) GetByID(ctx context.Context, id uuid.UUID) (*payloads.VM, error) {
+	return guardDo(ctx, s.g, operationVMGet, func(ctx context.Context) (*payloads.VM, error) {
+		return s.VM.GetByID(ctx, id)
+	}, attributeVMID.String(id.String()))
+}
+
+func (s *guardedVM) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.VM, error) {
+	return guardDo(ctx, s.g, operationVMList, func(ctx context.Context) ([]*payloads.VM, error) {
+		return s.VM.GetAll(ctx, limit, filter)
+	})
+}
+
+func (s *guardedVM) Create(ctx context.Context, poolID uuid.UUID, vm *payloads.CreateVMParams) (*payloads.VM, error) {
+	return guardDo(ctx, s.g, operationVMCreate, func(ctx context.Context) (*payloads.VM, error) {
+		return s.VM.Create(ctx, poolID, vm)
+	})
+}
+
+func (s *guardedVM) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := guardDo(ctx, s.g, operationVMDelete, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, s.VM.Delete(ctx, id)
+	}, attributeVMID.String(id.String()))
+
+	return err
+}
+
+func (s *guardedVM) HardShutdown(ctx context.Context, id uuid.UUID) (string, error) {
+	return guardDo(ctx, s.g, operationVMShutdown, func(ctx context.Context) (string, error) {
+		return s.VM.HardShutdown(ctx, id)
+	}, attributeVMID.String(id.String()))
+}
+
+func (s *guardedVM) AddTag(ctx context.Context, id uuid.UUID, tag string) error {
+	_, err := guardDo(ctx, s.g, operationVMTag, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, s.VM.AddTag(ctx, id, tag)
+	}, attributeVMID.String(id.String()))
+
+	return err
+}
+
+type guardedHost struct {
+	library.Host
+
+	g *requestGuard
+}
+
+func (s *guardedHost) Get(ctx context.Context, id uuid.UUID) (*payloads.Host, error) {
+	return guardDo(ctx, s.g, operationHostGet, func(ctx context.Context) (*payloads.Host, error) {
+		return s.Host.Get(ctx, id)
+	})
+}
+
+func (s *guardedHost) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.Host, error) {
+	return guardDo(ctx, s.g, operationHostList, func(ctx context.Context) ([]*payloads.Host, error) {
+		return s.Host.GetAll(ctx, limit, filter)
+	})
+}
+
+type guardedPool struct {
+	library.Pool
+
+	g *requestGuard
+}
+
+func (s *guardedPool) Get(ctx context.Context, id uuid.UUID) (*payloads.Pool, error) {
+	return guardDo(ctx, s.g, operationPoolGet, func(ctx context.Context) (*payloads.Pool, error) {
+		return s.Pool.Get(ctx, id)
+	})
+}
+
+func (s *guardedPool) GetAll(ctx context.Context, limit int, filter string) ([]*payloads.Pool, error) {
+	return guardDo(ctx, s.g, operationPoolList, func(ctx context.Context) ([]*payloads.Pool, error) {
+		return s.Pool.GetAll(ctx, limit, filter)
+	})
+}
+
+// guardedV1Client guards the JSON-RPC requests of the CCM, the JSON-RPC client has no context.
+type guardedV1Client struct {
+	v1.XOClient
+
+	g *requestGuard
+}
+
+func (c *guardedV1Client) GetVm(vmReq v1.Vm) (*v1.Vm, error) {
+	return guardDo(context.Background(), c.g, operationVMGet, func(context.Context) (*v1.Vm, error) {
+		return c.XOClient.GetVm(vmReq)
+	})
+}
+
+func (c *guardedV1Client) GetVIFs(vm *v1.Vm) ([]v1.VIF, error) {
+	return guardDo(context.Background(), c.g, operationVIFList, func(context.Context) ([]v1.VIF, error) {
+		return c.XOClient.GetVIFs(vm)
+	})
+}
+
+func (c *guardedV1Client) GetNetwork(netReq v1.Network) (*v1.Network, error) {
+	return guardDo(context.Background(), c.g, operationNetworkGet, func(context.Context) (*v1.Network, error) {
+		return c.XOClient.GetNetwork(netReq)
+	})
+}
+
+func (c *guardedV1Client) GetAllObjectsOfType(obj v1.XoObject, response interface{}) error {
+	operation := operationObjectList
+
+	switch obj.(type) {
+	case v1.Vm:
+		operation = operationVMList
+	case v1.VIF:
+		operation = operationVIFList
+	case v1.Network:
+		operation = operationNetworkList
+	}
+
+	_, err := guardDo(context.Background(), c.g, operation, func(context.Context) (struct{}, error) {
+		return struct{}{}, c.XOClient.GetAllObjectsOfType(obj, response)
+	})
+
+	return err
+}
+
+func (c *guardedV1Client) GetTemplate(template v1.Template) ([]v1.Template, error) {
+	return guardDo(context.Background(), c.g, operationTemplateGet, func(context.Context) ([]v1.Template, error) {
+		return c.XOClient.GetTemplate(template)
+	})
+}
+
+func (c *guardedV1Client) Call(method string, params, result interface{}) error {
+	caller, ok := c.XOClient.(jsonRPCCaller)
+	if !ok {
+		return fmt.Errorf("xen Orchestra client does not support JSON-RPC calls")
+	}
+
+	_, err := guardDo(context.Background(), c.g, "jsonrpc_"+method, func(context.Context) (struct{}, error) {
+		return struct{}{}, caller.Call(method, params, result)
+	})
+
+	return err
+}
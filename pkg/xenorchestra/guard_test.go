@@ -0,0 +1,189 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+)
+
+func guardedMockVM(ctrl *gomock.Controller, g *requestGuard) (*mock_library.MockVM, *guardedLibrary) {
+	mockVM := mock_library.NewMockVM(ctrl)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	return mockVM, &guardedLibrary{Library: mockLib, g: g}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	g := newRequestGuard("dc1", rateLimitConfig{}, circuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute})
+
+	now := time.Now()
+	g.breaker.now = func() time.Time { return now }
+
+	mockVM, lib := guardedMockVM(ctrl, g)
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+	unavailable := errors.New("API error: 503 Service Unavailable - {}")
+
+	// A missing VM is an answer of Xen Orchestra, it resets the failures
+	gomock.InOrder(
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, unavailable),
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, errors.New("API error: 404 Not Found - {}")),
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, unavailable).Times(2),
+	)
+
+	for range 4 {
+		_, err := lib.VM().GetByID(t.Context(), id)
+		require.Error(t, err)
+	}
+
+	// The requests fail fast while the circuit is open
+	_, err := lib.VM().GetByID(t.Context(), id)
+	assert.ErrorIs(t, err, ErrXOCircuitOpen)
+	assert.ErrorIs(t, err, ErrXOTransient, "the callers retry later")
+
+	// A failed probe opens the circuit again
+	now = now.Add(time.Minute)
+
+	mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, unavailable)
+
+	_, err = lib.VM().GetByID(t.Context(), id)
+	assert.ErrorIs(t, err, unavailable)
+
+	_, err = lib.VM().GetByID(t.Context(), id)
+	assert.ErrorIs(t, err, ErrXOCircuitOpen)
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+
+	vm := &payloads.VM{ID: id}
+	mockVM.EXPECT().GetByID(gomock.Any(), id).Return(vm, nil).Times(2)
+
+	for range 2 {
+		got, err := lib.VM().GetByID(t.Context(), id)
+		require.NoError(t, err)
+		assert.Equal(t, vm, got)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newCircuitBreaker("dc1", circuitBreakerConfig{FailureThreshold: 1})
+
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	probe, err := b.allow()
+	require.NoError(t, err)
+	b.done(probe, ErrXOTransient)
+
+	now = now.Add(defaultCircuitBreakerOpenDuration)
+
+	probe, err = b.allow()
+	require.NoError(t, err)
+	assert.True(t, probe)
+
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrXOCircuitOpen, "a single probe is let through")
+
+	// An abandoned probe lets the next request probe the circuit
+	b.done(probe, context.Canceled)
+
+	probe, err = b.allow()
+	require.NoError(t, err)
+	assert.True(t, probe)
+}
+
+func TestRequestGuardLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+
+	// The concurrency cap holds the requests above it
+	g := newRequestGuard("dc1", rateLimitConfig{MaxInFlight: 1}, circuitBreakerConfig{Disabled: true})
+	mockVM, lib := guardedMockVM(ctrl, g)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	mockVM.EXPECT().GetByID(gomock.Any(), id).DoAndReturn(func(context.Context, uuid.UUID) (*payloads.VM, error) {
+		close(started)
+		<-release
+
+		return &payloads.VM{}, nil
+	})
+
+	go lib.VM().GetByID(context.Background(), id) //nolint:errcheck
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := lib.VM().GetByID(ctx, id)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+
+	// The rate limiter delays the requests above the burst
+	g = newRequestGuard("dc1", rateLimitConfig{QPS: 0.1, Burst: 1}, circuitBreakerConfig{Disabled: true})
+	mockVM, lib = guardedMockVM(ctrl, g)
+
+	mockVM.EXPECT().GetByID(gomock.Any(), id).Return(&payloads.VM{}, nil)
+
+	_, err = lib.VM().GetByID(t.Context(), id)
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = lib.VM().GetByID(ctx, id)
+	assert.Error(t, err)
+}
+
+func TestGuardConfig(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+rateLimit:
+  qps: 10
+  maxInFlight: 4
+circuitBreaker:
+  failureThreshold: 3
+  openDuration: 1m
+`))
+	require.NoError(t, err)
+	assert.Equal(t, rateLimitConfig{QPS: 10, MaxInFlight: 4}, cfg.RateLimit)
+	assert.Equal(t, circuitBreakerConfig{FailureThreshold: 3, OpenDuration: time.Minute}, cfg.CircuitBreaker)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+rateLimit:
+  qps: -1
+`))
+	assert.EqualError(t, err, "rateLimit: qps, burst and maxInFlight must be positive")
+}
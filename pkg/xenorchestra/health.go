@@ -0,0 +1,183 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/controller-manager/pkg/healthz"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultHealthCheckInterval is the delay between two checks of the connection to Xen Orchestra.
+	defaultHealthCheckInterval = 30 * time.Second
+	// defaultHealthCheckUnhealthyThreshold is how long an endpoint can be unreachable before the health check fails.
+	defaultHealthCheckUnhealthyThreshold = 2 * time.Minute
+)
+
+// healthCheckConfig is the HealthCheck section of the cloud config.
+type healthCheckConfig struct {
+	// Interval is the delay between two checks of the connection to each endpoint, it defaults to 30s.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// UnhealthyThreshold is how long an endpoint can be unreachable before the health check fails, it defaults to 2m.
+	UnhealthyThreshold time.Duration `yaml:"unhealthyThreshold,omitempty"`
+}
+
+func (c *healthCheckConfig) validate() error {
+	if c.Interval < 0 || c.UnhealthyThreshold < 0 {
+		return fmt.Errorf("interval and unhealthyThreshold must be positive")
+	}
+
+	return nil
+}
+
+// HealthCheckable is implemented by the cloud, its health check reports the connection to Xen Orchestra.
+type HealthCheckable interface {
+	HealthChecker() healthz.UnnamedHealthChecker
+}
+
+// healthChecker checks the connection to the endpoints periodically, the health endpoint serves the cached results.
+type healthChecker struct {
+	endpoints *xoEndpoints
+	interval  time.Duration
+	threshold time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	// checkedAt is the time of the last checks, the results are stale when the checks stopped.
+	checkedAt time.Time
+	// lastSuccess is the time of the last successful check of each endpoint, lastErr its last error.
+	lastSuccess map[*xoEndpoint]time.Time
+	lastErr     map[*xoEndpoint]error
+}
+
+var _ healthz.UnnamedHealthChecker = &healthChecker{}
+
+func newHealthChecker(endpoints *xoEndpoints, config healthCheckConfig) *healthChecker {
+	h := &healthChecker{
+		endpoints:   endpoints,
+		interval:    config.Interval,
+		threshold:   config.UnhealthyThreshold,
+		now:         time.Now,
+		lastSuccess: map[*xoEndpoint]time.Time{},
+		lastErr:     map[*xoEndpoint]error{},
+	}
+
+	if h.interval == 0 {
+		h.interval = defaultHealthCheckInterval
+	}
+
+	if h.threshold == 0 {
+		h.threshold = defaultHealthCheckUnhealthyThreshold
+	}
+
+	// The threshold runs from the start, Xen Orchestra may be unreachable since then
+	start := h.now()
+	h.checkedAt = start
+
+	for _, endpoint := range endpoints.list {
+		h.lastSuccess[endpoint] = start
+	}
+
+	return h
+}
+
+// run checks the endpoints until the context is done.
+func (h *healthChecker) run(ctx context.Context) {
+	wait.UntilWithContext(ctx, h.checkAll, h.interval)
+}
+
+// checkAll checks the connection to the endpoints in parallel, and records the results.
+// Each check takes at most the interval, so a slow endpoint does not delay the others.
+func (h *healthChecker) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, endpoint := range h.endpoints.list {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			h.check(ctx, endpoint)
+		}()
+	}
+
+	wg.Wait()
+
+	h.mu.Lock()
+	h.checkedAt = h.now()
+	h.mu.Unlock()
+}
+
+// check checks the connection to the endpoint, and records the result.
+func (h *healthChecker) check(ctx context.Context, endpoint *xoEndpoint) {
+	ctx, cancel := context.WithTimeout(ctx, h.interval)
+	defer cancel()
+
+	start := h.now()
+	err := endpoint.c.CheckClient(ctx)
+	observeAPIRequest(endpoint.String(), operationCheck, start, err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err == nil && h.lastErr[endpoint] != nil:
+		klog.InfoS("Xen Orchestra is reachable again", "endpoint", endpoint)
+	case err != nil && h.lastErr[endpoint] == nil:
+		klog.ErrorS(err, "Xen Orchestra is unreachable", "endpoint", endpoint)
+	}
+
+	if err == nil {
+		h.lastSuccess[endpoint] = h.now()
+	}
+
+	h.lastErr[endpoint] = err
+}
+
+// Check fails when an endpoint has been unreachable for longer than the threshold, or when the checks stopped.
+func (h *healthChecker) Check(_ *http.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+
+	// A round of checks takes at most the interval, the results are stale once two rounds are missing
+	if stale := now.Sub(h.checkedAt); stale > 3*h.interval {
+		return fmt.Errorf("the Xen Orchestra connection was last checked %s ago", stale.Round(time.Second))
+	}
+
+	var failures []string
+
+	for _, endpoint := range h.endpoints.list {
+		if down := now.Sub(h.lastSuccess[endpoint]); down > h.threshold {
+			failures = append(failures, fmt.Sprintf("endpoint %s unreachable for %s: %v", endpoint, down.Round(time.Second), h.lastErr[endpoint]))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	libraries := []*mock_library.MockLibrary{checkLibrary(ctrl, nil), checkLibrary(ctrl, errUnreachable)}
+	lib := newReloadableLibrary(libraries[0])
+
+	endpoints := newXOEndpoints([]*xoEndpoint{{name: "dc1", c: &xok8s.XoClient{Client: lib}}}, nil)
+
+	now := time.Now()
+	h := newHealthChecker(endpoints, healthCheckConfig{Interval: time.Minute, UnhealthyThreshold: 5 * time.Minute})
+	h.now = func() time.Time { return now }
+
+	h.checkAll(t.Context())
+	require.NoError(t, h.Check(nil))
+
+	// Xen Orchestra is unreachable, the check fails after the threshold only
+	lib.set(libraries[1])
+
+	for range 4 {
+		now = now.Add(time.Minute)
+		h.checkAll(t.Context())
+	}
+
+	require.NoError(t, h.Check(nil))
+
+	now = now.Add(2 * time.Minute)
+	h.checkAll(t.Context())
+
+	err := h.Check(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "endpoint dc1 unreachable for 6m0s")
+	assert.ErrorContains(t, err, "connection refused")
+
+	// A successful check makes it healthy again
+	lib.set(libraries[0])
+	h.checkAll(t.Context())
+	require.NoError(t, h.Check(nil))
+
+	// The results are stale once the checks stopped
+	now = now.Add(4 * time.Minute)
+	assert.ErrorContains(t, h.Check(nil), "the Xen Orchestra connection was last checked 4m0s ago")
+}
+
+func TestHealthCheckerParallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Each check completes once all the endpoints are being checked
+	var started sync.WaitGroup
+
+	started.Add(3)
+
+	all := make(chan struct{})
+
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+
+	list := []*xoEndpoint{}
+
+	for _, name := range []string{"dc1", "dc2", "dc3"} {
+		mockVM := mock_library.NewMockVM(ctrl)
+		mockVM.EXPECT().GetAll(gomock.Any(), 1, "").DoAndReturn(func(ctx context.Context, _ int, _ string) ([]*payloads.VM, error) {
+			started.Done()
+
+			select {
+			case <-all:
+				return []*payloads.VM{}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+
+		mockLib := mock_library.NewMockLibrary(ctrl)
+		mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+		list = append(list, &xoEndpoint{name: name, c: &xok8s.XoClient{Client: mockLib}})
+	}
+
+	h := newHealthChecker(newXOEndpoints(list, nil), healthCheckConfig{Interval: 5 * time.Second})
+	h.checkAll(t.Context())
+
+	for _, endpoint := range list {
+		assert.NoError(t, h.lastErr[endpoint], "endpoint %s", endpoint)
+	}
+}
+
+func TestHealthCheckConfig(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+healthCheck:
+  interval: 10s
+  unhealthyThreshold: 1m
+`))
+	require.NoError(t, err)
+	assert.Equal(t, healthCheckConfig{Interval: 10 * time.Second, UnhealthyThreshold: time.Minute}, cfg.HealthCheck)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+healthCheck:
+  interval: -1s
+`))
+	assert.EqualError(t, err, "healthCheck: interval and unhealthyThreshold must be positive")
+}
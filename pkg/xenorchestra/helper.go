@@ -20,18 +20,43 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/gofrs/uuid"
+
 	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 )
 
-// getInstanceType returns the instance type for the given VM.
-// It returns the instance type name if it matches the expected format, otherwise it returns a formatted string
-// returns: the instance type name or a formatted string with vCPU and memory, and an error if any
+// jsonRPCCaller is implemented by the Xen Orchestra JSON-RPC client.
+type jsonRPCCaller interface {
+	Call(method string, params, result interface{}) error
+}
+
+// setXenstoreData writes keys to the VM xenstore (vm-data/...), a nil value removes the key.
+// The REST API does not update VMs yet, so the JSON-RPC vm.set method is used.
+func setXenstoreData(client *xok8s.XoClient, vmID uuid.UUID, data map[string]any) error {
+	caller, ok := client.Client.V1Client().(jsonRPCCaller)
+	if !ok {
+		return fmt.Errorf("xen Orchestra client does not support JSON-RPC calls")
+	}
+
+	var success bool
+
+	return caller.Call("vm.set", map[string]any{
+		"id":           vmID.String(),
+		"xenStoreData": data,
+	}, &success)
+}
+
+// getInstanceType returns the instance type formatted from the VM size, for example 2vCPU-4GB.
+// The memory is formatted in MB when it is not a whole number of GB, for example 1vCPU-512MB.
 func getInstanceType(vm *payloads.VM) string {
-	memory := float64(vm.Memory.Size) / float64(1024*1024*1024)
+	const gib = 1024 * 1024 * 1024
+
+	if vm.Memory.Size%gib != 0 {
+		return fmt.Sprintf("%dvCPU-%dMB", vm.CPUs.Max, vm.Memory.Size/(1024*1024))
+	}
 
-	return fmt.Sprintf("%.0fvCPU-%.0fGB",
-		float64(vm.CPUs.Max),
-		memory)
+	return fmt.Sprintf("%dvCPU-%dGB", vm.CPUs.Max, vm.Memory.Size/gib)
 }
 
 // sanitizeToLabel replaces characters in a string so that it matches the regex:
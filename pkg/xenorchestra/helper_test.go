@@ -16,13 +16,100 @@ limitations under the License.
 package xenorchestra
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
 	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
 )
 
+// fakeV1Client serves VMs and records the JSON-RPC calls made through the v1 client.
+type fakeV1Client struct {
+	client.XOClient
+
+	vms       map[string]*client.Vm
+	vifs      map[string][]client.VIF
+	networks  map[string]*client.Network
+	templates []client.Template
+	calls     []map[string]any
+	// lookups counts the per object requests, lists counts the requests listing all the objects of a type.
+	lookups int
+	lists   int
+}
+
+func (c *fakeV1Client) GetAllObjectsOfType(obj client.XoObject, response interface{}) error {
+	c.lists++
+
+	switch obj.(type) {
+	case client.Vm:
+		vms := response.(*map[string]client.Vm)
+		for id, vm := range c.vms {
+			(*vms)[id] = *vm
+		}
+	case client.VIF:
+		vifs := response.(*map[string]client.VIF)
+		for vmID, vmVIFs := range c.vifs {
+			for _, vif := range vmVIFs {
+				vif.VmId = vmID
+				(*vifs)[vmID+"/"+vif.Device] = vif
+			}
+		}
+	case client.Network:
+		networks := response.(*map[string]client.Network)
+		for id, network := range c.networks {
+			(*networks)[id] = *network
+		}
+	default:
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	return nil
+}
+
+func (c *fakeV1Client) GetTemplate(template client.Template) ([]client.Template, error) {
+	for _, t := range c.templates {
+		if t.Id == template.Id {
+			return []client.Template{t}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find client.Template with query %+v", template)
+}
+
+func (c *fakeV1Client) GetVIFs(vm *client.Vm) ([]client.VIF, error) {
+	c.lookups++
+
+	return c.vifs[vm.Id], nil
+}
+
+func (c *fakeV1Client) GetNetwork(netReq client.Network) (*client.Network, error) {
+	c.lookups++
+
+	if network, ok := c.networks[netReq.Id]; ok {
+		return network, nil
+	}
+
+	return nil, fmt.Errorf("could not find client.Network with query %+v", netReq)
+}
+
+func (c *fakeV1Client) GetVm(vmReq client.Vm) (*client.Vm, error) {
+	c.lookups++
+
+	if vm, ok := c.vms[vmReq.Id]; ok {
+		return vm, nil
+	}
+
+	return nil, fmt.Errorf("expected to find a single VM from request %+v, instead found 0", vmReq)
+}
+
+func (c *fakeV1Client) Call(_ string, params, _ interface{}) error {
+	c.calls = append(c.calls, params.(map[string]any))
+
+	return nil
+}
+
 func TestGetInstanceType(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -45,6 +132,14 @@ func TestGetInstanceType(t *testing.T) {
 			},
 			expected: "4vCPU-8GB",
 		},
+		{
+			name: "1 vCPU 512 MB",
+			vm: &payloads.VM{
+				CPUs:   payloads.CPUs{Max: 1},
+				Memory: payloads.Memory{Size: 512 * 1024 * 1024},
+			},
+			expected: "1vCPU-512MB",
+		},
 	}
 
 	for _, tt := range tests {
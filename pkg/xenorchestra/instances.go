@@ -18,15 +18,19 @@ package xenorchestra
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
-	"github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
 	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
 	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 
 	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
 	cloudprovider "k8s.io/cloud-provider"
 	cloudproviderapi "k8s.io/cloud-provider/api"
 	"k8s.io/klog/v2"
@@ -38,24 +42,93 @@ const unknownLabel = "unknown"
 type XOInstances interface {
 	// GetInstance returns the VM reference for the given node.
 	GetInstance(ctx context.Context, node *v1.Node) (*payloads.VM, error)
+	// WithInventory returns a context whose lookups are served from an inventory snapshot of the nodes.
+	WithInventory(ctx context.Context, nodes []*v1.Node) (context.Context, error)
+	// WatchInstances calls onChange with the UUID of the VMs whose node labels may have changed.
+	// It returns false when the event subscription is disabled.
+	WatchInstances(ctx context.Context, onChange func(vmID string)) bool
+	// ReportFailure records a deduplicated warning event for a failed Xen Orchestra request, regarding the node,
+	// or the CCM when the node is nil.
+	ReportFailure(ctx context.Context, node *v1.Node, action string, err error)
 	cloudprovider.InstancesV2
 }
 
 type instances struct {
-	c *xok8s.XoClient
+	endpoints *xoEndpoints
+	// clusterID scopes the VMs the instances belong to, VMs tagged for another cluster are refused.
+	clusterID     string
+	nodeAddresses nodeAddressesConfig
+	instanceType  instanceTypeConfig
+	topology      topologyConfig
+
+	deletionGuardConfig deletionGuardConfig
+	// deletionGuard is nil when it is disabled, or until the instances are initialized.
+	deletionGuard *deletionGuard
+
+	// kubeClient is used by the legacy Instances and Zones interfaces to resolve node names.
+	kubeClient clientset.Interface
+	// reporter records the failures as events, it is nil until the instances are initialized.
+	reporter *eventReporter
+
+	// unavailable is set while the connection to Xen Orchestra failed at startup.
+	unavailable atomic.Bool
 }
 
-func newInstances(client *xok8s.XoClient) *instances {
+// newInstances returns the instances of a single Xen Orchestra instance.
+func newInstances(client *xok8s.XoClient, config *cloudConfig) *instances {
+	return newEndpointInstances(newXOEndpoints([]*xoEndpoint{newXOEndpoint("", client, config.XoConfig, config)}, nil), config)
+}
+
+func newEndpointInstances(endpoints *xoEndpoints, config *cloudConfig) *instances {
 	return &instances{
-		c: client,
+		endpoints:     endpoints,
+		clusterID:     config.ClusterID,
+		nodeAddresses: config.NodeAddresses,
+		instanceType:  config.InstanceType,
+		topology:      config.Topology,
+
+		deletionGuardConfig: config.DeletionGuard,
+	}
+}
+
+func (i *instances) initialize(kubeClient clientset.Interface) {
+	i.kubeClient = kubeClient
+	i.reporter = newEventReporter(kubeClient)
+	i.deletionGuard = newDeletionGuard(kubeClient, i.deletionGuardConfig)
+}
+
+// ReportFailure records a deduplicated warning event for a failed Xen Orchestra request.
+func (i *instances) ReportFailure(ctx context.Context, node *v1.Node, action string, err error) {
+	i.reporter.reportFailure(ctx, node, action, err)
+}
+
+// setAvailable marks whether Xen Orchestra can be reached.
+func (i *instances) setAvailable(available bool) {
+	i.unavailable.Store(!available)
+}
+
+// checkAvailable returns a retriable error while Xen Orchestra cannot be reached,
+// so that the node controllers retry later instead of acting on a missing VM.
+func (i *instances) checkAvailable() error {
+	if i.unavailable.Load() {
+		return fmt.Errorf("%w: %w", errWaitingForXO, ErrXOTransient)
 	}
+
+	return nil
 }
 
 // InstanceExists returns true if the instance for the given node exists according to the cloud provider.
 // Use the node.name or node.spec.providerID field to find the node in the cloud provider.
-func (i *instances) InstanceExists(ctx context.Context, node *v1.Node) (bool, error) {
+func (i *instances) InstanceExists(ctx context.Context, node *v1.Node) (exists bool, err error) {
 	klog.V(4).InfoS("instances.InstanceExists() called", "node", klog.KRef("", node.Name))
 
+	ctx, span := startSpan(ctx, "InstanceExists", attributeNode.String(node.Name), attributeProviderID.String(node.Spec.ProviderID))
+
+	defer func() {
+		recordInstancesCall("InstanceExists", callOutcome(err, exists, outcomeExists, outcomeNotFound))
+		endSpan(span, err)
+	}()
+
 	if node.Spec.ProviderID == "" {
 		klog.V(4).InfoS("instances.InstanceExists() empty providerID, omitting unmanaged node", "node", klog.KObj(node))
 
@@ -72,20 +145,50 @@ func (i *instances) InstanceExists(ctx context.Context, node *v1.Node) (bool, er
 		if err == cloudprovider.InstanceNotFound {
 			klog.V(4).InfoS("instances.InstanceExists() instance not found", "node", klog.KObj(node), "providerID", node.Spec.ProviderID)
 
+			// Many VMs not found at once are more likely a Xen Orchestra anomaly than deletions
+			if err := i.deletionGuard.checkNotFound(ctx, node); err != nil {
+				if errors.Is(err, ErrDeletionUnconfirmed) {
+					klog.V(2).InfoS("instances.InstanceExists() waiting to confirm the instance deletion", "node", klog.KObj(node), "reason", err)
+
+					return false, err
+				}
+
+				klog.ErrorS(err, "instances.InstanceExists() refusing to report the instance as deleted", "node", klog.KObj(node))
+				i.reporter.reportFailure(ctx, node, eventActionGetInstance, err)
+
+				return false, err
+			}
+
 			return false, nil // Return nil, it's not an error: it's expected when the VM has been deleted
 		}
 
+		// Any other error must not delete the node: the controller retries later
+		if isXOAuthError(err) {
+			klog.ErrorS(err, "instances.InstanceExists() Xen Orchestra refused the request, check the API token permissions", "node", klog.KObj(node))
+		}
+
+		i.reporter.reportFailure(ctx, node, eventActionGetInstance, err)
+
 		return false, err
 	}
 
+	i.deletionGuard.observeFound(ctx, node)
+
 	return true, nil
 }
 
 // InstanceShutdown returns true if the instance is shutdown according to the cloud provider.
 // Use the node.name or node.spec.providerID field to find the node in the cloud provider.
-func (i *instances) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
+func (i *instances) InstanceShutdown(ctx context.Context, node *v1.Node) (shutdown bool, err error) {
 	klog.V(4).InfoS("instances.InstanceShutdown() called", "node", klog.KRef("", node.Name))
 
+	ctx, span := startSpan(ctx, "InstanceShutdown", attributeNode.String(node.Name), attributeProviderID.String(node.Spec.ProviderID))
+
+	defer func() {
+		recordInstancesCall("InstanceShutdown", callOutcome(err, shutdown, outcomeShutdown, outcomeRunning))
+		endSpan(span, err)
+	}()
+
 	if node.Spec.ProviderID == "" {
 		klog.V(4).InfoS("instances.InstanceShutdown() empty providerID, omitting unmanaged node", "node", klog.KObj(node))
 
@@ -106,9 +209,15 @@ func (i *instances) InstanceShutdown(ctx context.Context, node *v1.Node) (bool,
 			return false, fmt.Errorf("vm not found: %s", node.Spec.ProviderID) // Vm not found, probably deleted
 		}
 
-		klog.ErrorS(err, "instances.InstanceShutdown() failed to parse providerID", "providerID", node.Spec.ProviderID)
+		i.reporter.reportFailure(ctx, node, eventActionGetInstance, err)
 
-		return false, nil
+		if errors.Is(err, ErrInvalidProviderID) {
+			klog.ErrorS(err, "instances.InstanceShutdown() failed to parse providerID", "providerID", node.Spec.ProviderID)
+
+			return false, nil
+		}
+
+		return false, err
 	}
 
 	if vmr.PowerState != payloads.PowerStateRunning {
@@ -121,25 +230,43 @@ func (i *instances) InstanceShutdown(ctx context.Context, node *v1.Node) (bool,
 // InstanceMetadata returns the instance's metadata. The values returned in InstanceMetadata are
 // translated into specific fields in the Node object on registration.
 // Use the node.name or node.spec.providerID field to find the node in the cloud provider.
-func (i *instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprovider.InstanceMetadata, error) {
+func (i *instances) InstanceMetadata(ctx context.Context, node *v1.Node) (_ *cloudprovider.InstanceMetadata, err error) {
 	klog.V(4).InfoS("instances.InstanceMetadata() called", "node", klog.KRef("", node.Name))
 
+	ctx, span := startSpan(ctx, "InstanceMetadata", attributeNode.String(node.Name), attributeProviderID.String(node.Spec.ProviderID))
+
+	defer func() {
+		recordInstancesCall("InstanceMetadata", callOutcome(err, true, outcomeSuccess, outcomeSuccess))
+		i.reporter.reportFailure(ctx, node, eventActionGetInstance, err)
+		endSpan(span, err)
+	}()
+
 	var (
-		vmRef  *payloads.VM
-		region uuid.UUID // The XCP-ng poolID
-		err    error
+		vmRef    *payloads.VM
+		endpoint *xoEndpoint
 	)
 
 	providerID := node.Spec.ProviderID
 	if providerID == "" {
 		klog.V(4).InfoS("instances.InstanceMetadata() empty providerID, trying find node", "node", klog.KObj(node), "uuid", node.Status.NodeInfo.SystemUUID)
 
-		vmRef, region, err = i.c.FindVMByNode(ctx, node)
+		if err := i.checkAvailable(); err != nil {
+			return nil, err
+		}
+
+		vmRef, endpoint, err = i.endpoints.findVMByNode(ctx, node)
 		if err != nil {
-			return nil, fmt.Errorf("instances.InstanceMetadata() - failed to find instance by uuid %s: %v, skipped", node.Name, err)
+			return nil, fmt.Errorf("instances.InstanceMetadata() - failed to find instance by uuid %s: %w, skipped", node.Name, classifyXOError(err))
 		}
 
-		providerID = xok8s.GetProviderID(region, vmRef)
+		span.SetAttributes(attributeVMID.String(vmRef.ID.String()))
+
+		if err := checkVMCluster(vmRef, i.clusterID); err != nil {
+			return nil, fmt.Errorf("instances.InstanceMetadata() - refusing to adopt instance of node %s: %v", node.Name, err)
+		}
+
+		// The pool routes the lookups to the endpoint, the providerID keeps the format shared with the other XO components
+		providerID = xok8s.GetProviderID(vmRef.PoolID, vmRef)
 	} else if !strings.HasPrefix(node.Spec.ProviderID, xok8s.ProviderName) {
 		klog.V(4).InfoS("instances.InstanceMetadata() omitting unmanaged node", "node", klog.KObj(node), "providerID", node.Spec.ProviderID)
 
@@ -147,47 +274,34 @@ func (i *instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloud
 	}
 
 	if vmRef == nil {
-		vmRef, err = i.GetInstance(ctx, node)
+		vmRef, endpoint, err = i.getInstance(ctx, node)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	addresses := []v1.NodeAddress{}
-
-	if providedIP, ok := node.Annotations[cloudproviderapi.AnnotationAlphaProvidedIPAddr]; ok {
-		for _, ip := range strings.Split(providedIP, ",") {
-			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip})
-		}
+	if err := tagVMCluster(ctx, endpoint.c, vmRef, i.clusterID); err != nil {
+		klog.ErrorS(err, "instances.InstanceMetadata() failed to tag instance with the cluster ID", "node", klog.KObj(node))
+	} else if i.clusterID != "" {
+		endpoint.cache.invalidateVM(vmRef.ID)
 	}
 
-	// Add external IP from the VM's main IP address
-	if vmRef.MainIpAddress != "" {
-		addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: vmRef.MainIpAddress})
+	addresses, err := i.instanceAddresses(ctx, endpoint, node, vmRef)
+	if err != nil {
+		return nil, fmt.Errorf("instances.InstanceMetadata() - failed to get the addresses of node %s: %w", node.Name, err)
 	}
 
-	addresses = append(addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: node.Name})
+	instanceType := i.instanceType.instanceType(endpoint.c, vmRef)
 
-	instanceType := getInstanceType(vmRef)
+	hostRef, poolRef := i.getHostAndPool(ctx, endpoint, node, vmRef)
 
-	// Get Host info
-	hostRef, err := i.c.Client.Host().Get(ctx, vmRef.Container)
-	if err != nil {
-		klog.ErrorS(err, "instances.InstanceMetadata() failed to get host info", "hostID", vmRef.Container.String())
-		hostRef = &payloads.Host{
-			ID:        vmRef.Container,
-			NameLabel: unknownLabel,
-		}
+	hostNameLabel, poolNameLabel := unknownLabel, unknownLabel
+	if hostRef != nil {
+		hostNameLabel = hostRef.NameLabel
 	}
 
-	// Get Pool info
-	poolRef, err := i.c.Client.Pool().Get(ctx, vmRef.PoolID)
-	if err != nil {
-		klog.ErrorS(err, "instances.InstanceMetadata() failed to get pool info", "poolID", vmRef.PoolID.String())
-		poolRef = &payloads.Pool{
-			ID:        vmRef.PoolID,
-			NameLabel: unknownLabel,
-		}
+	if poolRef != nil {
+		poolNameLabel = poolRef.NameLabel
 	}
 
 	return &cloudprovider.InstanceMetadata{
@@ -195,45 +309,138 @@ func (i *instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloud
 			xok8s.XOLabelVmNameLabel:           sanitizeToLabel(vmRef.NameLabel),
 			xok8s.XOLabelTopologyPoolID:        sanitizeToLabel(vmRef.PoolID.String()),
 			xok8s.XOLabelTopologyHostID:        sanitizeToLabel(vmRef.Container.String()),
-			xok8s.XOLabelTopologyHostNameLabel: sanitizeToLabel(hostRef.NameLabel),
-			xok8s.XOLabelTopologyPoolNameLabel: sanitizeToLabel(poolRef.NameLabel),
+			xok8s.XOLabelTopologyHostNameLabel: sanitizeToLabel(hostNameLabel),
+			xok8s.XOLabelTopologyPoolNameLabel: sanitizeToLabel(poolNameLabel),
 		},
 		ProviderID:    providerID,
 		NodeAddresses: addresses,
 		InstanceType:  instanceType,
-		Zone:          vmRef.Container.String(),
-		Region:        vmRef.PoolID.String(),
+		Zone:          i.topology.zone(vmRef, hostRef, poolRef),
+		Region:        i.topology.region(vmRef, hostRef, poolRef),
 	}, nil
 }
 
-// getInstance returns the VM reference, and error for the given node.
+// instanceAddresses returns the node addresses: the addresses provided by the kubelet,
+// the addresses reported by the VM guest tools (main IP address first) classified by the
+// node address rules, and the node hostname. It fails rather than returning a partial list, the node
+// addresses would be replaced with it.
+func (i *instances) instanceAddresses(ctx context.Context, endpoint *xoEndpoint, node *v1.Node, vmRef *payloads.VM) ([]v1.NodeAddress, error) {
+	addresses := []v1.NodeAddress{}
+	provided := map[string]bool{}
+
+	if providedIP, ok := node.Annotations[cloudproviderapi.AnnotationAlphaProvidedIPAddr]; ok {
+		for _, ip := range strings.Split(providedIP, ",") {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip})
+			provided[ip] = true
+		}
+	}
+
+	vmAddresses, err := getVMAddresses(ctx, endpoint, vmRef)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := vmAddressDevices(vmAddresses)
+
+	var networks map[int]*client.Network
+	if i.nodeAddresses.needsNetworks() {
+		// The network rules must not fall through to the default type because the networks are unknown
+		if networks, err = getVMNetworks(ctx, endpoint, vmRef); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, addr := range sortVMAddresses(vmRef.MainIpAddress, vmAddresses, i.nodeAddresses.PrimaryIPFamily) {
+		if provided[addr.String()] {
+			continue
+		}
+
+		device, ok := devices[addr]
+		if !ok {
+			device = -1
+		}
+
+		switch i.nodeAddresses.classify(addr, device, networks[device]) {
+		case NodeAddressTypeInternalIP:
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: addr.String()})
+		case NodeAddressTypeExternalIP:
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: addr.String()})
+		}
+	}
+
+	if node.Name != "" {
+		addresses = append(addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: node.Name})
+	}
+
+	return addresses, nil
+}
+
+// GetInstance returns the VM reference, and error for the given node.
 func (i *instances) GetInstance(ctx context.Context, node *v1.Node) (*payloads.VM, error) {
+	vm, _, err := i.getInstance(ctx, node)
+
+	return vm, err
+}
+
+// getInstance returns the VM reference and its Xen Orchestra endpoint for the given node.
+func (i *instances) getInstance(ctx context.Context, node *v1.Node) (*payloads.VM, *xoEndpoint, error) {
 	klog.V(4).InfoS("instances.getInstance() called", "node", klog.KRef("", node.Name))
 
-	nodeRef, poolID, err := xok8s.ParseProviderID(node.Spec.ProviderID)
+	if err := i.checkAvailable(); err != nil {
+		return nil, nil, err
+	}
+
+	name, nodeRef, poolID, err := parseProviderID(node.Spec.ProviderID)
 	if err != nil {
-		klog.Errorf("Cannot parse Node UUID %s (%s)", nodeRef.ID, node.Name)
+		klog.Errorf("Cannot parse providerID %s (%s)", node.Spec.ProviderID, node.Name)
 
-		return nil, fmt.Errorf("instances.getInstance() error: %v", err)
+		return nil, nil, fmt.Errorf("instances.getInstance() error: %w", invalidProviderIDError(err))
 	}
 
-	vm, err := i.c.Client.VM().GetByID(ctx, nodeRef.ID)
+	trace.SpanFromContext(ctx).SetAttributes(attributeVMID.String(nodeRef.ID.String()))
+
+	endpoint, err := i.endpoints.route(ctx, name, poolID)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "API error: 404 Not Found") {
-			return nil, cloudprovider.InstanceNotFound
+		return nil, nil, fmt.Errorf("instances.getInstance() error: %w", invalidProviderIDError(err))
+	}
+
+	cached, found, err := i.endpoints.getVM(ctx, endpoint, nodeRef.ID)
+
+	// The VM may have migrated to a pool of another endpoint since the providerID was set, the VM UUID is kept
+	if endpoint != nil && name == "" && len(i.endpoints.list) > 1 && errors.Is(classifyXOError(err), ErrXONotFound) {
+		cached, found, err = i.endpoints.getVM(ctx, nil, nodeRef.ID)
+	}
+
+	if err != nil {
+		err = classifyXOError(err)
+		if errors.Is(err, ErrXONotFound) {
+			return nil, nil, cloudprovider.InstanceNotFound
 		}
 
-		return nil, fmt.Errorf("instances.getInstance() error: %v", err)
+		return nil, nil, fmt.Errorf("instances.getInstance() error: %w", err)
 	}
 
+	// The cached VM is shared, the callers get their own copy
+	vm := *cached
+
 	// Exclude case `vm.NameLabel != node.Name ||`, this should only require a refresh, not an 'node not found' error
-	if vm.ID != nodeRef.ID || vm.PoolID != poolID {
+	if vm.ID != nodeRef.ID {
 		klog.Errorf("instances.getInstance() vm.name(%s) != node.name(%s) with uuid=%s", vm.NameLabel, node.Name, nodeRef.ID)
 
-		return nil, fmt.Errorf("instances.getInstance() error: vm.PoolID=%s mismatches nodePoolID=%s", vm.PoolID, poolID)
+		return nil, nil, fmt.Errorf("instances.getInstance() error: vm.ID=%s mismatches nodeID=%s", vm.ID, nodeRef.ID)
+	}
+
+	// The providerID is immutable, its pool is historical once the VM migrated to another pool
+	if vm.PoolID != poolID {
+		klog.V(4).InfoS("instances.getInstance() VM migrated to another pool than the providerID one", "node", klog.KObj(node),
+			"providerIDPool", poolID, "pool", vm.PoolID)
+	}
+
+	if err := checkVMCluster(&vm, i.clusterID); err != nil {
+		return nil, nil, fmt.Errorf("instances.getInstance() error: %v", err)
 	}
 
 	klog.V(5).Infof("instances.getInstance() vm %+v", vm)
 
-	return vm, nil
+	return &vm, found, nil
 }
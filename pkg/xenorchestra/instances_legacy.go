@@ -0,0 +1,211 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// The legacy Instances interface is served for older control planes, the lookups are shared with InstancesV2.
+var _ cloudprovider.Instances = &instances{}
+
+// NodeAddresses returns the addresses of the specified instance.
+func (i *instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.NodeAddress, error) {
+	klog.V(4).InfoS("instances.NodeAddresses() called", "node", name)
+
+	vmRef, node, endpoint, err := i.getInstanceByNodeName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.instanceAddresses(ctx, endpoint, node, vmRef)
+}
+
+// NodeAddressesByProviderID returns the addresses of the specified instance.
+func (i *instances) NodeAddressesByProviderID(ctx context.Context, providerID string) ([]v1.NodeAddress, error) {
+	klog.V(4).InfoS("instances.NodeAddressesByProviderID() called", "providerID", providerID)
+
+	node := nodeFromProviderID(providerID)
+
+	vmRef, endpoint, err := i.getInstance(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.instanceAddresses(ctx, endpoint, node, vmRef)
+}
+
+// InstanceID returns the cloud provider ID of the node with the specified NodeName.
+// The cloud provider ID is the providerID without the "xenorchestra://" prefix.
+func (i *instances) InstanceID(ctx context.Context, nodeName types.NodeName) (string, error) {
+	klog.V(4).InfoS("instances.InstanceID() called", "node", nodeName)
+
+	vmRef, _, _, err := i.getInstanceByNodeName(ctx, nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(xok8s.GetProviderID(vmRef.PoolID, vmRef), xok8s.ProviderName+"://"), nil
+}
+
+// InstanceType returns the type of the specified instance.
+func (i *instances) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
+	klog.V(4).InfoS("instances.InstanceType() called", "node", name)
+
+	vmRef, _, endpoint, err := i.getInstanceByNodeName(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	return i.instanceType.instanceType(endpoint.c, vmRef), nil
+}
+
+// InstanceTypeByProviderID returns the type of the specified instance.
+func (i *instances) InstanceTypeByProviderID(ctx context.Context, providerID string) (string, error) {
+	klog.V(4).InfoS("instances.InstanceTypeByProviderID() called", "providerID", providerID)
+
+	vmRef, endpoint, err := i.getInstance(ctx, nodeFromProviderID(providerID))
+	if err != nil {
+		return "", err
+	}
+
+	return i.instanceType.instanceType(endpoint.c, vmRef), nil
+}
+
+// AddSSHKeyToAllInstances is not implemented.
+func (i *instances) AddSSHKeyToAllInstances(_ context.Context, _ string, _ []byte) error {
+	return cloudprovider.NotImplemented
+}
+
+// CurrentNodeName returns the name of the node we are currently running on.
+// The node name is the hostname, as the VM name_label is not required to match it.
+func (i *instances) CurrentNodeName(_ context.Context, hostname string) (types.NodeName, error) {
+	return types.NodeName(hostname), nil
+}
+
+// InstanceExistsByProviderID returns true if the instance for the given provider exists.
+// The registered Node is used, so that the deletion guard reads its annotations and labels.
+func (i *instances) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
+	return i.InstanceExists(ctx, i.registeredNode(ctx, providerID))
+}
+
+// InstanceShutdownByProviderID returns true if the instance is shutdown in cloudprovider.
+func (i *instances) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
+	return i.InstanceShutdown(ctx, i.registeredNode(ctx, providerID))
+}
+
+// registeredNode returns the registered Node with the providerID, or a Node stub when it is not registered.
+func (i *instances) registeredNode(ctx context.Context, providerID string) *v1.Node {
+	if i.kubeClient == nil {
+		return nodeFromProviderID(providerID)
+	}
+
+	// The nodes are served from the watch cache of the API server
+	nodes, err := i.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		klog.ErrorS(err, "instances.registeredNode() failed to list the nodes", "providerID", providerID)
+
+		return nodeFromProviderID(providerID)
+	}
+
+	for idx := range nodes.Items {
+		if nodes.Items[idx].Spec.ProviderID == providerID {
+			return &nodes.Items[idx]
+		}
+	}
+
+	return nodeFromProviderID(providerID)
+}
+
+// getInstanceByNodeName returns the VM reference of the node and its Xen Orchestra endpoint. The registered Node is used when it exists,
+// so the lookup goes through the providerID or the SystemUUID. Otherwise the VM is searched by name_label.
+func (i *instances) getInstanceByNodeName(ctx context.Context, name types.NodeName) (*payloads.VM, *v1.Node, *xoEndpoint, error) {
+	if err := i.checkAvailable(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: string(name)}}
+
+	if i.kubeClient != nil {
+		registered, err := i.kubeClient.CoreV1().Nodes().Get(ctx, string(name), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, nil, nil, fmt.Errorf("instances.getInstanceByNodeName() failed to get node %s: %v", name, err)
+		}
+
+		if err == nil {
+			node = registered
+		}
+	}
+
+	if strings.HasPrefix(node.Spec.ProviderID, xok8s.ProviderName) {
+		vmRef, endpoint, err := i.getInstance(ctx, node)
+
+		return vmRef, node, endpoint, err
+	}
+
+	if node.Status.NodeInfo.SystemUUID != "" {
+		vmRef, endpoint, err := i.endpoints.findVMByNode(ctx, node)
+		if err != nil {
+			if err = classifyXOError(err); errors.Is(err, ErrXONotFound) {
+				return nil, nil, nil, cloudprovider.InstanceNotFound
+			}
+
+			return nil, nil, nil, fmt.Errorf("instances.getInstanceByNodeName() failed to find instance of node %s: %w", name, err)
+		}
+
+		if err := checkVMCluster(vmRef, i.clusterID); err != nil {
+			return nil, nil, nil, fmt.Errorf("instances.getInstanceByNodeName() error: %v", err)
+		}
+
+		return vmRef, node, endpoint, nil
+	}
+
+	vmRef, endpoint, err := i.endpoints.findVMByName(ctx, string(name))
+	if err != nil {
+		// Only a VM missing from every endpoint is not found, the instance may exist after any other error
+		if err = classifyXOError(err); errors.Is(err, ErrXONotFound) {
+			klog.V(4).InfoS("instances.getInstanceByNodeName() instance not found", "node", name, "err", err)
+
+			return nil, nil, nil, cloudprovider.InstanceNotFound
+		}
+
+		return nil, nil, nil, fmt.Errorf("instances.getInstanceByNodeName() failed to find instance of node %s: %w", name, err)
+	}
+
+	if err := checkVMCluster(vmRef, i.clusterID); err != nil {
+		return nil, nil, nil, fmt.Errorf("instances.getInstanceByNodeName() error: %v", err)
+	}
+
+	return vmRef, node, endpoint, nil
+}
+
+// nodeFromProviderID returns a Node stub, so the providerID lookups share the InstancesV2 code.
+func nodeFromProviderID(providerID string) *v1.Node {
+	return &v1.Node{Spec: v1.NodeSpec{ProviderID: providerID}}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func (ts *ccmTestSuite) initializeLegacyNodes() {
+	ts.i.initialize(fake.NewClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: pool1Node1},
+			Spec:       v1.NodeSpec{ProviderID: providerURIPool1Node1},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: cluster1Node2},
+			Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{SystemUUID: vmPool2Node1ID}},
+		},
+	))
+}
+
+func (ts *ccmTestSuite) TestLegacyInstancesByProviderID() {
+	addresses, err := ts.i.NodeAddressesByProviderID(context.Background(), providerURIPool1Node1)
+	ts.Require().NoError(err)
+	ts.Require().Equal([]v1.NodeAddress{{Type: v1.NodeExternalIP, Address: nodeExternalIP1}}, addresses)
+
+	instanceType, err := ts.i.InstanceTypeByProviderID(context.Background(), providerURIPool2Node1)
+	ts.Require().NoError(err)
+	ts.Require().Equal(instanceType2, instanceType)
+
+	exists, err := ts.i.InstanceExistsByProviderID(context.Background(), providerURIMissingVM)
+	ts.Require().NoError(err)
+	ts.Require().False(exists)
+
+	stopped, err := ts.i.InstanceShutdownByProviderID(context.Background(), providerURIPool2Node1)
+	ts.Require().NoError(err)
+	ts.Require().True(stopped)
+
+	_, err = ts.i.NodeAddressesByProviderID(context.Background(), providerURIMissingVM)
+	ts.Require().ErrorIs(err, cloudprovider.InstanceNotFound)
+}
+
+func (ts *ccmTestSuite) TestLegacyInstancesByNodeName() {
+	ts.initializeLegacyNodes()
+
+	tests := []struct {
+		msg                  string
+		nodeName             types.NodeName
+		expectedError        string
+		expectedInstanceID   string
+		expectedInstanceType string
+		expectedAddresses    []v1.NodeAddress
+	}{
+		{
+			msg:                  "NodeWithProviderID",
+			nodeName:             pool1Node1,
+			expectedInstanceID:   pool1ID + "/" + vmPool1Node1ID,
+			expectedInstanceType: instanceType1,
+			expectedAddresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: nodeExternalIP1},
+				{Type: v1.NodeHostName, Address: pool1Node1},
+			},
+		},
+		{
+			msg:                  "NodeWithSystemUUID",
+			nodeName:             cluster1Node2,
+			expectedInstanceID:   pool2ID + "/" + vmPool2Node1ID,
+			expectedInstanceType: instanceType2,
+			expectedAddresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: nodeExternalIP2},
+				{Type: v1.NodeExternalIP, Address: publicIP2},
+				{Type: v1.NodeExternalIP, Address: publicIPv6},
+				{Type: v1.NodeHostName, Address: cluster1Node2},
+			},
+		},
+		{
+			msg:                "UnregisteredNodeByVMName",
+			nodeName:           "test1-vm",
+			expectedInstanceID: pool1ID + "/" + vmPool1Node1ID,
+			expectedAddresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "test1-vm"},
+			},
+			expectedInstanceType: "0vCPU-0GB",
+		},
+		{
+			msg:           nodeNotExists,
+			nodeName:      cluster1Node500,
+			expectedError: cloudprovider.InstanceNotFound.Error(),
+		},
+	}
+
+	for _, testCase := range tests {
+		ts.Run(testCase.msg, func() {
+			instanceID, err := ts.i.InstanceID(context.Background(), testCase.nodeName)
+			if testCase.expectedError != "" {
+				ts.Require().EqualError(err, testCase.expectedError)
+
+				return
+			}
+
+			ts.Require().NoError(err)
+			ts.Require().Equal(testCase.expectedInstanceID, instanceID)
+
+			instanceType, err := ts.i.InstanceType(context.Background(), testCase.nodeName)
+			ts.Require().NoError(err)
+			ts.Require().Equal(testCase.expectedInstanceType, instanceType)
+
+			addresses, err := ts.i.NodeAddresses(context.Background(), testCase.nodeName)
+			ts.Require().NoError(err)
+			ts.Require().Equal(testCase.expectedAddresses, addresses)
+		})
+	}
+}
+
+func (ts *ccmTestSuite) TestLegacyZones() {
+	ts.initializeLegacyNodes()
+
+	zone, err := ts.i.GetZoneByProviderID(context.Background(), providerURIPool1Node1)
+	ts.Require().NoError(err)
+	ts.Require().Equal(cloudprovider.Zone{FailureDomain: host1ID, Region: pool1ID}, zone)
+
+	zone, err = ts.i.GetZoneByNodeName(context.Background(), cluster1Node2)
+	ts.Require().NoError(err)
+	ts.Require().Equal(cloudprovider.Zone{FailureDomain: host2ID, Region: pool2ID}, zone)
+
+	_, err = ts.i.GetZoneByProviderID(context.Background(), providerURIMissingVM)
+	ts.Require().ErrorIs(err, cloudprovider.InstanceNotFound)
+
+	nodeName, err := ts.i.CurrentNodeName(context.Background(), testNode1)
+	ts.Require().NoError(err)
+	ts.Require().Equal(types.NodeName(testNode1), nodeName)
+}
+
+func TestLegacyInstanceByNodeNameErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, "name_label:test1-vm").Return(nil, errors.New("invalid character '<' looking for beginning of value"))
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, "name_label:test2-vm").Return([]*payloads.VM{}, nil)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{Events: eventsConfig{Disabled: true}})
+
+	// The VMs could not be listed, the instance may exist
+	_, err := i.InstanceID(t.Context(), "test1-vm")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, cloudprovider.InstanceNotFound)
+
+	_, err = i.InstanceID(t.Context(), "test2-vm")
+	assert.ErrorIs(t, err, cloudprovider.InstanceNotFound)
+}
@@ -28,6 +28,7 @@ import (
 	"go.uber.org/mock/gomock"
 
 	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
 	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
 	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
 
@@ -229,11 +230,24 @@ func (ts *ccmTestSuite) SetupTest() {
 	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
 	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
 	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(&fakeV1Client{
+		vms: map[string]*client.Vm{
+			vmPool2Node1ID: {
+				Id: vmPool2Node1ID,
+				Addresses: map[string]string{
+					"0/ipv4/0": nodeExternalIP2,
+					"0/ipv6/0": "fe80::1",
+					"0/ipv6/1": publicIPv6,
+					"1/ipv4/0": publicIP2,
+				},
+			},
+		},
+	}).AnyTimes()
 	// Inject mock into XOClient
-	client := &xok8s.XoClient{
+	xoClient := &xok8s.XoClient{
 		Client: mockLib,
 	}
-	ts.i = newInstances(client)
+	ts.i = newInstances(xoClient, &cloudConfig{})
 }
 
 func (ts *ccmTestSuite) TearDownTest() {
@@ -277,7 +291,7 @@ func (ts *ccmTestSuite) TestInstanceExists() {
 			expected: true,
 		},
 		{
-			msg: "NodeWrongPool",
+			msg: "NodeMigratedToAnotherPool",
 			node: &v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: pool1Node1,
@@ -286,8 +300,7 @@ func (ts *ccmTestSuite) TestInstanceExists() {
 					ProviderID: providerURIWrongPool,
 				},
 			},
-			expected:      false,
-			expectedError: "instances.getInstance() error: vm.PoolID=" + pool1ID + " mismatches nodePoolID=" + pool3ID,
+			expected: true,
 		},
 		{
 			msg: nodeNotExists,
@@ -466,7 +479,7 @@ func (ts *ccmTestSuite) TestInstanceMetadata() {
 			expected: &cloudprovider.InstanceMetadata{},
 		},
 		{
-			msg: "NodeWrongPool",
+			msg: "NodeMigratedToAnotherPool",
 			node: &v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: pool3Node1,
@@ -475,8 +488,30 @@ func (ts *ccmTestSuite) TestInstanceMetadata() {
 					ProviderID: providerURIWrongPool,
 				},
 			},
-			expected:      &cloudprovider.InstanceMetadata{},
-			expectedError: "instances.getInstance() error: vm.PoolID=" + pool1ID + " mismatches nodePoolID=" + pool3ID,
+			// The providerID is kept, the labels follow the current pool of the VM
+			expected: &cloudprovider.InstanceMetadata{
+				ProviderID: providerURIWrongPool,
+				NodeAddresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeExternalIP,
+						Address: nodeExternalIP1,
+					},
+					{
+						Type:    v1.NodeHostName,
+						Address: pool3Node1,
+					},
+				},
+				InstanceType: instanceType1,
+				Region:       pool1ID,
+				Zone:         host1ID,
+				AdditionalLabels: map[string]string{
+					labelXOHostID:   host1ID,
+					labelXOPoolID:   pool1ID,
+					labelXOVMName:   pool1Node1,
+					labelXOPoolName: testPool1,
+					labelXOHostName: testHost1,
+				},
+			},
 		},
 		{
 			msg: nodeNotExists,
@@ -0,0 +1,167 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	"k8s.io/klog/v2"
+)
+
+// Instance type strategies.
+const (
+	// InstanceTypeStrategyTag reads the k8s-instance-type=<name> VM tag.
+	InstanceTypeStrategyTag = "tag"
+	// InstanceTypeStrategyTemplate uses the name of the template the VM was created from.
+	InstanceTypeStrategyTemplate = "template"
+	// InstanceTypeStrategyCatalog matches the VM size against the catalog.
+	InstanceTypeStrategyCatalog = "catalog"
+	// InstanceTypeStrategySize formats the VM size, for example 2vCPU-4GB.
+	InstanceTypeStrategySize = "size"
+)
+
+const instanceTypeTagPrefix = "k8s-instance-type="
+
+// instanceTypeConfig is the InstanceType section of the cloud config.
+type instanceTypeConfig struct {
+	// Strategies are tried in order until one returns an instance type.
+	// The size strategy is always the last resort.
+	Strategies []string `yaml:"strategies,omitempty"`
+	// Catalog is the list of named sizes used by the catalog strategy, the first matching size wins.
+	Catalog []instanceTypeSize `yaml:"catalog,omitempty"`
+}
+
+// instanceTypeSize is a named size of the catalog. A zero maximum is unbounded.
+type instanceTypeSize struct {
+	Name         string `yaml:"name"`
+	MinCPUs      int    `yaml:"minCPUs,omitempty"`
+	MaxCPUs      int    `yaml:"maxCPUs,omitempty"`
+	MinMemoryMiB int    `yaml:"minMemoryMiB,omitempty"`
+	MaxMemoryMiB int    `yaml:"maxMemoryMiB,omitempty"`
+}
+
+func (c *instanceTypeConfig) validate() error {
+	for _, strategy := range c.Strategies {
+		switch strategy {
+		case InstanceTypeStrategyTag, InstanceTypeStrategyTemplate, InstanceTypeStrategySize:
+		case InstanceTypeStrategyCatalog:
+			if len(c.Catalog) == 0 {
+				return fmt.Errorf("catalog is required with the %q strategy", InstanceTypeStrategyCatalog)
+			}
+		default:
+			return fmt.Errorf("unsupported strategy %q", strategy)
+		}
+	}
+
+	for idx, size := range c.Catalog {
+		if size.Name == "" || sanitizeToLabel(size.Name) != size.Name {
+			return fmt.Errorf("catalog[%d]: name must be a valid label value, got %q", idx, size.Name)
+		}
+
+		if size.MinCPUs < 0 || size.MaxCPUs < 0 || size.MinMemoryMiB < 0 || size.MaxMemoryMiB < 0 {
+			return fmt.Errorf("catalog[%d]: sizes must be positive", idx)
+		}
+
+		if size.MaxCPUs > 0 && size.MaxCPUs < size.MinCPUs {
+			return fmt.Errorf("catalog[%d]: maxCPUs is lower than minCPUs", idx)
+		}
+
+		if size.MaxMemoryMiB > 0 && size.MaxMemoryMiB < size.MinMemoryMiB {
+			return fmt.Errorf("catalog[%d]: maxMemoryMiB is lower than minMemoryMiB", idx)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the VM size is in the catalog size ranges.
+func (s *instanceTypeSize) matches(vm *payloads.VM) bool {
+	cpus := int(vm.CPUs.Max)
+	memoryMiB := int(vm.Memory.Size / (1024 * 1024))
+
+	return cpus >= s.MinCPUs && (s.MaxCPUs == 0 || cpus <= s.MaxCPUs) &&
+		memoryMiB >= s.MinMemoryMiB && (s.MaxMemoryMiB == 0 || memoryMiB <= s.MaxMemoryMiB)
+}
+
+// instanceType returns the instance type of the VM with the first strategy returning one.
+func (c *instanceTypeConfig) instanceType(xo *xok8s.XoClient, vm *payloads.VM) string {
+	for _, strategy := range c.Strategies {
+		var instanceType string
+
+		switch strategy {
+		case InstanceTypeStrategyTag:
+			instanceType = vmInstanceTypeTag(vm)
+		case InstanceTypeStrategyTemplate:
+			instanceType = vmTemplateName(xo, vm)
+		case InstanceTypeStrategyCatalog:
+			for _, size := range c.Catalog {
+				if size.matches(vm) {
+					instanceType = size.Name
+
+					break
+				}
+			}
+		case InstanceTypeStrategySize:
+			instanceType = getInstanceType(vm)
+		}
+
+		if instanceType = sanitizeToLabel(instanceType); instanceType != "" {
+			return instanceType
+		}
+	}
+
+	return getInstanceType(vm)
+}
+
+// vmInstanceTypeTag returns the value of the k8s-instance-type VM tag.
+func vmInstanceTypeTag(vm *payloads.VM) string {
+	for _, tag := range vm.Tags {
+		if value, ok := strings.CutPrefix(tag, instanceTypeTagPrefix); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// vmTemplateName returns the name of the template the VM was created from.
+// The REST API does not expose the templates yet, so it is read with the JSON-RPC client.
+func vmTemplateName(xo *xok8s.XoClient, vm *payloads.VM) string {
+	v1Client := xo.Client.V1Client()
+	if v1Client == nil || vm.Template.IsNil() {
+		return ""
+	}
+
+	templates, err := v1Client.GetTemplate(client.Template{Id: vm.Template.String()})
+	if err != nil {
+		klog.ErrorS(err, "failed to get VM template", "vmID", vm.ID.String(), "templateID", vm.Template.String())
+
+		return ""
+	}
+
+	for _, template := range templates {
+		if template.Id == vm.Template.String() {
+			return template.NameLabel
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+)
+
+const testTemplateID = "3f2b1c4d-6e7a-4b8c-9d0e-1f2a3b4c5d6e"
+
+func TestInstanceTypeConfigValidate(t *testing.T) {
+	assert.NoError(t, (&instanceTypeConfig{}).validate())
+	assert.NoError(t, (&instanceTypeConfig{
+		Strategies: []string{InstanceTypeStrategyTag, InstanceTypeStrategyCatalog},
+		Catalog:    []instanceTypeSize{{Name: "small", MaxCPUs: 2, MaxMemoryMiB: 4096}},
+	}).validate())
+
+	assert.EqualError(t, (&instanceTypeConfig{Strategies: []string{"flavor"}}).validate(), `unsupported strategy "flavor"`)
+	assert.EqualError(t, (&instanceTypeConfig{Strategies: []string{InstanceTypeStrategyCatalog}}).validate(),
+		`catalog is required with the "catalog" strategy`)
+	assert.EqualError(t, (&instanceTypeConfig{Catalog: []instanceTypeSize{{Name: "very small"}}}).validate(),
+		`catalog[0]: name must be a valid label value, got "very small"`)
+	assert.EqualError(t, (&instanceTypeConfig{Catalog: []instanceTypeSize{{Name: "small", MinCPUs: 4, MaxCPUs: 2}}}).validate(),
+		"catalog[0]: maxCPUs is lower than minCPUs")
+}
+
+func TestInstanceTypeStrategies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(&fakeV1Client{
+		templates: []client.Template{{Id: testTemplateID, NameLabel: "Debian 12 worker"}},
+	}).AnyTimes()
+
+	xo := &xok8s.XoClient{Client: mockLib}
+
+	catalog := []instanceTypeSize{
+		{Name: "small", MaxCPUs: 2, MaxMemoryMiB: 4096},
+		{Name: "medium", MinCPUs: 2, MaxCPUs: 4, MinMemoryMiB: 4096, MaxMemoryMiB: 16384},
+	}
+
+	small := &payloads.VM{CPUs: payloads.CPUs{Max: 1}, Memory: payloads.Memory{Size: 512 * 1024 * 1024}}
+	large := &payloads.VM{CPUs: payloads.CPUs{Max: 8}, Memory: payloads.Memory{Size: 32 * 1024 * 1024 * 1024}}
+	tagged := &payloads.VM{
+		CPUs:     payloads.CPUs{Max: 2},
+		Memory:   payloads.Memory{Size: 8 * 1024 * 1024 * 1024},
+		Tags:     []string{"env=prod", instanceTypeTagPrefix + "gpu.xlarge"},
+		Template: uuid.FromStringOrNil(testTemplateID),
+	}
+	templated := &payloads.VM{
+		CPUs:     payloads.CPUs{Max: 2},
+		Memory:   payloads.Memory{Size: 8 * 1024 * 1024 * 1024},
+		Template: uuid.FromStringOrNil(testTemplateID),
+	}
+
+	tests := []struct {
+		name     string
+		config   instanceTypeConfig
+		vm       *payloads.VM
+		expected string
+	}{
+		{
+			name:     "default size",
+			vm:       small,
+			expected: "1vCPU-512MB",
+		},
+		{
+			name:     "tag",
+			config:   instanceTypeConfig{Strategies: []string{InstanceTypeStrategyTag, InstanceTypeStrategyTemplate}},
+			vm:       tagged,
+			expected: "gpu.xlarge",
+		},
+		{
+			name:     "template name when untagged",
+			config:   instanceTypeConfig{Strategies: []string{InstanceTypeStrategyTag, InstanceTypeStrategyTemplate}},
+			vm:       templated,
+			expected: "Debian-12-worker",
+		},
+		{
+			name:     "catalog",
+			config:   instanceTypeConfig{Strategies: []string{InstanceTypeStrategyTemplate, InstanceTypeStrategyCatalog}, Catalog: catalog},
+			vm:       small,
+			expected: "small",
+		},
+		{
+			name:     "size when nothing matches",
+			config:   instanceTypeConfig{Strategies: []string{InstanceTypeStrategyTag, InstanceTypeStrategyCatalog}, Catalog: catalog},
+			vm:       large,
+			expected: "8vCPU-32GB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.config.instanceType(xo, tt.vm))
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// inventoryVMBatch bounds the number of VM IDs of a filter, so that the request URL stays short.
+const inventoryVMBatch = 100
+
+// inventory is a snapshot of the VMs of the nodes, and of all the hosts and pools, by endpoint.
+// A VM is only served to the lookups of the endpoint it was fetched from.
+type inventory struct {
+	endpoints map[*xoEndpoint]*endpointInventory
+}
+
+// endpointInventory is the snapshot of an endpoint.
+type endpointInventory struct {
+	vms   map[uuid.UUID]*payloads.VM
+	hosts map[uuid.UUID]*payloads.Host
+	pools map[uuid.UUID]*payloads.Pool
+	// addresses are the guest tools addresses of the VMs, nil when they could not be fetched.
+	addresses map[uuid.UUID]map[string]string
+	// networks are the XO networks of the VMs by VIF device, nil unless a node address rule matches on the networks.
+	networks map[uuid.UUID]map[int]*client.Network
+}
+
+// of returns the snapshot of the endpoint, or nil when the inventory or the endpoint has none.
+func (inv *inventory) of(endpoint *xoEndpoint) *endpointInventory {
+	if inv == nil {
+		return nil
+	}
+
+	return inv.endpoints[endpoint]
+}
+
+type inventoryKey struct{}
+
+// inventoryFrom returns the inventory snapshot of the context, or nil.
+func inventoryFrom(ctx context.Context) *inventory {
+	inv, _ := ctx.Value(inventoryKey{}).(*inventory)
+
+	return inv
+}
+
+// WithInventory returns a context whose VM, host and pool lookups are served from a snapshot of
+// the inventory of the nodes, fetched with a few bulk requests instead of several requests per node.
+// The VMs missing from the snapshot are still looked up one by one.
+func (i *instances) WithInventory(ctx context.Context, nodes []*v1.Node) (context.Context, error) {
+	if err := i.checkAvailable(); err != nil {
+		return ctx, err
+	}
+
+	ids := map[*xoEndpoint][]string{}
+	unrouted := []string{}
+
+	for _, node := range nodes {
+		if !strings.HasPrefix(node.Spec.ProviderID, xok8s.ProviderName) {
+			continue
+		}
+
+		name, vmRef, poolID, err := parseProviderID(node.Spec.ProviderID)
+		if err != nil {
+			continue
+		}
+
+		endpoint, err := i.endpoints.route(ctx, name, poolID)
+		if err != nil {
+			continue
+		}
+
+		if endpoint == nil {
+			// The VMs of an unknown pool are looked up on all the endpoints
+			unrouted = append(unrouted, vmRef.ID.String())
+
+			continue
+		}
+
+		ids[endpoint] = append(ids[endpoint], vmRef.ID.String())
+	}
+
+	inv := &inventory{endpoints: map[*xoEndpoint]*endpointInventory{}}
+
+	var lastErr error
+
+	for _, endpoint := range i.endpoints.list {
+		endpointIDs := append(ids[endpoint], unrouted...)
+		if len(endpointIDs) == 0 {
+			continue
+		}
+
+		snapshot, err := fetchEndpointInventory(ctx, endpoint, endpointIDs, i.nodeAddresses.needsNetworks())
+		if err != nil {
+			// The nodes of this endpoint are looked up one by one
+			klog.ErrorS(err, "failed to get the inventory of the Xen Orchestra endpoint", "endpoint", endpoint)
+
+			lastErr = err
+
+			continue
+		}
+
+		inv.endpoints[endpoint] = snapshot
+
+		klog.V(4).InfoS("Fetched the inventory snapshot", "endpoint", endpoint,
+			"vms", len(snapshot.vms), "hosts", len(snapshot.hosts), "pools", len(snapshot.pools))
+	}
+
+	if len(inv.endpoints) == 0 && lastErr != nil {
+		return ctx, lastErr
+	}
+
+	return context.WithValue(ctx, inventoryKey{}, inv), nil
+}
+
+// fetchEndpointInventory returns the snapshot of the VMs, the hosts and the pools of the endpoint,
+// and of the addresses and the networks of the VMs.
+func fetchEndpointInventory(ctx context.Context, endpoint *xoEndpoint, ids []string, withNetworks bool) (*endpointInventory, error) {
+	snapshot := &endpointInventory{
+		vms:   map[uuid.UUID]*payloads.VM{},
+		hosts: map[uuid.UUID]*payloads.Host{},
+		pools: map[uuid.UUID]*payloads.Pool{},
+	}
+
+	for start := 0; start < len(ids); start += inventoryVMBatch {
+		batch := ids[start:min(start+inventoryVMBatch, len(ids))]
+
+		vms, err := endpoint.c.Client.VM().GetAll(ctx, 0, "id:|("+strings.Join(batch, " ")+")")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the VMs of the nodes: %w", classifyXOError(err))
+		}
+
+		for _, vm := range vms {
+			snapshot.vms[vm.ID] = vm
+		}
+	}
+
+	hosts, err := endpoint.c.Client.Host().GetAll(ctx, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hosts: %w", classifyXOError(err))
+	}
+
+	for _, host := range hosts {
+		snapshot.hosts[host.ID] = host
+	}
+
+	pools, err := endpoint.c.Client.Pool().GetAll(ctx, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the pools: %w", classifyXOError(err))
+	}
+
+	for _, pool := range pools {
+		snapshot.pools[pool.ID] = pool
+	}
+
+	if v1Client := endpoint.c.Client.V1Client(); v1Client != nil {
+		if err := snapshot.fetchAddresses(v1Client, withNetworks); err != nil {
+			// The addresses of the nodes are looked up one by one
+			klog.ErrorS(err, "failed to get the VM addresses of the Xen Orchestra endpoint", "endpoint", endpoint)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// fetchAddresses adds the addresses, and the networks when they are needed, of the VMs of the snapshot.
+func (snapshot *endpointInventory) fetchAddresses(v1Client client.XOClient, withNetworks bool) error {
+	all, err := fetchVMAddresses(v1Client)
+	if err != nil {
+		return err
+	}
+
+	addresses := map[uuid.UUID]map[string]string{}
+
+	for id := range snapshot.vms {
+		if vmAddresses, ok := all[id]; ok {
+			addresses[id] = vmAddresses
+		}
+	}
+
+	snapshot.addresses = addresses
+
+	if !withNetworks {
+		return nil
+	}
+
+	allNetworks, err := fetchVMNetworks(v1Client)
+	if err != nil {
+		return err
+	}
+
+	networks := map[uuid.UUID]map[int]*client.Network{}
+
+	for id := range snapshot.vms {
+		networks[id] = allNetworks[id]
+		if networks[id] == nil {
+			networks[id] = map[int]*client.Network{}
+		}
+	}
+
+	snapshot.networks = networks
+
+	return nil
+}
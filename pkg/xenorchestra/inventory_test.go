@@ -0,0 +1,193 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithInventory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	node1VM := &payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool1Node1ID),
+		NameLabel: pool1Node1,
+		PoolID:    uuid.FromStringOrNil(pool1ID),
+		Container: uuid.FromStringOrNil(host1ID),
+	}
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, "id:|("+vmPool1Node1ID+" "+vmPool1Node3ID+")").
+		Return([]*payloads.VM{node1VM}, nil).Times(1)
+	// The VM missing from the snapshot is still looked up
+	mockVM.EXPECT().GetByID(gomock.Any(), uuid.FromStringOrNil(vmPool1Node3ID)).Return(&payloads.VM{
+		ID:        uuid.FromStringOrNil(vmPool1Node3ID),
+		NameLabel: pool1Node3,
+		PoolID:    uuid.FromStringOrNil(pool1ID),
+		Container: uuid.FromStringOrNil(host1ID),
+	}, nil).Times(1)
+
+	mockHost := mock_library.NewMockHost(ctrl)
+	mockHost.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Host{
+		{ID: uuid.FromStringOrNil(host1ID), NameLabel: testHost1},
+	}, nil).Times(1)
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{
+		{ID: uuid.FromStringOrNil(pool1ID), NameLabel: testPool1},
+	}, nil).Times(1)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(&fakeV1Client{}).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{Cache: cacheConfig{Disabled: true}})
+
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: pool1Node1}, Spec: v1.NodeSpec{ProviderID: providerURIPool1Node1}},
+		{ObjectMeta: metav1.ObjectMeta{Name: pool1Node3}, Spec: v1.NodeSpec{ProviderID: providerURIPool1Node3}},
+		{ObjectMeta: metav1.ObjectMeta{Name: nodeForeignProviderID}, Spec: v1.NodeSpec{ProviderID: nodeForeignProviderURI}},
+	}
+
+	ctx, err := i.WithInventory(t.Context(), nodes)
+	require.NoError(t, err)
+
+	for _, node := range nodes[:2] {
+		meta, err := i.InstanceMetadata(ctx, node)
+		require.NoError(t, err)
+		assert.Equal(t, node.Spec.ProviderID, meta.ProviderID)
+		assert.Equal(t, host1ID, meta.Zone)
+		assert.Equal(t, pool1ID, meta.Region)
+	}
+}
+
+func TestWithInventoryAddresses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	vms := []*payloads.VM{}
+	rpc := &fakeV1Client{vms: map[string]*client.Vm{}, vifs: map[string][]client.VIF{}, networks: map[string]*client.Network{
+		"net-lan":     {Id: "net-lan", NameLabel: "lan"},
+		"net-storage": {Id: "net-storage", NameLabel: "storage"},
+	}}
+
+	nodes := []*v1.Node{}
+
+	for idx, id := range []string{vmPool1Node1ID, vmPool1Node3ID, "550e8400-e29b-41d4-a716-446655440011"} {
+		vms = append(vms, &payloads.VM{ID: uuid.FromStringOrNil(id), PoolID: uuid.FromStringOrNil(pool1ID), Container: uuid.FromStringOrNil(host1ID)})
+		rpc.vms[id] = &client.Vm{Id: id, Addresses: map[string]string{
+			"0/ipv4/0": fmt.Sprintf("10.0.0.%d", 10+idx),
+			"1/ipv4/0": fmt.Sprintf("172.16.0.%d", 10+idx),
+		}}
+		rpc.vifs[id] = []client.VIF{{Device: "0", Network: "net-lan"}, {Device: "1", Network: "net-storage"}}
+
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", idx)},
+			Spec:       v1.NodeSpec{ProviderID: xenorchestraProviderScheme + pool1ID + "/" + id},
+		})
+	}
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, gomock.Any()).Return(vms, nil).Times(1)
+
+	mockHost := mock_library.NewMockHost(ctrl)
+	mockHost.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Host{{ID: uuid.FromStringOrNil(host1ID)}}, nil).Times(1)
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{{ID: uuid.FromStringOrNil(pool1ID)}}, nil).Times(1)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(rpc).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{NodeAddresses: nodeAddressesConfig{Rules: []nodeAddressRule{
+		{Type: NodeAddressTypeInternalIP, Networks: []string{"lan"}},
+		{Type: NodeAddressTypeExclude, Networks: []string{"storage"}},
+	}}})
+
+	ctx, err := i.WithInventory(t.Context(), nodes)
+	require.NoError(t, err)
+
+	for idx, node := range nodes {
+		meta, err := i.InstanceMetadata(ctx, node)
+		require.NoError(t, err)
+		assert.Equal(t, []v1.NodeAddress{
+			{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", 10+idx)},
+			{Type: v1.NodeHostName, Address: node.Name},
+		}, meta.NodeAddresses)
+	}
+
+	// The VMs, the VIFs and the networks are listed once, whatever the number of nodes and of VIFs
+	assert.Equal(t, 3, rpc.lists)
+	assert.Equal(t, 0, rpc.lookups)
+}
+
+func TestWithInventoryError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, gomock.Any()).Return(nil, errors.New("API error: 503 Service Unavailable - {}"))
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	i := newInstances(&xok8s.XoClient{Client: mockLib}, &cloudConfig{})
+
+	ctx, err := i.WithInventory(t.Context(), []*v1.Node{nodeFromProviderID(providerURIPool1Node1)})
+	assert.ErrorIs(t, err, ErrXOTransient)
+	assert.Nil(t, inventoryFrom(ctx), "the caller falls back to the per node lookups")
+}
+
+func TestInventoryByEndpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	vm1 := &payloads.VM{ID: uuid.FromStringOrNil(vmPool1Node1ID), NameLabel: pool1Node1, PoolID: uuid.FromStringOrNil(pool1ID)}
+
+	config := &cloudConfig{Events: eventsConfig{Disabled: true}}
+	dc1 := newXOEndpoint("dc1", &xok8s.XoClient{Client: endpointMocks(ctrl, pool1ID)}, xok8s.XoConfig{}, config)
+	dc2 := newXOEndpoint("dc2", &xok8s.XoClient{Client: endpointMocks(ctrl, pool2ID)}, xok8s.XoConfig{}, config)
+
+	ctx := context.WithValue(t.Context(), inventoryKey{}, &inventory{endpoints: map[*xoEndpoint]*endpointInventory{
+		dc1: {vms: map[uuid.UUID]*payloads.VM{vm1.ID: vm1}},
+	}})
+
+	vm, err := dc1.getVM(ctx, vm1.ID)
+	require.NoError(t, err)
+	assert.Same(t, vm1, vm)
+
+	// The VM of the snapshot of dc1 is not attributed to dc2
+	_, err = dc2.getVM(ctx, vm1.ID)
+	assert.ErrorContains(t, err, "404 Not Found")
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// LoadBalancerModeIPAM hands out VIPs from IP pools, the addresses are announced by in-cluster speakers.
+	LoadBalancerModeIPAM = "ipam"
+
+	// AnnotationLoadBalancerIPPool selects the IP pool used to allocate the Service VIP.
+	AnnotationLoadBalancerIPPool = "loadbalancer." + xok8s.XOLabelNamespace + "/ip_pool"
+	// AnnotationLoadBalancerIP records the VIP allocated to the Service, so it survives CCM restarts.
+	AnnotationLoadBalancerIP = "loadbalancer." + xok8s.XOLabelNamespace + "/ip"
+)
+
+// loadBalancerConfig is the LoadBalancer section of the cloud config.
+type loadBalancerConfig struct {
+	// Mode selects the LoadBalancer implementation. The LoadBalancer interface is disabled when empty.
+	Mode string `yaml:"mode,omitempty"`
+	// IPPools are the address ranges VIPs are allocated from.
+	IPPools []ipPoolConfig `yaml:"ipPools,omitempty"`
+	// VM configures the load balancer VMs in "vm" mode.
+	VM vmLoadBalancerConfig `yaml:"vm,omitempty"`
+}
+
+// ipPoolConfig describes a range of VIPs reserved on a Xen Orchestra network.
+type ipPoolConfig struct {
+	Name string `yaml:"name"`
+	// PoolID restricts the IP pool to Services whose nodes run in this Xen Orchestra pool.
+	PoolID string `yaml:"poolID,omitempty"`
+	// Network is the Xen Orchestra network (UUID or name_label) the addresses belong to.
+	// It must belong to PoolID, the IP pool is bound to the pool of the network when PoolID is empty.
+	Network string `yaml:"network,omitempty"`
+	// Ranges are CIDRs (10.0.0.0/28) or inclusive address ranges (10.0.0.10-10.0.0.20).
+	Ranges []string `yaml:"ranges"`
+}
+
+func (c *loadBalancerConfig) validate() error {
+	switch c.Mode {
+	case "":
+		return nil
+	case LoadBalancerModeIPAM:
+		if len(c.IPPools) == 0 {
+			return fmt.Errorf("at least one ipPool is required in %q mode", c.Mode)
+		}
+	case LoadBalancerModeVM:
+		if err := c.VM.validate(len(c.IPPools) > 0); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported mode %q", c.Mode)
+	}
+
+	names := map[string]bool{}
+	for _, pool := range c.IPPools {
+		if pool.Name == "" {
+			return fmt.Errorf("ipPool name is required")
+		}
+
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate ipPool name %q", pool.Name)
+		}
+
+		names[pool.Name] = true
+
+		if _, err := newIPPool(pool); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadBalancer is the LoadBalancer implementation used by the cloud provider.
+type loadBalancer interface {
+	cloudprovider.LoadBalancer
+	// initialize provides the Kubernetes client once the cloud provider is initialized.
+	initialize(kubeClient clientset.Interface)
+}
+
+func newLoadBalancer(config loadBalancerConfig, client *xok8s.XoClient) (loadBalancer, error) {
+	switch config.Mode {
+	case "":
+		return nil, nil
+	case LoadBalancerModeIPAM:
+		lb, err := newIPAMLoadBalancer(config, client)
+		if err != nil {
+			return nil, err
+		}
+
+		return lb, nil
+	case LoadBalancerModeVM:
+		lb, err := newVMLoadBalancer(config, client)
+		if err != nil {
+			return nil, err
+		}
+
+		return lb, nil
+	}
+
+	return nil, fmt.Errorf("unsupported LoadBalancer mode %q", config.Mode)
+}
+
+// patchServiceAnnotation sets the annotation on the Service, or removes it when value is empty.
+func patchServiceAnnotation(ctx context.Context, kubeClient clientset.Interface, service *v1.Service, key, value string) error {
+	var annotationValue any
+	if value != "" {
+		annotationValue = value
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				key: annotationValue,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = kubeClient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !(value == "" && apierrors.IsNotFound(err)) {
+		return fmt.Errorf("failed to patch service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,575 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// ipRange is an inclusive range of addresses.
+type ipRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+func (r ipRange) contains(addr netip.Addr) bool {
+	return r.first.Compare(addr) <= 0 && addr.Compare(r.last) <= 0
+}
+
+// parseIPRange parses a CIDR or a "first-last" address range.
+// For IPv4 CIDRs the network and broadcast addresses are excluded.
+func parseIPRange(s string) (ipRange, error) {
+	s = strings.TrimSpace(s)
+
+	if first, last, ok := strings.Cut(s, "-"); ok {
+		firstAddr, err := netip.ParseAddr(strings.TrimSpace(first))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid range %q: %v", s, err)
+		}
+
+		lastAddr, err := netip.ParseAddr(strings.TrimSpace(last))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid range %q: %v", s, err)
+		}
+
+		if firstAddr.Is4() != lastAddr.Is4() || lastAddr.Less(firstAddr) {
+			return ipRange{}, fmt.Errorf("invalid range %q", s)
+		}
+
+		return ipRange{first: firstAddr, last: lastAddr}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return ipRange{}, fmt.Errorf("invalid range %q: %v", s, err)
+	}
+
+	prefix = prefix.Masked()
+	first := prefix.Addr()
+	last := lastAddrOfPrefix(prefix)
+
+	if first.Is4() && prefix.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+
+	return ipRange{first: first, last: last}, nil
+}
+
+func lastAddrOfPrefix(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(addr)*8; bit++ {
+		addr[bit/8] |= 1 << (7 - bit%8)
+	}
+
+	last, _ := netip.AddrFromSlice(addr)
+
+	return last
+}
+
+// ipPool is a named set of address ranges VIPs are allocated from.
+type ipPool struct {
+	name   string
+	poolID uuid.UUID
+	// network is the configured network, it is checked against Xen Orchestra before the first allocation.
+	network string
+	ranges  []ipRange
+}
+
+func newIPPool(config ipPoolConfig) (*ipPool, error) {
+	pool := &ipPool{
+		name:    config.Name,
+		network: config.Network,
+	}
+
+	if config.PoolID != "" {
+		poolID, err := uuid.FromString(config.PoolID)
+		if err != nil {
+			return nil, fmt.Errorf("ipPool %q: poolID must be a UUID, got %q", config.Name, config.PoolID)
+		}
+
+		pool.poolID = poolID
+	}
+
+	if len(config.Ranges) == 0 {
+		return nil, fmt.Errorf("ipPool %q: at least one range is required", config.Name)
+	}
+
+	for _, r := range config.Ranges {
+		ipr, err := parseIPRange(r)
+		if err != nil {
+			return nil, fmt.Errorf("ipPool %q: %v", config.Name, err)
+		}
+
+		pool.ranges = append(pool.ranges, ipr)
+	}
+
+	return pool, nil
+}
+
+func (p *ipPool) contains(addr netip.Addr) bool {
+	for _, r := range p.ranges {
+		if r.contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipAllocator keeps track of the VIPs handed out to Services.
+type ipAllocator struct {
+	mu        sync.Mutex
+	allocated map[netip.Addr]string
+}
+
+func newIPAllocator() *ipAllocator {
+	return &ipAllocator{
+		allocated: map[netip.Addr]string{},
+	}
+}
+
+// allocate returns the address owned by owner in the pool. The requested address is used when it is valid,
+// otherwise the first free address matching the family is handed out.
+func (a *ipAllocator) allocate(pool *ipPool, owner string, requested netip.Addr, family v1.IPFamily) (netip.Addr, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if requested.IsValid() {
+		if !pool.contains(requested) {
+			return netip.Addr{}, fmt.Errorf("address %s is not part of ipPool %q", requested, pool.name)
+		}
+
+		if current, ok := a.allocated[requested]; ok && current != owner {
+			return netip.Addr{}, fmt.Errorf("address %s is already allocated to %s", requested, current)
+		}
+
+		a.releaseLocked(owner)
+		a.allocated[requested] = owner
+
+		return requested, nil
+	}
+
+	for addr, current := range a.allocated {
+		if current == owner && pool.contains(addr) && addressFamily(addr) == family {
+			return addr, nil
+		}
+	}
+
+	for _, r := range pool.ranges {
+		if addressFamily(r.first) != family {
+			continue
+		}
+
+		for addr := r.first; addr.IsValid() && r.contains(addr); addr = addr.Next() {
+			if _, ok := a.allocated[addr]; !ok {
+				a.releaseLocked(owner)
+				a.allocated[addr] = owner
+
+				return addr, nil
+			}
+		}
+	}
+
+	return netip.Addr{}, fmt.Errorf("no %s address available in ipPool %q", family, pool.name)
+}
+
+// reserve marks the address as owned by owner, it is used to restore allocations after a restart.
+func (a *ipAllocator) reserve(addr netip.Addr, owner string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if current, ok := a.allocated[addr]; ok && current != owner {
+		return false
+	}
+
+	a.allocated[addr] = owner
+
+	return true
+}
+
+func (a *ipAllocator) release(owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.releaseLocked(owner)
+}
+
+func (a *ipAllocator) releaseLocked(owner string) {
+	for addr, current := range a.allocated {
+		if current == owner {
+			delete(a.allocated, addr)
+		}
+	}
+}
+
+func (a *ipAllocator) lookup(owner string) (netip.Addr, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for addr, current := range a.allocated {
+		if current == owner {
+			return addr, true
+		}
+	}
+
+	return netip.Addr{}, false
+}
+
+func addressFamily(addr netip.Addr) v1.IPFamily {
+	if addr.Is4() {
+		return v1.IPv4Protocol
+	}
+
+	return v1.IPv6Protocol
+}
+
+// ipamLoadBalancer implements cloudprovider.LoadBalancer by allocating VIPs from IP pools.
+// The VIPs are written to the Service status and announced by in-cluster speakers.
+type ipamLoadBalancer struct {
+	client     *xok8s.XoClient
+	kubeClient clientset.Interface
+	pools      []*ipPool
+	allocator  *ipAllocator
+
+	restoreMu sync.Mutex
+	restored  bool
+}
+
+func newIPAMLoadBalancer(config loadBalancerConfig, client *xok8s.XoClient) (*ipamLoadBalancer, error) {
+	lb := &ipamLoadBalancer{
+		client:    client,
+		allocator: newIPAllocator(),
+	}
+
+	for _, poolConfig := range config.IPPools {
+		pool, err := newIPPool(poolConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		lb.pools = append(lb.pools, pool)
+	}
+
+	return lb, nil
+}
+
+func (l *ipamLoadBalancer) initialize(kubeClient clientset.Interface) {
+	l.kubeClient = kubeClient
+}
+
+// GetLoadBalancer returns whether the specified load balancer exists, and if so, what its status is.
+func (l *ipamLoadBalancer) GetLoadBalancer(ctx context.Context, _ string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	if err := l.restore(ctx); err != nil {
+		return nil, false, err
+	}
+
+	addr, ok := l.allocator.lookup(serviceKey(service))
+	if !ok {
+		return nil, false, nil
+	}
+
+	return loadBalancerStatus(addr), true, nil
+}
+
+// GetLoadBalancerName returns the name of the load balancer.
+func (l *ipamLoadBalancer) GetLoadBalancerName(_ context.Context, _ string, service *v1.Service) string {
+	return cloudprovider.DefaultLoadBalancerName(service)
+}
+
+// EnsureLoadBalancer allocates a VIP for the Service and records it in the Service annotations.
+func (l *ipamLoadBalancer) EnsureLoadBalancer(ctx context.Context, _ string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	klog.V(4).InfoS("ipamLoadBalancer.EnsureLoadBalancer() called", "service", klog.KObj(service))
+
+	if err := l.restore(ctx); err != nil {
+		return nil, err
+	}
+
+	pool, err := l.selectPool(service, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	requested, err := requestedLoadBalancerIP(service)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := l.allocator.allocate(pool, serviceKey(service), requested, serviceIPFamily(service))
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate load balancer address for service %s: %v", serviceKey(service), err)
+	}
+
+	if service.Annotations[AnnotationLoadBalancerIP] != addr.String() {
+		if err := patchServiceAnnotation(ctx, l.kubeClient, service, AnnotationLoadBalancerIP, addr.String()); err != nil {
+			l.allocator.release(serviceKey(service))
+
+			return nil, err
+		}
+	}
+
+	klog.V(2).InfoS("Allocated load balancer address", "service", klog.KObj(service), "ipPool", pool.name, "network", pool.network, "address", addr.String())
+
+	return loadBalancerStatus(addr), nil
+}
+
+// UpdateLoadBalancer has nothing to do, the VIP does not depend on the nodes.
+func (l *ipamLoadBalancer) UpdateLoadBalancer(_ context.Context, _ string, _ *v1.Service, _ []*v1.Node) error {
+	return nil
+}
+
+// EnsureLoadBalancerDeleted releases the VIP allocated to the Service.
+func (l *ipamLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, _ string, service *v1.Service) error {
+	klog.V(4).InfoS("ipamLoadBalancer.EnsureLoadBalancerDeleted() called", "service", klog.KObj(service))
+
+	if err := l.restore(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := service.Annotations[AnnotationLoadBalancerIP]; ok {
+		if err := patchServiceAnnotation(ctx, l.kubeClient, service, AnnotationLoadBalancerIP, ""); err != nil {
+			return err
+		}
+	}
+
+	l.allocator.release(serviceKey(service))
+
+	return nil
+}
+
+// restore checks the networks of the IP pools and rebuilds the allocations from the Service annotations, once per process.
+func (l *ipamLoadBalancer) restore(ctx context.Context) error {
+	l.restoreMu.Lock()
+	defer l.restoreMu.Unlock()
+
+	if l.restored {
+		return nil
+	}
+
+	if l.kubeClient == nil {
+		return fmt.Errorf("load balancer is not initialized")
+	}
+
+	if err := l.resolveNetworks(); err != nil {
+		return err
+	}
+
+	services, err := l.kubeClient.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+
+	for i := range services.Items {
+		service := &services.Items[i]
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			continue
+		}
+
+		value, ok := service.Annotations[AnnotationLoadBalancerIP]
+		if !ok {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			klog.ErrorS(err, "ignoring invalid load balancer address annotation", "service", klog.KObj(service))
+
+			continue
+		}
+
+		if !l.allocator.reserve(addr, serviceKey(service)) {
+			klog.InfoS("load balancer address is annotated on several services", "service", klog.KObj(service), "address", value)
+		}
+	}
+
+	l.restored = true
+
+	return nil
+}
+
+// resolveNetworks checks that the network of each IP pool exists in Xen Orchestra and belongs to its poolID.
+// IP pools without poolID are bound to the Xen Orchestra pool of their network.
+func (l *ipamLoadBalancer) resolveNetworks() error {
+	var pools []*ipPool
+	for _, pool := range l.pools {
+		if pool.network != "" {
+			pools = append(pools, pool)
+		}
+	}
+
+	if len(pools) == 0 {
+		return nil
+	}
+
+	var v1Client client.XOClient
+	if l.client != nil {
+		v1Client = l.client.Client.V1Client()
+	}
+
+	if v1Client == nil {
+		return fmt.Errorf("failed to list networks: JSON-RPC client is not available")
+	}
+
+	networks := map[string]client.Network{}
+	if err := v1Client.GetAllObjectsOfType(client.Network{}, &networks); err != nil {
+		return fmt.Errorf("failed to list networks: %v", err)
+	}
+
+	for _, pool := range pools {
+		network, err := findIPPoolNetwork(pool, networks)
+		if err != nil {
+			return err
+		}
+
+		if pool.poolID.IsNil() {
+			pool.poolID = uuid.FromStringOrNil(network.PoolId)
+		}
+
+		klog.V(4).InfoS("Resolved ipPool network", "ipPool", pool.name, "network", pool.network, "networkID", network.Id, "poolID", network.PoolId)
+	}
+
+	return nil
+}
+
+// findIPPoolNetwork returns the network of the IP pool, matched by UUID or name_label within its poolID.
+func findIPPoolNetwork(pool *ipPool, networks map[string]client.Network) (client.Network, error) {
+	var found []client.Network
+	for _, network := range networks {
+		if network.Id == pool.network || network.NameLabel == pool.network {
+			found = append(found, network)
+		}
+	}
+
+	if len(found) == 0 {
+		return client.Network{}, fmt.Errorf("ipPool %q: network %q not found", pool.name, pool.network)
+	}
+
+	if !pool.poolID.IsNil() {
+		found = slices.DeleteFunc(found, func(network client.Network) bool {
+			return network.PoolId != pool.poolID.String()
+		})
+
+		if len(found) == 0 {
+			return client.Network{}, fmt.Errorf("ipPool %q: network %q does not belong to pool %s", pool.name, pool.network, pool.poolID)
+		}
+	}
+
+	if len(found) > 1 {
+		return client.Network{}, fmt.Errorf("ipPool %q: network %q matches %d networks, use its UUID or set poolID", pool.name, pool.network, len(found))
+	}
+
+	return found[0], nil
+}
+
+// selectPool returns the IP pool for the Service: the annotated pool, the pool matching
+// the Xen Orchestra pool of most nodes, or the first pool not bound to an XO pool.
+func (l *ipamLoadBalancer) selectPool(service *v1.Service, nodes []*v1.Node) (*ipPool, error) {
+	if name, ok := service.Annotations[AnnotationLoadBalancerIPPool]; ok {
+		for _, pool := range l.pools {
+			if pool.name == name {
+				return pool, nil
+			}
+		}
+
+		return nil, fmt.Errorf("ipPool %q not found for service %s", name, serviceKey(service))
+	}
+
+	// The pool label follows the VM migrations, the pool of the providerID is the pool the VM was created in
+	counts := map[uuid.UUID]int{}
+	for _, node := range nodes {
+		if poolID, err := uuid.FromString(nodePool(node)); err == nil && !poolID.IsNil() {
+			counts[poolID]++
+		}
+	}
+
+	var selected *ipPool
+	for _, pool := range l.pools {
+		if pool.poolID.IsNil() || counts[pool.poolID] == 0 {
+			continue
+		}
+
+		if selected == nil || counts[pool.poolID] > counts[selected.poolID] {
+			selected = pool
+		}
+	}
+
+	if selected != nil {
+		return selected, nil
+	}
+
+	for _, pool := range l.pools {
+		if pool.poolID.IsNil() {
+			return pool, nil
+		}
+	}
+
+	return l.pools[0], nil
+}
+
+// requestedLoadBalancerIP returns the address recorded by a previous allocation or requested by the user.
+func requestedLoadBalancerIP(service *v1.Service) (netip.Addr, error) {
+	value := service.Annotations[AnnotationLoadBalancerIP]
+	if value == "" {
+		value = service.Spec.LoadBalancerIP
+	}
+
+	if value == "" {
+		return netip.Addr{}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid load balancer address %q for service %s: %v", value, serviceKey(service), err)
+	}
+
+	return addr, nil
+}
+
+func serviceIPFamily(service *v1.Service) v1.IPFamily {
+	if len(service.Spec.IPFamilies) > 0 {
+		return service.Spec.IPFamilies[0]
+	}
+
+	return v1.IPv4Protocol
+}
+
+func serviceKey(service *v1.Service) string {
+	return service.Namespace + "/" + service.Name
+}
+
+func loadBalancerStatus(addr netip.Addr) *v1.LoadBalancerStatus {
+	return &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{
+			{IP: addr.String()},
+		},
+	}
+}
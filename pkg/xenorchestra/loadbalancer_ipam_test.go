@@ -0,0 +1,324 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	lbIPPoolDefault = "default"
+	lbIPPoolPool2   = "pool-2"
+	lbServiceName   = "web"
+)
+
+var lbTestConfig = loadBalancerConfig{
+	Mode: LoadBalancerModeIPAM,
+	IPPools: []ipPoolConfig{
+		{Name: lbIPPoolDefault, Ranges: []string{"192.168.10.10-192.168.10.11", "2001:db8::/126"}},
+		{Name: lbIPPoolPool2, PoolID: pool2ID, Ranges: []string{"192.168.20.0/30"}},
+	},
+}
+
+func newTestLoadBalancerService(name string, annotations map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   metav1.NamespaceDefault,
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+}
+
+func newTestIPAMLoadBalancer(t *testing.T, objects ...*v1.Service) (*ipamLoadBalancer, *fake.Clientset) {
+	t.Helper()
+
+	client := fake.NewClientset()
+	for _, obj := range objects {
+		_, err := client.CoreV1().Services(obj.Namespace).Create(t.Context(), obj, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	lb, err := newIPAMLoadBalancer(lbTestConfig, nil)
+	require.NoError(t, err)
+
+	lb.initialize(client)
+
+	return lb, client
+}
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		first         string
+		last          string
+		expectedError bool
+	}{
+		{name: "ipv4 range", input: "10.0.0.10-10.0.0.20", first: "10.0.0.10", last: "10.0.0.20"},
+		{name: "ipv4 cidr excludes network and broadcast", input: "10.0.0.0/29", first: "10.0.0.1", last: "10.0.0.6"},
+		{name: "ipv4 /32", input: "10.0.0.5/32", first: "10.0.0.5", last: "10.0.0.5"},
+		{name: "ipv6 cidr", input: "2001:db8::/126", first: "2001:db8::", last: "2001:db8::3"},
+		{name: "reversed range", input: "10.0.0.20-10.0.0.10", expectedError: true},
+		{name: "mixed families", input: "10.0.0.1-2001:db8::1", expectedError: true},
+		{name: "invalid", input: "not-an-ip", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseIPRange(tt.input)
+			if tt.expectedError {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.first), r.first)
+			assert.Equal(t, netip.MustParseAddr(tt.last), r.last)
+		})
+	}
+}
+
+func TestLoadBalancerConfigValidate(t *testing.T) {
+	assert.NoError(t, (&loadBalancerConfig{}).validate())
+	assert.NoError(t, lbTestConfig.validate())
+
+	assert.EqualError(t, (&loadBalancerConfig{Mode: "unknown"}).validate(), `unsupported mode "unknown"`)
+	assert.EqualError(t, (&loadBalancerConfig{Mode: LoadBalancerModeIPAM}).validate(), `at least one ipPool is required in "ipam" mode`)
+	assert.EqualError(t, (&loadBalancerConfig{
+		Mode:    LoadBalancerModeIPAM,
+		IPPools: []ipPoolConfig{{Name: "a", Ranges: []string{"10.0.0.1/32"}}, {Name: "a", Ranges: []string{"10.0.0.2/32"}}},
+	}).validate(), `duplicate ipPool name "a"`)
+	assert.EqualError(t, (&loadBalancerConfig{
+		Mode:    LoadBalancerModeIPAM,
+		IPPools: []ipPoolConfig{{Name: "a", PoolID: "pool", Ranges: []string{"10.0.0.1/32"}}},
+	}).validate(), `ipPool "a": poolID must be a UUID, got "pool"`)
+}
+
+func TestIPAMLoadBalancerEnsureAllocatesAndAnnotates(t *testing.T) {
+	svc1 := newTestLoadBalancerService(lbServiceName, nil)
+	svc2 := newTestLoadBalancerService("api", nil)
+	svc3 := newTestLoadBalancerService("db", nil)
+	lb, client := newTestIPAMLoadBalancer(t, svc1, svc2, svc3)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+
+	// A second reconcile keeps the same address
+	status, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+
+	status, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.11", status.Ingress[0].IP)
+
+	_, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc3, nil)
+	assert.ErrorContains(t, err, `no IPv4 address available in ipPool "default"`)
+
+	stored, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(t.Context(), lbServiceName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", stored.Annotations[AnnotationLoadBalancerIP])
+
+	status, exists, err := lb.GetLoadBalancer(t.Context(), "kubernetes", svc1)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+}
+
+func TestIPAMLoadBalancerRestoresAllocationsFromAnnotations(t *testing.T) {
+	existing := newTestLoadBalancerService(lbServiceName, map[string]string{AnnotationLoadBalancerIP: "192.168.10.10"})
+	created := newTestLoadBalancerService("api", nil)
+	lb, _ := newTestIPAMLoadBalancer(t, existing, created)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", created, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.11", status.Ingress[0].IP)
+
+	status, exists, err := lb.GetLoadBalancer(t.Context(), "kubernetes", existing)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+}
+
+func TestIPAMLoadBalancerRequestedAddress(t *testing.T) {
+	svc := newTestLoadBalancerService(lbServiceName, nil)
+	svc.Spec.LoadBalancerIP = "192.168.10.11"
+	outside := newTestLoadBalancerService("api", nil)
+	outside.Spec.LoadBalancerIP = "10.10.10.10"
+	lb, _ := newTestIPAMLoadBalancer(t, svc, outside)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.11", status.Ingress[0].IP)
+
+	_, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", outside, nil)
+	assert.ErrorContains(t, err, `address 10.10.10.10 is not part of ipPool "default"`)
+}
+
+func TestIPAMLoadBalancerSelectPool(t *testing.T) {
+	annotated := newTestLoadBalancerService(lbServiceName, map[string]string{AnnotationLoadBalancerIPPool: lbIPPoolPool2})
+	byNodes := newTestLoadBalancerService("api", nil)
+	ipv6 := newTestLoadBalancerService("ipv6", nil)
+	ipv6.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol}
+	migrated := newTestLoadBalancerService("migrated", nil)
+	unknown := newTestLoadBalancerService("unknown", map[string]string{AnnotationLoadBalancerIPPool: "missing"})
+	lb, _ := newTestIPAMLoadBalancer(t, annotated, byNodes, ipv6, migrated, unknown)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", annotated, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.20.1", status.Ingress[0].IP)
+
+	nodes := []*v1.Node{
+		{Spec: v1.NodeSpec{ProviderID: providerURIPool2Node1}},
+		{Spec: v1.NodeSpec{ProviderID: nodeForeignProviderURI}},
+	}
+	status, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", byNodes, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.20.2", status.Ingress[0].IP)
+
+	// The VMs migrated to pool 2 since their providerID was set
+	nodes = []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{xok8s.XOLabelTopologyPoolID: pool2ID}},
+			Spec:       v1.NodeSpec{ProviderID: providerURIPool1Node1},
+		},
+	}
+	pool, err := lb.selectPool(migrated, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, lbIPPoolPool2, pool.name)
+
+	status, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", ipv6, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::", status.Ingress[0].IP)
+
+	_, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", unknown, nil)
+	assert.ErrorContains(t, err, `ipPool "missing" not found`)
+}
+
+func TestIPAMLoadBalancerNetworks(t *testing.T) {
+	v1Client := &fakeV1Client{networks: map[string]*client.Network{
+		"net-lan-1":  {Id: "net-lan-1", NameLabel: "lan", PoolId: pool1ID},
+		"net-lan-2":  {Id: "net-lan-2", NameLabel: "lan", PoolId: pool2ID},
+		"net-public": {Id: "net-public", NameLabel: "public", PoolId: pool1ID},
+	}}
+
+	ctrl := gomock.NewController(t)
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().V1Client().Return(v1Client).AnyTimes()
+
+	tests := []struct {
+		name          string
+		pools         []ipPoolConfig
+		expectedPool  string
+		expectedError string
+	}{
+		{
+			name:         "network binds the ipPool to its pool",
+			pools:        []ipPoolConfig{{Name: "public", Network: "public", Ranges: []string{"10.0.0.1/32"}}, {Name: lbIPPoolDefault, Ranges: []string{"10.0.1.1/32"}}},
+			expectedPool: "public",
+		},
+		{
+			name:         "network name label within poolID",
+			pools:        []ipPoolConfig{{Name: lbIPPoolDefault, Ranges: []string{"10.0.1.1/32"}}, {Name: lbIPPoolPool2, PoolID: pool1ID, Network: "lan", Ranges: []string{"10.0.0.1/32"}}},
+			expectedPool: lbIPPoolPool2,
+		},
+		{
+			name:          "unknown network",
+			pools:         []ipPoolConfig{{Name: lbIPPoolDefault, Network: "missing", Ranges: []string{"10.0.0.1/32"}}},
+			expectedError: `ipPool "default": network "missing" not found`,
+		},
+		{
+			name:          "network of another pool",
+			pools:         []ipPoolConfig{{Name: lbIPPoolDefault, PoolID: pool2ID, Network: "net-public", Ranges: []string{"10.0.0.1/32"}}},
+			expectedError: `ipPool "default": network "net-public" does not belong to pool ` + pool2ID,
+		},
+		{
+			name:          "ambiguous network name label",
+			pools:         []ipPoolConfig{{Name: lbIPPoolDefault, Network: "lan", Ranges: []string{"10.0.0.1/32"}}},
+			expectedError: `ipPool "default": network "lan" matches 2 networks, use its UUID or set poolID`,
+		},
+	}
+
+	nodes := []*v1.Node{{Spec: v1.NodeSpec{ProviderID: providerURIPool1Node1}}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestLoadBalancerService(lbServiceName, nil)
+
+			lb, err := newIPAMLoadBalancer(loadBalancerConfig{Mode: LoadBalancerModeIPAM, IPPools: tt.pools}, &xok8s.XoClient{Client: mockLib})
+			require.NoError(t, err)
+
+			lb.initialize(fake.NewClientset(svc))
+
+			_, err = lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, nodes)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			pool, err := lb.selectPool(svc, nodes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPool, pool.name)
+		})
+	}
+}
+
+func TestIPAMLoadBalancerEnsureDeletedReleasesAddress(t *testing.T) {
+	svc := newTestLoadBalancerService(lbServiceName, nil)
+	other := newTestLoadBalancerService("api", nil)
+	lb, client := newTestIPAMLoadBalancer(t, svc, other)
+
+	_, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, nil)
+	require.NoError(t, err)
+
+	stored, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(t.Context(), lbServiceName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	err = lb.EnsureLoadBalancerDeleted(t.Context(), "kubernetes", stored)
+	require.NoError(t, err)
+
+	stored, err = client.CoreV1().Services(metav1.NamespaceDefault).Get(t.Context(), lbServiceName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, stored.Annotations, AnnotationLoadBalancerIP)
+
+	_, exists, err := lb.GetLoadBalancer(t.Context(), "kubernetes", svc)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", other, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+}
@@ -0,0 +1,610 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"text/template"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LoadBalancerModeVM provisions dedicated load balancer VMs (HAProxy/keepalived) through Xen Orchestra.
+	LoadBalancerModeVM = "vm"
+
+	// loadBalancerVMTagPrefix tags the VMs owned by a load balancer.
+	loadBalancerVMTagPrefix = "k8s-loadbalancer="
+	// loadBalancerXenstoreKey holds the load balancer spec pushed to the VMs.
+	loadBalancerXenstoreKey = "vm-data/xenorchestra-ccm/loadbalancer"
+
+	defaultLoadBalancerVMNamePrefix = "k8s-lb"
+	keepalivedMasterPriority        = 150
+	keepalivedBackupPriority        = 100
+)
+
+// vmLoadBalancerConfig is the configuration of the load balancer VMs.
+type vmLoadBalancerConfig struct {
+	// PoolID is the Xen Orchestra pool the VMs are created in.
+	PoolID string `yaml:"poolID"`
+	// Template is the UUID of the VM template to clone.
+	Template string `yaml:"template"`
+	// Network is the UUID of the network attached to the VMs, the template VIFs are used when empty.
+	Network string `yaml:"network,omitempty"`
+	// NamePrefix is prepended to the VM name_label.
+	NamePrefix string `yaml:"namePrefix,omitempty"`
+	// Replicas is the number of VMs per load balancer. More than one replica requires ipPools for the keepalived VIP.
+	Replicas int `yaml:"replicas,omitempty"`
+	// MemoryMiB overrides the template memory.
+	MemoryMiB int `yaml:"memoryMiB,omitempty"`
+	// CloudConfig is a text/template rendered with the load balancer spec, it replaces the default HAProxy/keepalived cloud-config.
+	CloudConfig string `yaml:"cloudConfig,omitempty"`
+}
+
+func (c *vmLoadBalancerConfig) validate(hasIPPools bool) error {
+	if _, err := uuid.FromString(c.PoolID); err != nil {
+		return fmt.Errorf("vm.poolID must be a UUID, got %q", c.PoolID)
+	}
+
+	if _, err := uuid.FromString(c.Template); err != nil {
+		return fmt.Errorf("vm.template must be a UUID, got %q", c.Template)
+	}
+
+	if c.Network != "" {
+		if _, err := uuid.FromString(c.Network); err != nil {
+			return fmt.Errorf("vm.network must be a UUID, got %q", c.Network)
+		}
+	}
+
+	if c.Replicas < 0 {
+		return fmt.Errorf("vm.replicas must be positive")
+	}
+
+	if c.Replicas > 1 && !hasIPPools {
+		return fmt.Errorf("ipPools are required to allocate the VIP shared by %d replicas", c.Replicas)
+	}
+
+	if c.CloudConfig != "" {
+		if _, err := template.New("cloudConfig").Parse(c.CloudConfig); err != nil {
+			return fmt.Errorf("vm.cloudConfig: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// vmLoadBalancerSpec is rendered in the VM cloud-config and pushed to the VM xenstore.
+type vmLoadBalancerSpec struct {
+	Name     string               `json:"name"`
+	Service  string               `json:"service"`
+	VIP      string               `json:"vip,omitempty"`
+	Priority int                  `json:"priority"`
+	Ports    []vmLoadBalancerPort `json:"ports"`
+	Backends []string             `json:"backends"`
+}
+
+type vmLoadBalancerPort struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Port     int32  `json:"port"`
+	NodePort int32  `json:"nodePort"`
+}
+
+// defaultLoadBalancerCloudConfig configures HAProxy in TCP mode, and keepalived when a VIP is allocated.
+// The agent renders the HAProxy configuration again from the spec pushed to the xenstore, and reloads HAProxy when it changes.
+// It requires the guest tools of the template for xenstore-read.
+const defaultLoadBalancerCloudConfig = `#cloud-config
+packages:
+  - haproxy
+  - jq
+{{- if .VIP }}
+  - keepalived
+{{- end }}
+write_files:
+  - path: /usr/local/bin/xenorchestra-ccm-lb-agent
+    permissions: "0755"
+    content: |
+      #!/bin/sh
+      set -eu
+      spec=$(xenstore-read vm-data/xenorchestra-ccm/loadbalancer 2>/dev/null) || exit 0
+      cfg=$(mktemp)
+      trap 'rm -f "$cfg"' EXIT
+      cat > "$cfg" <<EOF
+      global
+        daemon
+        maxconn 10000
+      defaults
+        mode tcp
+        timeout connect 5s
+        timeout client 1h
+        timeout server 1h
+      EOF
+      echo "$spec" | jq -r '(.vip // "*") as $bind | .backends as $backends | .ports[] | select(.protocol == "TCP") | . as $port |
+        "frontend \($port.name)", "  bind \($bind):\($port.port)", "  default_backend \($port.name)",
+        "backend \($port.name)", "  balance roundrobin",
+        ($backends | to_entries[] | "  server node\(.key) \(.value):\($port.nodePort) check")' >> "$cfg"
+      if ! cmp -s "$cfg" /etc/haproxy/haproxy.cfg && haproxy -c -q -f "$cfg"; then
+        cp "$cfg" /etc/haproxy/haproxy.cfg
+        systemctl reload haproxy
+      fi
+  - path: /etc/systemd/system/xenorchestra-ccm-lb-agent.service
+    content: |
+      [Unit]
+      Description=Apply the load balancer backends pushed by the Xen Orchestra CCM
+      After=haproxy.service
+      [Service]
+      Type=oneshot
+      ExecStart=/usr/local/bin/xenorchestra-ccm-lb-agent
+  - path: /etc/systemd/system/xenorchestra-ccm-lb-agent.timer
+    content: |
+      [Timer]
+      OnActiveSec=10s
+      OnUnitActiveSec=10s
+      AccuracySec=1s
+      [Install]
+      WantedBy=timers.target
+  - path: /etc/haproxy/haproxy.cfg
+    content: |
+      global
+        daemon
+        maxconn 10000
+      defaults
+        mode tcp
+        timeout connect 5s
+        timeout client 1h
+        timeout server 1h
+{{- range $port := .Ports }}
+{{- if eq $port.Protocol "TCP" }}
+      frontend {{ $port.Name }}
+        bind {{ if $.VIP }}{{ $.VIP }}{{ else }}*{{ end }}:{{ $port.Port }}
+        default_backend {{ $port.Name }}
+      backend {{ $port.Name }}
+        balance roundrobin
+{{- range $i, $backend := $.Backends }}
+        server node{{ $i }} {{ $backend }}:{{ $port.NodePort }} check
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .VIP }}
+  - path: /etc/keepalived/keepalived.conf
+    content: |
+      vrrp_instance {{ .Name }} {
+        interface eth0
+        virtual_router_id {{ .RouterID }}
+        priority {{ .Priority }}
+        virtual_ipaddress {
+          {{ .VIP }}
+        }
+      }
+{{- end }}
+runcmd:
+  - sysctl -w net.ipv4.ip_nonlocal_bind=1
+{{- if .VIP }}
+  - systemctl enable --now keepalived
+{{- end }}
+  - systemctl enable haproxy
+  - systemctl restart haproxy
+  - systemctl daemon-reload
+  - systemctl enable --now xenorchestra-ccm-lb-agent.timer
+`
+
+// vmLoadBalancer implements cloudprovider.LoadBalancer with dedicated VMs created through Xen Orchestra.
+// Backends are rendered in the cloud-config on creation, then pushed to the VM xenstore on updates.
+type vmLoadBalancer struct {
+	client      *xok8s.XoClient
+	config      vmLoadBalancerConfig
+	poolID      uuid.UUID
+	template    uuid.UUID
+	network     uuid.UUID
+	cloudConfig *template.Template
+
+	// vip allocates the keepalived VIP, VMs use their own address when nil.
+	vip *ipamLoadBalancer
+}
+
+func newVMLoadBalancer(config loadBalancerConfig, client *xok8s.XoClient) (*vmLoadBalancer, error) {
+	lb := &vmLoadBalancer{
+		client:   client,
+		config:   config.VM,
+		poolID:   uuid.FromStringOrNil(config.VM.PoolID),
+		template: uuid.FromStringOrNil(config.VM.Template),
+		network:  uuid.FromStringOrNil(config.VM.Network),
+	}
+
+	if lb.config.NamePrefix == "" {
+		lb.config.NamePrefix = defaultLoadBalancerVMNamePrefix
+	}
+
+	if lb.config.Replicas == 0 {
+		lb.config.Replicas = 1
+	}
+
+	cloudConfig := config.VM.CloudConfig
+	if cloudConfig == "" {
+		cloudConfig = defaultLoadBalancerCloudConfig
+	}
+
+	tmpl, err := template.New("cloudConfig").Parse(cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lb.cloudConfig = tmpl
+
+	if len(config.IPPools) > 0 {
+		vip, err := newIPAMLoadBalancer(config, client)
+		if err != nil {
+			return nil, err
+		}
+
+		lb.vip = vip
+	}
+
+	return lb, nil
+}
+
+func (l *vmLoadBalancer) initialize(kubeClient clientset.Interface) {
+	if l.vip != nil {
+		l.vip.initialize(kubeClient)
+	}
+}
+
+// GetLoadBalancer returns whether the specified load balancer exists, and if so, what its status is.
+func (l *vmLoadBalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	vms, err := l.findVMs(ctx, l.GetLoadBalancerName(ctx, clusterName, service))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(vms) == 0 {
+		return nil, false, nil
+	}
+
+	if l.vip != nil {
+		return l.vip.GetLoadBalancer(ctx, clusterName, service)
+	}
+
+	if vms[0].MainIpAddress == "" {
+		return &v1.LoadBalancerStatus{}, true, nil
+	}
+
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: vms[0].MainIpAddress}}}, true, nil
+}
+
+// GetLoadBalancerName returns the name of the load balancer.
+func (l *vmLoadBalancer) GetLoadBalancerName(_ context.Context, _ string, service *v1.Service) string {
+	return cloudprovider.DefaultLoadBalancerName(service)
+}
+
+// EnsureLoadBalancer creates the load balancer VMs if they don't exist, and pushes the backends to them.
+func (l *vmLoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	klog.V(4).InfoS("vmLoadBalancer.EnsureLoadBalancer() called", "service", klog.KObj(service))
+
+	// HAProxy runs in TCP mode, the other protocols would get an address that does not forward traffic
+	for _, port := range service.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP {
+			return nil, fmt.Errorf("service %s: protocol %s of port %d is not supported, only TCP is", serviceKey(service), port.Protocol, port.Port)
+		}
+	}
+
+	var status *v1.LoadBalancerStatus
+
+	spec := l.loadBalancerSpec(ctx, clusterName, service, nodes)
+
+	if l.vip != nil {
+		var err error
+
+		status, err = l.vip.EnsureLoadBalancer(ctx, clusterName, service, nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		spec.VIP = status.Ingress[0].IP
+	}
+
+	vms, err := l.findVMs(ctx, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	for replica := range l.config.Replicas {
+		vmName := l.vmName(spec.Name, replica)
+		spec.Priority = keepalivedPriority(replica)
+
+		idx := slices.IndexFunc(vms, func(vm *payloads.VM) bool { return vm.NameLabel == vmName })
+		if idx >= 0 {
+			if err := l.publish(vms[idx], spec); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		vm, err := l.createVM(ctx, vmName, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		vms = append(vms, vm)
+	}
+
+	if status != nil {
+		return status, nil
+	}
+
+	if vms[0].MainIpAddress == "" {
+		return nil, fmt.Errorf("load balancer VM %s has no IP address yet", vms[0].NameLabel)
+	}
+
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: vms[0].MainIpAddress}}}, nil
+}
+
+// UpdateLoadBalancer pushes the new backends to the load balancer VMs.
+func (l *vmLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	klog.V(4).InfoS("vmLoadBalancer.UpdateLoadBalancer() called", "service", klog.KObj(service))
+
+	spec := l.loadBalancerSpec(ctx, clusterName, service, nodes)
+
+	if l.vip != nil {
+		status, exists, err := l.vip.GetLoadBalancer(ctx, clusterName, service)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			spec.VIP = status.Ingress[0].IP
+		}
+	}
+
+	vms, err := l.findVMs(ctx, spec.Name)
+	if err != nil {
+		return err
+	}
+
+	for replica := range l.config.Replicas {
+		vmName := l.vmName(spec.Name, replica)
+		spec.Priority = keepalivedPriority(replica)
+
+		for _, vm := range vms {
+			if vm.NameLabel != vmName {
+				continue
+			}
+
+			if err := l.publish(vm, spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnsureLoadBalancerDeleted deletes the load balancer VMs and releases the VIP.
+func (l *vmLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	klog.V(4).InfoS("vmLoadBalancer.EnsureLoadBalancerDeleted() called", "service", klog.KObj(service))
+
+	vms, err := l.findVMs(ctx, l.GetLoadBalancerName(ctx, clusterName, service))
+	if err != nil {
+		return err
+	}
+
+	for _, vm := range vms {
+		if err := l.deleteVM(ctx, vm); err != nil {
+			return err
+		}
+
+		klog.V(2).InfoS("Deleted load balancer VM", "service", klog.KObj(service), "vm", vm.NameLabel, "vmID", vm.ID.String())
+	}
+
+	if l.vip != nil {
+		return l.vip.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+	}
+
+	return nil
+}
+
+func (l *vmLoadBalancer) loadBalancerSpec(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) vmLoadBalancerSpec {
+	spec := vmLoadBalancerSpec{
+		Name:     l.GetLoadBalancerName(ctx, clusterName, service),
+		Service:  serviceKey(service),
+		Ports:    []vmLoadBalancerPort{},
+		Backends: []string{},
+	}
+
+	for _, port := range service.Spec.Ports {
+		name := port.Name
+		if name == "" {
+			name = fmt.Sprintf("port-%d", port.Port)
+		}
+
+		spec.Ports = append(spec.Ports, vmLoadBalancerPort{
+			Name:     name,
+			Protocol: string(port.Protocol),
+			Port:     port.Port,
+			NodePort: port.NodePort,
+		})
+	}
+
+	for _, node := range nodes {
+		if addr := nodeBackendAddress(node); addr != "" {
+			spec.Backends = append(spec.Backends, addr)
+		}
+	}
+
+	slices.Sort(spec.Backends)
+
+	return spec
+}
+
+// findVMs returns the VMs owned by the load balancer.
+func (l *vmLoadBalancer) findVMs(ctx context.Context, name string) ([]*payloads.VM, error) {
+	prefix := l.config.NamePrefix + "-" + name
+
+	vms, err := l.client.Client.VM().GetAll(ctx, 0, "name_label:"+prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list load balancer VMs %s: %v", prefix, err)
+	}
+
+	owned := []*payloads.VM{}
+	for _, vm := range vms {
+		if slices.Contains(vm.Tags, loadBalancerVMTagPrefix+name) {
+			owned = append(owned, vm)
+		}
+	}
+
+	slices.SortFunc(owned, func(a, b *payloads.VM) int {
+		switch {
+		case a.NameLabel < b.NameLabel:
+			return -1
+		case a.NameLabel > b.NameLabel:
+			return 1
+		}
+
+		return 0
+	})
+
+	return owned, nil
+}
+
+func (l *vmLoadBalancer) createVM(ctx context.Context, name string, spec vmLoadBalancerSpec) (*payloads.VM, error) {
+	var rendered bytes.Buffer
+	if err := l.cloudConfig.Execute(&rendered, cloudConfigData{vmLoadBalancerSpec: spec}); err != nil {
+		return nil, fmt.Errorf("failed to render cloud-config for load balancer VM %s: %v", name, err)
+	}
+
+	boot := true
+	userData := rendered.String()
+	params := &payloads.CreateVMParams{
+		NameLabel:       name,
+		NameDescription: "Load balancer of service " + spec.Service,
+		Template:        l.template,
+		Boot:            &boot,
+		CloudConfig:     &userData,
+	}
+
+	if !l.network.IsNil() {
+		params.VIFs = []payloads.VIFParams{{Network: &l.network}}
+	}
+
+	if l.config.MemoryMiB > 0 {
+		memory := l.config.MemoryMiB * 1024 * 1024
+		params.Memory = &memory
+	}
+
+	vm, err := l.client.Client.VM().Create(ctx, l.poolID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create load balancer VM %s: %v", name, err)
+	}
+
+	// An untagged VM is not found by findVMs, it would be created again on the next reconcile
+	if err := l.client.Client.VM().AddTag(ctx, vm.ID, loadBalancerVMTagPrefix+spec.Name); err != nil {
+		if deleteErr := l.deleteVM(ctx, vm); deleteErr != nil {
+			klog.ErrorS(deleteErr, "failed to delete untagged load balancer VM", "service", spec.Service, "vm", name, "vmID", vm.ID.String())
+		}
+
+		return nil, fmt.Errorf("failed to tag load balancer VM %s: %v", name, err)
+	}
+
+	klog.V(2).InfoS("Created load balancer VM", "service", spec.Service, "vm", name, "vmID", vm.ID.String())
+
+	return vm, nil
+}
+
+// deleteVM stops and deletes the load balancer VM.
+func (l *vmLoadBalancer) deleteVM(ctx context.Context, vm *payloads.VM) error {
+	if vm.PowerState == payloads.PowerStateRunning {
+		if _, err := l.client.Client.VM().HardShutdown(ctx, vm.ID); err != nil {
+			return fmt.Errorf("failed to stop load balancer VM %s: %v", vm.NameLabel, err)
+		}
+	}
+
+	if err := l.client.Client.VM().Delete(ctx, vm.ID); err != nil {
+		return fmt.Errorf("failed to delete load balancer VM %s: %v", vm.NameLabel, err)
+	}
+
+	return nil
+}
+
+// publish pushes the load balancer spec to the VM xenstore, the agent of the default cloud-config reloads HAProxy when it changes.
+func (l *vmLoadBalancer) publish(vm *payloads.VM, spec vmLoadBalancerSpec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	if vm.XenstoreData[loadBalancerXenstoreKey] == string(data) {
+		return nil
+	}
+
+	if err := setXenstoreData(l.client, vm.ID, map[string]any{loadBalancerXenstoreKey: string(data)}); err != nil {
+		return fmt.Errorf("failed to push backends to load balancer VM %s: %v", vm.NameLabel, err)
+	}
+
+	klog.V(4).InfoS("Pushed backends to load balancer VM", "service", spec.Service, "vm", vm.NameLabel, "backends", spec.Backends)
+
+	return nil
+}
+
+func (l *vmLoadBalancer) vmName(name string, replica int) string {
+	return fmt.Sprintf("%s-%s-%d", l.config.NamePrefix, name, replica)
+}
+
+// cloudConfigData exposes the load balancer spec and helpers to the cloud-config template.
+type cloudConfigData struct {
+	vmLoadBalancerSpec
+}
+
+// RouterID returns a VRRP router ID, between 1 and 255, hashed from the namespaced name of the service.
+func (d cloudConfigData) RouterID() int {
+	h := fnv.New32a()
+	h.Write([]byte(d.Service))
+
+	return int(h.Sum32()%255) + 1
+}
+
+func keepalivedPriority(replica int) int {
+	if replica == 0 {
+		return keepalivedMasterPriority
+	}
+
+	return keepalivedBackupPriority
+}
+
+// nodeBackendAddress returns the node address used as load balancer backend.
+func nodeBackendAddress(node *v1.Node) string {
+	for _, addressType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == addressType {
+				return addr.Address
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	lbTemplateID = "b7569d99-30f8-178a-7d94-801de3e29b5b"
+	lbVM0ID      = "3e2a9f5b-1c4d-4e6f-8a7b-9c0d1e2f3a4b"
+	lbVM1ID      = "4f3b0a6c-2d5e-4f70-9b8c-0d1e2f3a4b5c"
+)
+
+func newTestVMLoadBalancerConfig(replicas int) loadBalancerConfig {
+	config := loadBalancerConfig{
+		Mode: LoadBalancerModeVM,
+		VM: vmLoadBalancerConfig{
+			PoolID:   pool1ID,
+			Template: lbTemplateID,
+			Replicas: replicas,
+		},
+	}
+
+	if replicas > 1 {
+		config.IPPools = []ipPoolConfig{{Name: lbIPPoolDefault, Ranges: []string{"192.168.10.10-192.168.10.11"}}}
+	}
+
+	return config
+}
+
+func newTestVMLoadBalancer(t *testing.T, config loadBalancerConfig, mockVM *mock_library.MockVM, rpc client.XOClient, services ...*v1.Service) *vmLoadBalancer {
+	t.Helper()
+
+	ctrl := gomock.NewController(t)
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(rpc).AnyTimes()
+
+	lb, err := newVMLoadBalancer(config, &xok8s.XoClient{Client: mockLib})
+	require.NoError(t, err)
+
+	kubeClient := fake.NewClientset()
+	for _, svc := range services {
+		_, err := kubeClient.CoreV1().Services(svc.Namespace).Create(t.Context(), svc, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	lb.initialize(kubeClient)
+
+	return lb
+}
+
+func newTestVMLoadBalancerService() *v1.Service {
+	svc := newTestLoadBalancerService(lbServiceName, nil)
+	svc.UID = "3c6e1f64-1d2a-4b7c-8f3e-6a5b4c3d2e1f"
+	svc.Spec.Ports = []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}}
+
+	return svc
+}
+
+func newTestLoadBalancerNodes() []*v1.Node {
+	return []*v1.Node{
+		{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: nodeExternalIP2}}}},
+		{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: nodeExternalIP1}}}},
+		{Status: v1.NodeStatus{}},
+	}
+}
+
+func TestVMLoadBalancerConfigValidate(t *testing.T) {
+	config := newTestVMLoadBalancerConfig(1)
+	assert.NoError(t, config.validate())
+
+	config = newTestVMLoadBalancerConfig(2)
+	assert.NoError(t, config.validate())
+
+	config = newTestVMLoadBalancerConfig(2)
+	config.IPPools = nil
+	assert.EqualError(t, config.validate(), "ipPools are required to allocate the VIP shared by 2 replicas")
+
+	config = newTestVMLoadBalancerConfig(1)
+	config.VM.Template = "template"
+	assert.EqualError(t, config.validate(), `vm.template must be a UUID, got "template"`)
+
+	config = newTestVMLoadBalancerConfig(1)
+	config.VM.CloudConfig = "{{ .Missing"
+	assert.ErrorContains(t, config.validate(), "vm.cloudConfig:")
+}
+
+func TestVMLoadBalancerDefaultCloudConfig(t *testing.T) {
+	lb, err := newVMLoadBalancer(newTestVMLoadBalancerConfig(1), nil)
+	require.NoError(t, err)
+
+	svc := newTestVMLoadBalancerService()
+	spec := lb.loadBalancerSpec(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	spec.VIP = "192.168.10.10"
+	spec.Priority = keepalivedPriority(0)
+
+	assert.Equal(t, []string{nodeExternalIP1, nodeExternalIP2}, spec.Backends)
+
+	var rendered bytes.Buffer
+	require.NoError(t, lb.cloudConfig.Execute(&rendered, cloudConfigData{vmLoadBalancerSpec: spec}))
+	assert.Contains(t, rendered.String(), "bind 192.168.10.10:80")
+	assert.Contains(t, rendered.String(), "server node0 10.0.0.1:30080 check")
+	assert.Contains(t, rendered.String(), "server node1 10.0.0.2:30080 check")
+	assert.Contains(t, rendered.String(), "priority 150")
+
+	// The agent applies the backends pushed by publish
+	assert.Contains(t, rendered.String(), "xenstore-read "+loadBalancerXenstoreKey)
+	assert.Contains(t, rendered.String(), "systemctl enable --now xenorchestra-ccm-lb-agent.timer")
+}
+
+func TestVMLoadBalancerRouterID(t *testing.T) {
+	ab := cloudConfigData{vmLoadBalancerSpec{Service: "default/ab"}}
+	ba := cloudConfigData{vmLoadBalancerSpec{Service: "default/ba"}}
+
+	assert.NotEqual(t, ab.RouterID(), ba.RouterID())
+	assert.Equal(t, ab.RouterID(), cloudConfigData{vmLoadBalancerSpec{Service: "default/ab"}}.RouterID())
+
+	for _, service := range []string{"default/ab", "kube-system/ingress", "web/frontend"} {
+		id := cloudConfigData{vmLoadBalancerSpec{Service: service}}.RouterID()
+		assert.True(t, id >= 1 && id <= 255, "router ID %d of %s", id, service)
+	}
+}
+
+func TestVMLoadBalancerEnsureCreatesReplicas(t *testing.T) {
+	svc := newTestVMLoadBalancerService()
+	name := cloudprovider.DefaultLoadBalancerName(svc)
+
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, "name_label:k8s-lb-"+name).Return([]*payloads.VM{}, nil)
+
+	for _, vmID := range []string{lbVM0ID, lbVM1ID} {
+		mockVM.EXPECT().Create(gomock.Any(), uuid.FromStringOrNil(pool1ID), gomock.Any()).DoAndReturn(
+			func(_ any, _ uuid.UUID, params *payloads.CreateVMParams) (*payloads.VM, error) {
+				assert.Equal(t, uuid.FromStringOrNil(lbTemplateID), params.Template)
+				assert.Contains(t, *params.CloudConfig, "bind 192.168.10.10:80")
+
+				return &payloads.VM{ID: uuid.FromStringOrNil(vmID), NameLabel: params.NameLabel}, nil
+			},
+		)
+		mockVM.EXPECT().AddTag(gomock.Any(), uuid.FromStringOrNil(vmID), loadBalancerVMTagPrefix+name).Return(nil)
+	}
+
+	lb := newTestVMLoadBalancer(t, newTestVMLoadBalancerConfig(2), mockVM, nil, svc)
+
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.10", status.Ingress[0].IP)
+}
+
+func TestVMLoadBalancerEnsureRejectsNonTCPPorts(t *testing.T) {
+	svc := newTestVMLoadBalancerService()
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053})
+
+	// No VM is listed nor created, and no VIP is allocated
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+
+	lb := newTestVMLoadBalancer(t, newTestVMLoadBalancerConfig(2), mockVM, nil, svc)
+
+	_, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	assert.EqualError(t, err, "service default/web: protocol UDP of port 53 is not supported, only TCP is")
+
+	_, ok := lb.vip.allocator.lookup(serviceKey(svc))
+	assert.False(t, ok)
+}
+
+func TestVMLoadBalancerEnsureDeletesUntaggedVM(t *testing.T) {
+	svc := newTestVMLoadBalancerService()
+	name := cloudprovider.DefaultLoadBalancerName(svc)
+
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, "name_label:k8s-lb-"+name).Return([]*payloads.VM{}, nil)
+	mockVM.EXPECT().Create(gomock.Any(), uuid.FromStringOrNil(pool1ID), gomock.Any()).Return(
+		&payloads.VM{ID: uuid.FromStringOrNil(lbVM0ID), NameLabel: "k8s-lb-" + name + "-0", PowerState: runningState}, nil,
+	)
+	mockVM.EXPECT().AddTag(gomock.Any(), uuid.FromStringOrNil(lbVM0ID), loadBalancerVMTagPrefix+name).Return(errors.New("timeout"))
+	mockVM.EXPECT().HardShutdown(gomock.Any(), uuid.FromStringOrNil(lbVM0ID)).Return("", nil)
+	mockVM.EXPECT().Delete(gomock.Any(), uuid.FromStringOrNil(lbVM0ID)).Return(nil)
+
+	lb := newTestVMLoadBalancer(t, newTestVMLoadBalancerConfig(1), mockVM, nil, svc)
+
+	_, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	assert.ErrorContains(t, err, "failed to tag load balancer VM k8s-lb-"+name+"-0: timeout")
+}
+
+func TestVMLoadBalancerUpdatePublishesBackends(t *testing.T) {
+	svc := newTestVMLoadBalancerService()
+	name := cloudprovider.DefaultLoadBalancerName(svc)
+	vm := &payloads.VM{
+		ID:            uuid.FromStringOrNil(lbVM0ID),
+		NameLabel:     "k8s-lb-" + name + "-0",
+		Tags:          []string{loadBalancerVMTagPrefix + name},
+		MainIpAddress: nodeExternalIP3,
+		XenstoreData:  map[string]string{},
+	}
+	foreign := &payloads.VM{
+		ID:        uuid.FromStringOrNil(lbVM1ID),
+		NameLabel: "k8s-lb-" + name + "-1",
+	}
+
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, gomock.Any()).Return([]*payloads.VM{vm, foreign}, nil).AnyTimes()
+
+	rpc := &fakeV1Client{}
+	lb := newTestVMLoadBalancer(t, newTestVMLoadBalancerConfig(1), mockVM, rpc, svc)
+
+	err := lb.UpdateLoadBalancer(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	require.NoError(t, err)
+	require.Len(t, rpc.calls, 1)
+	assert.Equal(t, lbVM0ID, rpc.calls[0]["id"])
+
+	data := rpc.calls[0]["xenStoreData"].(map[string]any)[loadBalancerXenstoreKey].(string)
+
+	spec := vmLoadBalancerSpec{}
+	require.NoError(t, json.Unmarshal([]byte(data), &spec))
+	assert.Equal(t, []string{nodeExternalIP1, nodeExternalIP2}, spec.Backends)
+
+	// Unchanged backends are not pushed again
+	vm.XenstoreData[loadBalancerXenstoreKey] = data
+	status, err := lb.EnsureLoadBalancer(t.Context(), "kubernetes", svc, newTestLoadBalancerNodes())
+	require.NoError(t, err)
+	assert.Len(t, rpc.calls, 1)
+	assert.Equal(t, nodeExternalIP3, status.Ingress[0].IP)
+}
+
+func TestVMLoadBalancerEnsureDeleted(t *testing.T) {
+	svc := newTestVMLoadBalancerService()
+	name := cloudprovider.DefaultLoadBalancerName(svc)
+
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 0, gomock.Any()).Return([]*payloads.VM{
+		{ID: uuid.FromStringOrNil(lbVM0ID), NameLabel: "k8s-lb-" + name + "-0", Tags: []string{loadBalancerVMTagPrefix + name}, PowerState: runningState},
+		{ID: uuid.FromStringOrNil(lbVM1ID), NameLabel: "k8s-lb-" + name + "-1", Tags: []string{loadBalancerVMTagPrefix + name}, PowerState: haltedState},
+	}, nil)
+	mockVM.EXPECT().HardShutdown(gomock.Any(), uuid.FromStringOrNil(lbVM0ID)).Return("", nil)
+	mockVM.EXPECT().Delete(gomock.Any(), uuid.FromStringOrNil(lbVM0ID)).Return(nil)
+	mockVM.EXPECT().Delete(gomock.Any(), uuid.FromStringOrNil(lbVM1ID)).Return(nil)
+
+	lb := newTestVMLoadBalancer(t, newTestVMLoadBalancerConfig(2), mockVM, nil, svc)
+
+	err := lb.EnsureLoadBalancerDeleted(t.Context(), "kubernetes", svc)
+	require.NoError(t, err)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// Operations of the Xen Orchestra requests, they label the request metrics.
+const (
+	operationVMGet       = "vm_get"
+	operationVMList      = "vm_list"
+	operationVMCreate    = "vm_create"
+	operationVMDelete    = "vm_delete"
+	operationVMShutdown  = "vm_shutdown"
+	operationVMTag       = "vm_tag"
+	operationHostGet     = "host_get"
+	operationHostList    = "host_list"
+	operationPoolGet     = "pool_get"
+	operationPoolList    = "pool_list"
+	operationVIFList     = "vif_list"
+	operationNetworkGet  = "network_get"
+	operationNetworkList = "network_list"
+	operationObjectList  = "object_list"
+	operationTemplateGet = "template_get"
+	operationCheck       = "check"
+)
+
+// Outcomes of the cloud provider instance calls.
+const (
+	outcomeSuccess  = "success"
+	outcomeError    = "error"
+	outcomeExists   = "exists"
+	outcomeNotFound = "not_found"
+	outcomeRunning  = "running"
+	outcomeShutdown = "shutdown"
+)
+
+var (
+	apiRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "api",
+			Name:           "request_duration_seconds",
+			Help:           "Duration of the Xen Orchestra requests, by endpoint and operation.",
+			Buckets:        []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint", "operation"},
+	)
+	apiRequestErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "api",
+			Name:           "request_errors_total",
+			Help:           "Number of failed Xen Orchestra requests, by endpoint, operation and error class.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"endpoint", "operation", "class"},
+	)
+	instancesCalls = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "instances",
+			Name:           "calls_total",
+			Help:           "Number of InstanceExists, InstanceShutdown and InstanceMetadata calls, by method and outcome.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method", "outcome"},
+	)
+	deletionGuardTripped = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "deletion_guard",
+			Name:           "tripped",
+			Help:           "Whether the mass-deletion guard refuses to report the VMs as deleted.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	deletionGuardBlocked = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      "xenorchestra",
+			Subsystem:      "deletion_guard",
+			Name:           "blocked_total",
+			Help:           "Number of InstanceExists calls that did not report a VM as deleted because of the mass-deletion guard.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(apiRequestDuration, apiRequestErrors, instancesCalls, deletionGuardTripped, deletionGuardBlocked)
+}
+
+// observeAPIRequest records the duration and the error class of a Xen Orchestra request.
+func observeAPIRequest(endpoint, operation string, start time.Time, err error) {
+	apiRequestDuration.WithLabelValues(endpoint, operation).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		apiRequestErrors.WithLabelValues(endpoint, operation, errorClassLabel(err)).Inc()
+	}
+}
+
+// errorClassLabel returns the class of a Xen Orchestra error as a metric label.
+func errorClassLabel(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	switch xoErrorClass(err) {
+	case ErrXONotFound:
+		return "not_found"
+	case ErrXOUnauthorized:
+		return "unauthorized"
+	case ErrXOForbidden:
+		return "forbidden"
+	case ErrXORateLimited:
+		return "rate_limited"
+	case ErrXOTransient:
+		return "transient"
+	}
+
+	return "unknown"
+}
+
+// callOutcome returns the outcome of an instance call from its error and boolean result.
+func callOutcome(err error, result bool, ifTrue, ifFalse string) string {
+	switch {
+	case err != nil:
+		return outcomeError
+	case result:
+		return ifTrue
+	}
+
+	return ifFalse
+}
+
+// recordInstancesCall counts a cloud provider instance call by its outcome.
+func recordInstancesCall(method, outcome string) {
+	instancesCalls.WithLabelValues(method, outcome).Inc()
+}
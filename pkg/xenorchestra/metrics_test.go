@@ -0,0 +1,74 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestAPIRequestMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The endpoint name keeps the series of this test apart
+	g := newRequestGuard("metrics", rateLimitConfig{}, circuitBreakerConfig{Disabled: true})
+	mockVM, lib := guardedMockVM(ctrl, g)
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+
+	gomock.InOrder(
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(&payloads.VM{}, nil),
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, errors.New("API error: 404 Not Found - {}")),
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, errUnreachable),
+	)
+
+	for range 3 {
+		lib.VM().GetByID(t.Context(), id) //nolint:errcheck
+	}
+
+	count, err := testutil.GetHistogramMetricCount(apiRequestDuration.WithLabelValues("metrics", operationVMGet))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	for class, expected := range map[string]float64{"not_found": 1, "transient": 1, "unauthorized": 0} {
+		value, err := testutil.GetCounterMetricValue(apiRequestErrors.WithLabelValues("metrics", operationVMGet, class))
+		require.NoError(t, err)
+		assert.Equal(t, expected, value, class)
+	}
+}
+
+func TestErrorClassLabel(t *testing.T) {
+	assert.Equal(t, "unauthorized", errorClassLabel(errors.New("API error: 401 Unauthorized - {}")))
+	assert.Equal(t, "rate_limited", errorClassLabel(classifyXOError(errors.New("API error: 429 Too Many Requests - {}"))))
+	assert.Equal(t, "transient", errorClassLabel(context.DeadlineExceeded))
+	assert.Equal(t, "canceled", errorClassLabel(context.Canceled))
+	assert.Equal(t, "unknown", errorClassLabel(errors.New("unexpected payload")))
+}
+
+func TestCallOutcome(t *testing.T) {
+	assert.Equal(t, outcomeExists, callOutcome(nil, true, outcomeExists, outcomeNotFound))
+	assert.Equal(t, outcomeNotFound, callOutcome(nil, false, outcomeExists, outcomeNotFound))
+	assert.Equal(t, outcomeError, callOutcome(errUnreachable, true, outcomeExists, outcomeNotFound))
+}
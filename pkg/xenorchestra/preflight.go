@@ -0,0 +1,212 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// statusConfigMapName is the ConfigMap reporting the permission self-test, in the namespace of the CCM.
+	statusConfigMapName = "xenorchestra-ccm-status"
+
+	eventActionCheckPermissions   = "CheckPermissions"
+	eventReasonMissingPermissions = "MissingPermissions"
+
+	// Results of the permission self-test.
+	permissionsOK         = "OK"
+	permissionsMissing    = "MissingPermissions"
+	permissionsIncomplete = "Incomplete"
+)
+
+// missingPermissionsError is returned when the token of an endpoint cannot read the objects managed by the CCM.
+type missingPermissionsError struct {
+	missing []string
+}
+
+func (e *missingPermissionsError) Error() string {
+	// The event notes are limited to 1kB, the ConfigMap lists every missing permission
+	return fmt.Sprintf("%d missing permission(s), see the %s ConfigMap, first: %s", len(e.missing), statusConfigMapName, e.missing[0])
+}
+
+// permissionReport is the result of the permission self-test of the endpoints.
+type permissionReport struct {
+	// pools lists the pools checked, as "<endpoint>/<pool UUID> (<name>)".
+	pools []string
+	// missing lists the objects the tokens cannot read.
+	missing []string
+	// errors lists the checks that failed for another reason, for example a timeout.
+	errors []string
+}
+
+func (r *permissionReport) status() string {
+	switch {
+	case len(r.missing) > 0:
+		return permissionsMissing
+	case len(r.errors) > 0:
+		return permissionsIncomplete
+	}
+
+	return permissionsOK
+}
+
+// record adds the error of a check to the report, an authorization error is a missing permission.
+func (r *permissionReport) record(endpoint *xoEndpoint, what string, err error) {
+	problem := fmt.Sprintf("endpoint %s: cannot read %s: %v", endpoint, what, err)
+
+	if isXOAuthError(classifyXOError(err)) {
+		r.missing = append(r.missing, problem)
+
+		return
+	}
+
+	r.errors = append(r.errors, problem)
+}
+
+// checkPermissions verifies that the token of each endpoint can read the pools, and samples their hosts and VMs.
+// The report is stored in the status ConfigMap, and the missing permissions are recorded as an event.
+func (c *cloud) checkPermissions(ctx context.Context, kubeClient clientset.Interface) {
+	report := c.endpoints.checkPermissions(ctx)
+
+	if err := writeStatusConfigMap(ctx, kubeClient, report); err != nil {
+		klog.ErrorS(err, "failed to write the status ConfigMap", "configMap", klog.KRef(cloudProviderEventNamespace(), statusConfigMapName))
+	}
+
+	switch report.status() {
+	case permissionsMissing:
+		err := &missingPermissionsError{missing: report.missing}
+		klog.ErrorS(err, "the Xen Orchestra token is missing permissions")
+
+		if eventErr := recordCloudProviderInitializationFailure(ctx, kubeClient, err); eventErr != nil {
+			klog.ErrorS(eventErr, "failed to record Xen Orchestra missing permissions event")
+		}
+	case permissionsIncomplete:
+		klog.InfoS("The Xen Orchestra token permissions could not all be verified", "errors", report.errors)
+	default:
+		klog.InfoS("Verified the Xen Orchestra token permissions", "pools", len(report.pools))
+	}
+}
+
+// checkPermissions lists the pools of each endpoint, and reads a host and a VM of each pool.
+func (e *xoEndpoints) checkPermissions(ctx context.Context) *permissionReport {
+	report := &permissionReport{}
+
+	for _, endpoint := range e.list {
+		pools, err := endpoint.c.Client.Pool().GetAll(ctx, 0, "")
+		if err != nil {
+			report.record(endpoint, "the pools", err)
+
+			continue
+		}
+
+		visible := map[uuid.UUID]bool{}
+
+		for _, pool := range pools {
+			visible[pool.ID] = true
+			report.pools = append(report.pools, fmt.Sprintf("%s/%s (%s)", endpoint, pool.ID, pool.NameLabel))
+
+			filter := "$pool:" + pool.ID.String()
+
+			// A pool has at least its master host
+			hosts, err := endpoint.c.Client.Host().GetAll(ctx, 1, filter)
+			if err != nil {
+				report.record(endpoint, fmt.Sprintf("the hosts of pool %s", pool.ID), err)
+			} else if len(hosts) == 0 {
+				report.missing = append(report.missing, fmt.Sprintf("endpoint %s: no host of pool %s is visible", endpoint, pool.ID))
+			}
+
+			if _, err := endpoint.c.Client.VM().GetAll(ctx, 1, filter); err != nil {
+				report.record(endpoint, fmt.Sprintf("the VMs of pool %s", pool.ID), err)
+			}
+		}
+
+		// The configured pools must be visible, the discovered pools are visible by definition
+		for _, pool := range e.configuredPools(endpoint) {
+			if !visible[pool] {
+				report.missing = append(report.missing, fmt.Sprintf("endpoint %s: pool %s is not visible", endpoint, pool))
+			}
+		}
+	}
+
+	return report
+}
+
+// configuredPools returns the pools of the endpoint listed in the cloud config.
+func (e *xoEndpoints) configuredPools(endpoint *xoEndpoint) []uuid.UUID {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pools := []uuid.UUID{}
+
+	for pool := range e.static {
+		if e.pools[pool] == endpoint {
+			pools = append(pools, pool)
+		}
+	}
+
+	slices.SortFunc(pools, func(a, b uuid.UUID) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return pools
+}
+
+// writeStatusConfigMap stores the permission report in the status ConfigMap.
+func writeStatusConfigMap(ctx context.Context, kubeClient clientset.Interface, report *permissionReport) error {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      statusConfigMapName,
+			Namespace: cloudProviderEventNamespace(),
+		},
+		Data: map[string]string{
+			"status":             report.status(),
+			"checkedAt":          time.Now().UTC().Format(time.RFC3339),
+			"pools":              strings.Join(report.pools, "\n"),
+			"missingPermissions": strings.Join(report.missing, "\n"),
+			"errors":             strings.Join(report.errors, "\n"),
+		},
+	}
+
+	configMaps := kubeClient.CoreV1().ConfigMaps(configMap.Namespace)
+
+	existing, err := configMaps.Get(ctx, configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	existing.Data = configMap.Data
+	_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+
+	return err
+}
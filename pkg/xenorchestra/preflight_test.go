@@ -0,0 +1,90 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	pool1 := uuid.FromStringOrNil(pool1ID)
+	pool3 := uuid.FromStringOrNil(pool3ID)
+	filter := "$pool:" + pool1ID
+	forbidden := errors.New("API error: 403 Forbidden - {}")
+
+	mockPool := mock_library.NewMockPool(ctrl)
+	mockHost := mock_library.NewMockHost(ctrl)
+	mockVM := mock_library.NewMockVM(ctrl)
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().Pool().Return(mockPool).AnyTimes()
+	mockLib.EXPECT().Host().Return(mockHost).AnyTimes()
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	endpoint := newXOEndpoint("dc1", &xok8s.XoClient{Client: mockLib}, xok8s.XoConfig{}, &cloudConfig{Events: eventsConfig{Disabled: true}})
+	endpoints := newXOEndpoints([]*xoEndpoint{endpoint}, map[uuid.UUID]*xoEndpoint{pool1: endpoint, pool3: endpoint})
+	c := &cloud{endpoints: endpoints}
+
+	client := fake.NewClientset()
+
+	// The token cannot read the VMs, and the pool 3 is not visible
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return([]*payloads.Pool{{ID: pool1, NameLabel: "pool-1"}}, nil)
+	mockHost.EXPECT().GetAll(gomock.Any(), 1, filter).Return([]*payloads.Host{{}}, nil)
+	mockVM.EXPECT().GetAll(gomock.Any(), 1, filter).Return(nil, forbidden)
+
+	c.checkPermissions(t.Context(), client)
+
+	status, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(t.Context(), statusConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, permissionsMissing, status.Data["status"])
+	assert.Equal(t, "dc1/"+pool1ID+" (pool-1)", status.Data["pools"])
+	assert.Equal(t, "endpoint dc1: cannot read the VMs of pool "+pool1ID+": API error: 403 Forbidden - {}\n"+
+		"endpoint dc1: pool "+pool3ID+" is not visible", status.Data["missingPermissions"])
+
+	events, err := client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, corev1.EventTypeWarning, events.Items[0].Type)
+	assert.Equal(t, eventReasonMissingPermissions, events.Items[0].Reason)
+	assert.Contains(t, events.Items[0].Note, "2 missing permission(s), see the xenorchestra-ccm-status ConfigMap")
+
+	// A timeout is not a missing permission, the status is updated
+	mockPool.EXPECT().GetAll(gomock.Any(), 0, "").Return(nil, errUnreachable)
+
+	c.checkPermissions(t.Context(), client)
+
+	status, err = client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(t.Context(), statusConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, permissionsIncomplete, status.Data["status"])
+	assert.Empty(t, status.Data["missingPermissions"])
+	assert.Contains(t, status.Data["errors"], "endpoint dc1: cannot read the pools")
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/vatesfr/xenorchestra-go-sdk/client"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/services/library"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// configReloadInterval is the delay between two reads of the cloud config file.
+	configReloadInterval = 30 * time.Second
+
+	eventActionReloadConfig         = "ReloadConfig"
+	eventReasonConfigReloaded       = "ConfigReloaded"
+	eventReasonFailedToReloadConfig = "FailedToReloadConfig"
+)
+
+// reloadableLibrary serves the requests with the current Xen Orchestra library,
+// it is replaced when the connection settings are reloaded.
+type reloadableLibrary struct {
+	current atomic.Pointer[libraryRef]
+}
+
+// libraryRef boxes the library, atomic values require a single concrete type.
+type libraryRef struct {
+	library.Library
+}
+
+func newReloadableLibrary(lib library.Library) *reloadableLibrary {
+	r := &reloadableLibrary{}
+	r.set(lib)
+
+	return r
+}
+
+func (r *reloadableLibrary) get() library.Library {
+	return r.current.Load().Library
+}
+
+func (r *reloadableLibrary) set(lib library.Library) {
+	r.current.Store(&libraryRef{Library: lib})
+}
+
+func (r *reloadableLibrary) VM() library.VM {
+	return r.get().VM()
+}
+
+func (r *reloadableLibrary) Task() library.Task {
+	return r.get().Task()
+}
+
+func (r *reloadableLibrary) Pool() library.Pool {
+	return r.get().Pool()
+}
+
+func (r *reloadableLibrary) Host() library.Host {
+	return r.get().Host()
+}
+
+func (r *reloadableLibrary) VDI() library.VDI {
+	return r.get().VDI()
+}
+
+func (r *reloadableLibrary) VBD() library.VBD {
+	return r.get().VBD()
+}
+
+func (r *reloadableLibrary) PBD() library.PBD {
+	return r.get().PBD()
+}
+
+func (r *reloadableLibrary) SR() library.SR {
+	return r.get().SR()
+}
+
+func (r *reloadableLibrary) V1Client() v1.XOClient {
+	return r.get().V1Client()
+}
+
+// configReloader reads the cloud config file periodically, and replaces the clients of the
+// endpoints whose connection settings changed, for example after a token rotation.
+// A Secret mounted as the cloud config file is reloaded as well, the kubelet updates the file.
+type configReloader struct {
+	path      string
+	endpoints *xoEndpoints
+	config    cloudConfig
+	checksum  [sha256.Size]byte
+
+	newClient func(name string, config xok8s.XoConfig, failoverURLs []string) (*xok8s.XoClient, error)
+}
+
+func newConfigReloader(path string, config cloudConfig, endpoints *xoEndpoints) *configReloader {
+	return &configReloader{
+		path:      path,
+		endpoints: endpoints,
+		config:    config,
+		newClient: newEndpointClient,
+	}
+}
+
+// run reloads the cloud config until the context is done.
+func (r *configReloader) run(ctx context.Context, kubeClient clientset.Interface) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		reloaded, err := r.reload(ctx)
+		if err != nil {
+			klog.ErrorS(err, "failed to reload the cloud config, the current Xen Orchestra clients are kept", "path", r.path)
+
+			if eventErr := recordCloudProviderEvent(ctx, kubeClient, corev1.EventTypeWarning, eventActionReloadConfig, eventReasonFailedToReloadConfig,
+				fmt.Sprintf("Failed to reload the cloud config: %v", err)); eventErr != nil {
+				klog.ErrorS(eventErr, "failed to record cloud config reload failure event")
+			}
+
+			return
+		}
+
+		if reloaded {
+			if eventErr := recordCloudProviderEvent(ctx, kubeClient, corev1.EventTypeNormal, eventActionReloadConfig, eventReasonConfigReloaded,
+				"Reloaded the Xen Orchestra connection settings"); eventErr != nil {
+				klog.ErrorS(eventErr, "failed to record cloud config reload event")
+			}
+		}
+	}, configReloadInterval)
+}
+
+// reload applies the connection settings of the cloud config file when they changed. The new clients
+// replace the current ones only once they all pass the client check, and it reports whether they did.
+func (r *configReloader) reload(ctx context.Context) (bool, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, err
+	}
+
+	checksum := sha256.Sum256(data)
+	if checksum == r.checksum {
+		return false, nil
+	}
+
+	config, err := readCloudConfig(bytes.NewReader(data))
+	if err != nil {
+		// The file is read again once it changes
+		r.checksum = checksum
+
+		return false, err
+	}
+
+	current, next := endpointConfigs(&r.config), endpointConfigs(&config)
+	if !sameEndpoints(current, next) {
+		r.checksum = checksum
+
+		return false, fmt.Errorf("the endpoints or their pools changed, restart the CCM to apply the changes")
+	}
+
+	clients := map[int]*xok8s.XoClient{}
+
+	for idx := range next {
+		if current[idx].XoConfig == next[idx].XoConfig && slices.Equal(current[idx].FailoverURLs, next[idx].FailoverURLs) {
+			continue
+		}
+
+		endpoint := r.endpoints.list[idx]
+
+		client, err := r.newClient(next[idx].Name, next[idx].XoConfig, next[idx].FailoverURLs)
+		if err != nil {
+			return false, fmt.Errorf("endpoint %s: %v", endpoint, err)
+		}
+
+		// The check is retried at the next reload, the file may be fixed or Xen Orchestra may recover
+		if err := client.CheckClient(ctx); err != nil {
+			return false, fmt.Errorf("endpoint %s: %v", endpoint, err)
+		}
+
+		clients[idx] = client
+	}
+
+	for idx, client := range clients {
+		r.endpoints.list[idx].setClient(client, next[idx].XoConfig)
+
+		klog.InfoS("Reloaded the Xen Orchestra connection settings", "endpoint", r.endpoints.list[idx], "url", next[idx].URL)
+	}
+
+	if !sameSettings(r.config, config) {
+		klog.InfoS("The cloud config changed, the settings other than the Xen Orchestra connection are applied at the next restart", "path", r.path)
+	}
+
+	r.config = config
+	r.checksum = checksum
+
+	return len(clients) > 0, nil
+}
+
+// sameEndpoints reports whether the endpoints have the same names and pools.
+func sameEndpoints(current, next []xoEndpointConfig) bool {
+	return slices.EqualFunc(current, next, func(a, b xoEndpointConfig) bool {
+		return a.Name == b.Name && slices.Equal(a.Pools, b.Pools)
+	})
+}
+
+// sameSettings reports whether the configs only differ by their Xen Orchestra connection settings.
+func sameSettings(current, next cloudConfig) bool {
+	current.XoConfig, current.FailoverURLs, current.Endpoints = xok8s.XoConfig{}, nil, nil
+	next.XoConfig, next.FailoverURLs, next.Endpoints = xok8s.XoConfig{}, nil, nil
+
+	return reflect.DeepEqual(current, next)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+)
+
+// checkLibrary returns a mocked library whose client check returns the error.
+func checkLibrary(ctrl *gomock.Controller, err error) *mock_library.MockLibrary {
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetAll(gomock.Any(), 1, "").Return([]*payloads.VM{}, err).AnyTimes()
+
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+
+	return mockLib
+}
+
+func TestConfigReloader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(config string) {
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	}
+
+	writeConfig(`
+url: https://xoa.example.com
+token: "12ABC"
+`)
+
+	config, err := readCloudConfig(strings.NewReader(`
+url: https://xoa.example.com
+token: "12ABC"
+`))
+	require.NoError(t, err)
+
+	initial := checkLibrary(ctrl, nil)
+	endpoints := newXOEndpoints([]*xoEndpoint{newXOEndpoint("", &xok8s.XoClient{Client: initial}, config.XoConfig, &config)}, nil)
+	endpoint := endpoints.list[0]
+
+	libraries := map[string]*mock_library.MockLibrary{
+		"34DEF": checkLibrary(ctrl, errUnreachable),
+		"56GHI": checkLibrary(ctrl, nil),
+	}
+
+	reloader := newConfigReloader(path, config, endpoints)
+	reloader.newClient = func(_ string, config xok8s.XoConfig, _ []string) (*xok8s.XoClient, error) {
+		return &xok8s.XoClient{Client: libraries[config.Token]}, nil
+	}
+
+	// Unchanged connection settings
+	reloaded, err := reloader.reload(t.Context())
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+	assert.Same(t, initial, endpoint.lib.get())
+
+	// The current client is kept until the new one passes the check
+	writeConfig(`
+url: https://xoa.example.com
+token: "34DEF"
+`)
+
+	_, err = reloader.reload(t.Context())
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Same(t, initial, endpoint.lib.get())
+
+	writeConfig(`
+url: https://xoa.example.com
+token: "56GHI"
+`)
+
+	reloaded, err = reloader.reload(t.Context())
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Same(t, libraries["56GHI"], endpoint.lib.get())
+	eventsConfig, _ := endpoint.eventsConfig()
+	assert.Equal(t, "56GHI", eventsConfig.Token)
+
+	// Invalid configs are not applied
+	writeConfig(`
+url: https://xoa.example.com
+`)
+
+	_, err = reloader.reload(t.Context())
+	assert.EqualError(t, err, "either token or username/password are required for authentication")
+	assert.Same(t, libraries["56GHI"], endpoint.lib.get())
+
+	writeConfig(`
+endpoints:
+  - name: dc1
+    url: https://xoa.example.com
+    token: "56GHI"
+`)
+
+	_, err = reloader.reload(t.Context())
+	assert.EqualError(t, err, "the endpoints or their pools changed, restart the CCM to apply the changes")
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// failureEventInterval is the minimum delay between two updates of the failure event of an object.
+	failureEventInterval = 5 * time.Minute
+	// failureEventQPS and failureEventBurst limit the failure events of all the objects.
+	failureEventQPS   = 1
+	failureEventBurst = 25
+)
+
+// Actions of the failure events.
+const (
+	eventActionGetInstance = "GetInstance"
+	eventActionGetHost     = "GetHost"
+	eventActionGetPool     = "GetPool"
+)
+
+// Reasons of the failure events, from the class of the error.
+const (
+	eventReasonAccessDenied      = "AccessDenied"
+	eventReasonUnreachable       = "Unreachable"
+	eventReasonNotFound          = "NotFound"
+	eventReasonInvalidProviderID = "InvalidProviderID"
+	eventReasonFailed            = "Failed"
+)
+
+// eventReporter records the failures of the Xen Orchestra requests as warning events, regarding the affected node or the CCM.
+// The repeated failures are aggregated into the series of their event, which is updated at most every interval.
+type eventReporter struct {
+	kubeClient clientset.Interface
+	interval   time.Duration
+	limiter    flowcontrol.RateLimiter
+	now        func() time.Time
+
+	mu sync.Mutex
+	// recorded tracks the events by name, until their interval ends without new occurrences.
+	recorded map[string]*reportedEvent
+}
+
+type reportedEvent struct {
+	recordedAt time.Time
+	// suppressed counts the occurrences since the event was last recorded.
+	suppressed int32
+}
+
+func newEventReporter(kubeClient clientset.Interface) *eventReporter {
+	return &eventReporter{
+		kubeClient: kubeClient,
+		interval:   failureEventInterval,
+		limiter:    flowcontrol.NewTokenBucketRateLimiter(failureEventQPS, failureEventBurst),
+		now:        time.Now,
+		recorded:   map[string]*reportedEvent{},
+	}
+}
+
+// reportFailure records a warning event for the error, regarding the node or the CCM when the node is nil.
+// The reporter is nil until the cloud provider is initialized.
+func (r *eventReporter) reportFailure(ctx context.Context, node *corev1.Node, action string, err error) {
+	// The cancellations are not failures, and the unavailability of Xen Orchestra is reported by the client check
+	if r == nil || err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errWaitingForXO) {
+		return
+	}
+
+	regarding, namespace := cloudProviderEventObjectReference(), cloudProviderEventNamespace()
+	if node != nil {
+		// The events of the cluster scoped objects are in the default namespace
+		regarding = corev1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: node.Name, UID: node.UID}
+		namespace = metav1.NamespaceDefault
+	}
+
+	reason := failureReason(err)
+
+	occurrences, ok := r.allow(cloudProviderEventName(regarding, action, reason))
+	if !ok {
+		return
+	}
+
+	if eventErr := recordEvent(ctx, r.kubeClient, regarding, namespace, occurrences, corev1.EventTypeWarning, action, reason,
+		fmt.Sprintf("%v", err)); eventErr != nil {
+		klog.ErrorS(eventErr, "failed to record Xen Orchestra failure event", "action", action, "reason", reason, "regarding", regarding.Name)
+	}
+}
+
+// allow reports whether the event can be recorded now, and the number of occurrences to add to its series.
+func (r *eventReporter) allow(name string) (int32, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+
+	for key, event := range r.recorded {
+		if event.suppressed == 0 && now.Sub(event.recordedAt) >= r.interval {
+			delete(r.recorded, key)
+		}
+	}
+
+	event, ok := r.recorded[name]
+	if !ok {
+		event = &reportedEvent{}
+		r.recorded[name] = event
+	}
+
+	if now.Sub(event.recordedAt) < r.interval || !r.limiter.TryAccept() {
+		event.suppressed++
+
+		return 0, false
+	}
+
+	occurrences := event.suppressed + 1
+	event.recordedAt, event.suppressed = now, 0
+
+	return occurrences, true
+}
+
+// failureReason returns the reason of the failure event from the class of the error.
+func failureReason(err error) string {
+	err = classifyXOError(err)
+
+	switch {
+	case isXOAuthError(err):
+		return eventReasonAccessDenied
+	case isXORetryable(err):
+		return eventReasonUnreachable
+	case errors.Is(err, ErrXONotFound), errors.Is(err, cloudprovider.InstanceNotFound):
+		return eventReasonNotFound
+	case errors.Is(err, ErrInvalidProviderID):
+		return eventReasonInvalidProviderID
+	case errors.Is(err, ErrDeletionBlocked):
+		return eventReasonDeletionBlocked
+	}
+
+	return eventReasonFailed
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestEventReporter(t *testing.T) {
+	client := fake.NewClientset()
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: testNode1, UID: "node-uid"}}
+	forbidden := errors.New("API error: 403 Forbidden - {}")
+
+	now := time.Now()
+	r := newEventReporter(client)
+	r.now = func() time.Time { return now }
+
+	r.reportFailure(t.Context(), node, eventActionGetInstance, forbidden)
+
+	events, err := client.EventsV1().Events(metav1.NamespaceDefault).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+
+	event := events.Items[0]
+	assert.Equal(t, v1.EventTypeWarning, event.Type)
+	assert.Equal(t, eventActionGetInstance, event.Action)
+	assert.Equal(t, eventReasonAccessDenied, event.Reason)
+	assert.Equal(t, v1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: testNode1, UID: "node-uid"}, event.Regarding)
+	assert.Nil(t, event.Series)
+
+	// The repeated failures are counted, and added to the series after the interval
+	r.reportFailure(t.Context(), node, eventActionGetInstance, forbidden)
+	r.reportFailure(t.Context(), node, eventActionGetInstance, forbidden)
+
+	got, err := client.EventsV1().Events(metav1.NamespaceDefault).Get(t.Context(), event.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, got.Series)
+
+	now = now.Add(failureEventInterval)
+	r.reportFailure(t.Context(), node, eventActionGetInstance, forbidden)
+
+	got, err = client.EventsV1().Events(metav1.NamespaceDefault).Get(t.Context(), event.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, got.Series)
+	assert.Equal(t, int32(4), got.Series.Count)
+
+	// The failures without a node are recorded on the CCM
+	r.reportFailure(t.Context(), nil, eventActionGetHost, errUnreachable)
+
+	events, err = client.EventsV1().Events(metav1.NamespaceSystem).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, eventReasonUnreachable, events.Items[0].Reason)
+	assert.Equal(t, ProviderName, events.Items[0].Regarding.Name)
+
+	// The cancellations and the unavailability at startup are not reported
+	r.reportFailure(t.Context(), node, eventActionGetPool, context.Canceled)
+	r.reportFailure(t.Context(), node, eventActionGetPool, fmt.Errorf("%w: %w", errWaitingForXO, ErrXOTransient))
+
+	events, err = client.EventsV1().Events(metav1.NamespaceDefault).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, events.Items, 1)
+
+	// The failures are dropped while the rate limit is reached
+	r.limiter = flowcontrol.NewFakeNeverRateLimiter()
+	r.reportFailure(t.Context(), node, eventActionGetPool, errUnreachable)
+
+	events, err = client.EventsV1().Events(metav1.NamespaceDefault).List(t.Context(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, events.Items, 1)
+
+	// A nil reporter does nothing
+	var nilReporter *eventReporter
+	nilReporter.reportFailure(t.Context(), node, eventActionGetInstance, forbidden)
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: errors.New("API error: 401 Unauthorized - {}"), expected: eventReasonAccessDenied},
+		{err: errors.New("API error: 403 Forbidden - {}"), expected: eventReasonAccessDenied},
+		{err: errUnreachable, expected: eventReasonUnreachable},
+		{err: errors.New("API error: 429 Too Many Requests - {}"), expected: eventReasonUnreachable},
+		{err: errors.New("API error: 404 Not Found - {}"), expected: eventReasonNotFound},
+		{err: cloudprovider.InstanceNotFound, expected: eventReasonNotFound},
+		{err: invalidProviderIDError(errors.New("bad")), expected: eventReasonInvalidProviderID},
+		{err: errors.New("cluster mismatch"), expected: eventReasonFailed},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, failureReason(tc.err), tc.err.Error())
+	}
+}
+
+func TestTruncateEventNote(t *testing.T) {
+	assert.Equal(t, "short", truncateEventNote("short"))
+
+	note := truncateEventNote(strings.Repeat("é", eventNoteLengthLimit))
+	assert.LessOrEqual(t, len(note), eventNoteLengthLimit)
+	assert.True(t, strings.HasSuffix(note, "é..."))
+}
+
+func (ts *ccmTestSuite) TestInstanceMetadataReportsFailures() {
+	client := fake.NewClientset()
+	ts.i.initialize(client)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: pool1Node500},
+		Spec:       v1.NodeSpec{ProviderID: providerURIMissingVM},
+	}
+
+	_, err := ts.i.InstanceMetadata(context.Background(), node)
+	ts.Require().ErrorIs(err, cloudprovider.InstanceNotFound)
+
+	events, err := client.EventsV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	ts.Require().NoError(err)
+	ts.Require().Len(events.Items, 1)
+	ts.Equal(eventReasonNotFound, events.Items[0].Reason)
+	ts.Equal(pool1Node500, events.Items[0].Regarding.Name)
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/gofrs/uuid"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// RoutesBackendFile stores the routes in a local JSON file.
+	RoutesBackendFile = "file"
+	// RoutesBackendVM stores the routes in the xenstore of a router VM managed through Xen Orchestra.
+	RoutesBackendVM = "vm"
+)
+
+// routesConfig is the Routes section of the cloud config.
+type routesConfig struct {
+	// Backend selects where the pod CIDR routes are stored. The Routes interface is disabled when empty.
+	Backend string `yaml:"backend,omitempty"`
+	// File configures the "file" backend.
+	File fileRouteBackendConfig `yaml:"file,omitempty"`
+	// VM configures the "vm" backend.
+	VM vmRouteBackendConfig `yaml:"vm,omitempty"`
+}
+
+type fileRouteBackendConfig struct {
+	// Path is the JSON file the route table is written to.
+	Path string `yaml:"path"`
+}
+
+type vmRouteBackendConfig struct {
+	// ID is the UUID of the router VM.
+	ID string `yaml:"id"`
+}
+
+func (c *routesConfig) validate() error {
+	switch c.Backend {
+	case "":
+	case RoutesBackendFile:
+		if c.File.Path == "" {
+			return fmt.Errorf("file.path is required with the %q backend", c.Backend)
+		}
+	case RoutesBackendVM:
+		if _, err := uuid.FromString(c.VM.ID); err != nil {
+			return fmt.Errorf("vm.id must be a UUID, got %q", c.VM.ID)
+		}
+	default:
+		return fmt.Errorf("unsupported backend %q", c.Backend)
+	}
+
+	return nil
+}
+
+// routeEntry is a pod CIDR route stored by a route backend.
+type routeEntry struct {
+	Name            string `json:"name"`
+	ClusterName     string `json:"clusterName"`
+	DestinationCIDR string `json:"destinationCIDR"`
+	// Gateway is the node address the traffic to DestinationCIDR is sent to.
+	Gateway    string `json:"gateway"`
+	NodeName   string `json:"nodeName"`
+	ProviderID string `json:"providerID"`
+}
+
+// routeBackend stores the route table.
+type routeBackend interface {
+	// load returns all the routes stored by the backend.
+	load(ctx context.Context) ([]routeEntry, error)
+	// store replaces the routes stored by the backend.
+	store(ctx context.Context, entries []routeEntry) error
+}
+
+// routes implements cloudprovider.Routes on top of a route backend.
+// The routes are bound to the providerID of their target node, so a route left
+// behind by a node recreated on another VM is reported as a blackhole.
+type routes struct {
+	backend    routeBackend
+	kubeClient clientset.Interface
+
+	mu sync.Mutex
+}
+
+func newRoutes(config routesConfig, client *xok8s.XoClient) (*routes, error) {
+	var backend routeBackend
+
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case RoutesBackendFile:
+		backend = newFileRouteBackend(config.File)
+	case RoutesBackendVM:
+		b, err := newVMRouteBackend(config.VM, client)
+		if err != nil {
+			return nil, err
+		}
+
+		backend = b
+	default:
+		return nil, fmt.Errorf("unsupported Routes backend %q", config.Backend)
+	}
+
+	return &routes{backend: backend}, nil
+}
+
+func (r *routes) initialize(kubeClient clientset.Interface) {
+	r.kubeClient = kubeClient
+}
+
+// ListRoutes lists all managed routes that belong to the specified clusterName.
+func (r *routes) ListRoutes(ctx context.Context, clusterName string) ([]*cloudprovider.Route, error) {
+	klog.V(4).InfoS("routes.ListRoutes() called", "cluster", clusterName)
+
+	entries, err := r.backend.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routes: %v", err)
+	}
+
+	nodes, err := r.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	providerIDs := map[string]string{}
+	for _, node := range nodes.Items {
+		providerIDs[node.Name] = node.Spec.ProviderID
+	}
+
+	result := []*cloudprovider.Route{}
+
+	for _, entry := range entries {
+		if entry.ClusterName != clusterName {
+			continue
+		}
+
+		result = append(result, &cloudprovider.Route{
+			Name:            entry.Name,
+			TargetNode:      types.NodeName(entry.NodeName),
+			DestinationCIDR: entry.DestinationCIDR,
+			Blackhole:       providerIDs[entry.NodeName] != entry.ProviderID,
+		})
+	}
+
+	return result, nil
+}
+
+// CreateRoute creates the described managed route.
+func (r *routes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
+	klog.V(4).InfoS("routes.CreateRoute() called", "cluster", clusterName, "node", route.TargetNode, "cidr", route.DestinationCIDR)
+
+	node, err := r.kubeClient.CoreV1().Nodes().Get(ctx, string(route.TargetNode), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", route.TargetNode, err)
+	}
+
+	if _, _, _, err := parseProviderID(node.Spec.ProviderID); err != nil {
+		return fmt.Errorf("node %s is not managed by Xen Orchestra: %v", node.Name, err)
+	}
+
+	addresses := node.Status.Addresses
+	if route.EnableNodeAddresses && len(route.TargetNodeAddresses) > 0 {
+		addresses = route.TargetNodeAddresses
+	}
+
+	gateway, err := routeGateway(addresses, route.DestinationCIDR)
+	if err != nil {
+		return fmt.Errorf("failed to create route to node %s: %v", node.Name, err)
+	}
+
+	entry := routeEntry{
+		Name:            routeName(clusterName, nameHint, route.DestinationCIDR),
+		ClusterName:     clusterName,
+		DestinationCIDR: route.DestinationCIDR,
+		Gateway:         gateway,
+		NodeName:        node.Name,
+		ProviderID:      node.Spec.ProviderID,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.backend.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load routes: %v", err)
+	}
+
+	entries = slices.DeleteFunc(entries, func(e routeEntry) bool {
+		return e.ClusterName == clusterName && e.DestinationCIDR == route.DestinationCIDR
+	})
+	entries = append(entries, entry)
+
+	if err := r.backend.store(ctx, entries); err != nil {
+		return fmt.Errorf("failed to store routes: %v", err)
+	}
+
+	klog.V(2).InfoS("Created route", "route", entry.Name, "node", entry.NodeName, "cidr", entry.DestinationCIDR, "gateway", entry.Gateway)
+
+	return nil
+}
+
+// DeleteRoute deletes the specified managed route.
+func (r *routes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
+	klog.V(4).InfoS("routes.DeleteRoute() called", "cluster", clusterName, "route", route.Name, "cidr", route.DestinationCIDR)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.backend.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load routes: %v", err)
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(entries), func(e routeEntry) bool {
+		return e.ClusterName == clusterName && e.Name == route.Name && e.DestinationCIDR == route.DestinationCIDR
+	})
+	if len(remaining) == len(entries) {
+		return nil
+	}
+
+	if err := r.backend.store(ctx, remaining); err != nil {
+		return fmt.Errorf("failed to store routes: %v", err)
+	}
+
+	klog.V(2).InfoS("Deleted route", "route", route.Name, "node", route.TargetNode, "cidr", route.DestinationCIDR)
+
+	return nil
+}
+
+// routeName returns a stable route name, the CIDR is hashed as a node has one route per address family.
+func routeName(clusterName, nameHint, cidr string) string {
+	hash := sha256.Sum256([]byte(cidr))
+
+	return fmt.Sprintf("%s-%s-%s", clusterName, nameHint, hex.EncodeToString(hash[:])[:8])
+}
+
+// routeGateway returns the node address of the same family as the destination CIDR,
+// InternalIP addresses are preferred over ExternalIP ones.
+func routeGateway(addresses []v1.NodeAddress, cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination CIDR %q: %v", cidr, err)
+	}
+
+	for _, addressType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, address := range addresses {
+			if address.Type != addressType {
+				continue
+			}
+
+			addr, err := netip.ParseAddr(address.Address)
+			if err == nil && addr.Is4() == prefix.Addr().Is4() {
+				return addr.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no %s node address found", addressFamily(prefix.Addr()))
+}
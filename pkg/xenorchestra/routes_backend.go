@@ -0,0 +1,194 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+)
+
+// routesXenstoreKey is the parent of the route keys pushed to the router VM, one key per route as the xenstore values are limited to 4 KB.
+// It held the whole route table in the previous releases.
+const routesXenstoreKey = "vm-data/xenorchestra-ccm/routes"
+
+// fileRouteBackend stores the route table in a JSON file.
+type fileRouteBackend struct {
+	path string
+}
+
+func newFileRouteBackend(config fileRouteBackendConfig) *fileRouteBackend {
+	return &fileRouteBackend{path: config.Path}
+}
+
+func (b *fileRouteBackend) load(_ context.Context) ([]routeEntry, error) {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []routeEntry{}, nil
+		}
+
+		return nil, err
+	}
+
+	return decodeRouteTable(data)
+}
+
+func (b *fileRouteBackend) store(_ context.Context, entries []routeEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so readers never see a partial table
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck,gosec
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), b.path)
+}
+
+// vmRouteBackend stores the route table in the xenstore of a router VM,
+// an agent running in the VM applies the routes when the key changes.
+type vmRouteBackend struct {
+	client *xok8s.XoClient
+	vmID   uuid.UUID
+}
+
+func newVMRouteBackend(config vmRouteBackendConfig, client *xok8s.XoClient) (*vmRouteBackend, error) {
+	vmID, err := uuid.FromString(config.ID)
+	if err != nil {
+		return nil, fmt.Errorf("router VM ID must be a UUID, got %q", config.ID)
+	}
+
+	return &vmRouteBackend{client: client, vmID: vmID}, nil
+}
+
+func (b *vmRouteBackend) load(ctx context.Context) ([]routeEntry, error) {
+	vm, err := b.client.Client.VM().GetByID(ctx, b.vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get router VM %s: %v", b.vmID, err)
+	}
+
+	entries := []routeEntry{}
+
+	// The route table of the previous releases is replaced by one key per route on the next store
+	if data := vm.XenstoreData[routesXenstoreKey]; data != "" {
+		if entries, err = decodeRouteTable([]byte(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(vm.XenstoreData)) {
+		if !strings.HasPrefix(key, routesXenstoreKey+"/") {
+			continue
+		}
+
+		entry := routeEntry{}
+		if err := json.Unmarshal([]byte(vm.XenstoreData[key]), &entry); err != nil {
+			return nil, fmt.Errorf("invalid route %s: %v", key, err)
+		}
+
+		entries = slices.DeleteFunc(entries, func(e routeEntry) bool { return e.Name == entry.Name })
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (b *vmRouteBackend) store(ctx context.Context, entries []routeEntry) error {
+	vm, err := b.client.Client.VM().GetByID(ctx, b.vmID)
+	if err != nil {
+		return fmt.Errorf("failed to get router VM %s: %v", b.vmID, err)
+	}
+
+	// Only the changed keys are written, and the keys of the deleted routes are removed
+	data := map[string]any{}
+	stored := map[string]bool{}
+
+	for _, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		key := routeXenstoreKey(entry.Name)
+		stored[key] = true
+
+		if current, ok := vm.XenstoreData[key]; !ok || current != string(value) {
+			data[key] = string(value)
+		}
+	}
+
+	for key := range vm.XenstoreData {
+		if key == routesXenstoreKey || (strings.HasPrefix(key, routesXenstoreKey+"/") && !stored[key]) {
+			data[key] = nil
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := setXenstoreData(b.client, b.vmID, data); err != nil {
+		return fmt.Errorf("failed to push routes to router VM %s: %v", b.vmID, err)
+	}
+
+	return nil
+}
+
+// routeXenstoreKey returns the xenstore key of the route, the characters not allowed in a xenstore path are replaced by '_'.
+func routeXenstoreKey(name string) string {
+	return routesXenstoreKey + "/" + strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '@') {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+func decodeRouteTable(data []byte) ([]routeEntry, error) {
+	entries := []routeEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid route table: %v", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	mock_library "github.com/vatesfr/xenorchestra-cloud-controller-manager/pkg/xenorchestra/mocks"
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+	xok8s "github.com/vatesfr/xenorchestra-k8s-common"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	routeClusterName = "kubernetes"
+	routeRouterVMID  = "8c2f1e4d-5a6b-4c7d-9e8f-0a1b2c3d4e5f"
+)
+
+func newTestRouteNode(name, providerID string, addresses ...v1.NodeAddress) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+		Status:     v1.NodeStatus{Addresses: addresses},
+	}
+}
+
+func newTestFileRoutes(t *testing.T, nodes ...*v1.Node) (*routes, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+
+	r, err := newRoutes(routesConfig{Backend: RoutesBackendFile, File: fileRouteBackendConfig{Path: path}}, nil)
+	require.NoError(t, err)
+
+	kubeClient := fake.NewClientset()
+	for _, node := range nodes {
+		_, err := kubeClient.CoreV1().Nodes().Create(t.Context(), node, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	r.initialize(kubeClient)
+
+	return r, path
+}
+
+func TestRoutesConfigValidate(t *testing.T) {
+	assert.NoError(t, (&routesConfig{}).validate())
+	assert.NoError(t, (&routesConfig{Backend: RoutesBackendVM, VM: vmRouteBackendConfig{ID: routeRouterVMID}}).validate())
+
+	assert.EqualError(t, (&routesConfig{Backend: "bgp"}).validate(), `unsupported backend "bgp"`)
+	assert.EqualError(t, (&routesConfig{Backend: RoutesBackendFile}).validate(), `file.path is required with the "file" backend`)
+}
+
+func TestRouteGateway(t *testing.T) {
+	addresses := []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: pool1Node1},
+		{Type: v1.NodeExternalIP, Address: publicIP1},
+		{Type: v1.NodeInternalIP, Address: nodeExternalIP1},
+		{Type: v1.NodeExternalIP, Address: publicIPv6},
+	}
+
+	gateway, err := routeGateway(addresses, "10.244.1.0/24")
+	assert.NoError(t, err)
+	assert.Equal(t, nodeExternalIP1, gateway)
+
+	gateway, err = routeGateway(addresses, "fd00:10:244:1::/64")
+	assert.NoError(t, err)
+	assert.Equal(t, publicIPv6, gateway)
+
+	_, err = routeGateway(addresses[:1], "10.244.1.0/24")
+	assert.EqualError(t, err, "no IPv4 node address found")
+}
+
+func TestFileRoutesLifecycle(t *testing.T) {
+	node1 := newTestRouteNode(pool1Node1, providerURIPool1Node1, v1.NodeAddress{Type: v1.NodeInternalIP, Address: nodeExternalIP1})
+	node2 := newTestRouteNode(pool2Node1, providerURIPool2Node1, v1.NodeAddress{Type: v1.NodeInternalIP, Address: nodeExternalIP2})
+	foreign := newTestRouteNode("foreign", nodeForeignProviderURI, v1.NodeAddress{Type: v1.NodeInternalIP, Address: nodeExternalIP3})
+	r, path := newTestFileRoutes(t, node1, node2, foreign)
+
+	list, err := r.ListRoutes(t.Context(), routeClusterName)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	err = r.CreateRoute(t.Context(), routeClusterName, "uid-1", &cloudprovider.Route{TargetNode: pool1Node1, DestinationCIDR: "10.244.1.0/24"})
+	require.NoError(t, err)
+
+	err = r.CreateRoute(t.Context(), routeClusterName, "uid-2", &cloudprovider.Route{TargetNode: pool2Node1, DestinationCIDR: "10.244.2.0/24"})
+	require.NoError(t, err)
+
+	err = r.CreateRoute(t.Context(), "other", "uid-3", &cloudprovider.Route{TargetNode: pool2Node1, DestinationCIDR: "10.245.2.0/24"})
+	require.NoError(t, err)
+
+	err = r.CreateRoute(t.Context(), routeClusterName, "uid-4", &cloudprovider.Route{TargetNode: "foreign", DestinationCIDR: "10.244.4.0/24"})
+	assert.ErrorContains(t, err, "node foreign is not managed by Xen Orchestra")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	entries := []routeEntry{}
+	require.NoError(t, json.Unmarshal(data, &entries))
+	require.Len(t, entries, 3)
+	assert.Equal(t, nodeExternalIP1, entries[0].Gateway)
+	assert.Equal(t, providerURIPool1Node1, entries[0].ProviderID)
+
+	list, err = r.ListRoutes(t.Context(), routeClusterName)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, routeName(routeClusterName, "uid-1", "10.244.1.0/24"), list[0].Name)
+	assert.Equal(t, pool1Node1, string(list[0].TargetNode))
+	assert.False(t, list[0].Blackhole)
+
+	err = r.DeleteRoute(t.Context(), routeClusterName, list[0])
+	require.NoError(t, err)
+
+	list, err = r.ListRoutes(t.Context(), routeClusterName)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "10.244.2.0/24", list[0].DestinationCIDR)
+}
+
+func TestRoutesBlackholeOnProviderIDChange(t *testing.T) {
+	node := newTestRouteNode(pool1Node1, providerURIPool1Node1, v1.NodeAddress{Type: v1.NodeInternalIP, Address: nodeExternalIP1})
+	r, _ := newTestFileRoutes(t, node)
+
+	err := r.CreateRoute(t.Context(), routeClusterName, "uid-1", &cloudprovider.Route{TargetNode: pool1Node1, DestinationCIDR: "10.244.1.0/24"})
+	require.NoError(t, err)
+
+	// The node was recreated on another VM
+	node.Spec.ProviderID = providerURIPool2Node1
+	_, err = r.kubeClient.CoreV1().Nodes().Update(t.Context(), node, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	list, err := r.ListRoutes(t.Context(), routeClusterName)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.True(t, list[0].Blackhole)
+}
+
+func TestVMRouteBackend(t *testing.T) {
+	r1 := routeEntry{Name: "kubernetes-uid-1-5d41402a", ClusterName: "kubernetes", DestinationCIDR: "10.244.1.0/24", Gateway: "10.0.0.1"}
+	r2 := routeEntry{Name: "kubernetes-uid-2-7d793037", ClusterName: "kubernetes", DestinationCIDR: "10.244.2.0/24", Gateway: "10.0.0.2"}
+	r3 := routeEntry{Name: "kubernetes-uid-3-4e07408f", ClusterName: "kubernetes", DestinationCIDR: "10.244.3.0/24", Gateway: "10.0.0.3"}
+
+	marshal := func(entry routeEntry) string {
+		data, err := json.Marshal(entry)
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	vm := &payloads.VM{
+		ID: uuid.FromStringOrNil(routeRouterVMID),
+		XenstoreData: map[string]string{
+			// Route table of the previous releases
+			routesXenstoreKey:                    `[{"name":"kubernetes-uid-1-5d41402a","clusterName":"kubernetes","destinationCIDR":"10.244.1.0/24","gateway":"10.0.0.1"}]`,
+			routesXenstoreKey + "/" + r2.Name:    marshal(r2),
+			"vm-data/xenorchestra-ccm/unrelated": "value",
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	mockVM := mock_library.NewMockVM(ctrl)
+	mockVM.EXPECT().GetByID(gomock.Any(), uuid.FromStringOrNil(routeRouterVMID)).Return(vm, nil).AnyTimes()
+
+	rpc := &fakeV1Client{}
+	mockLib := mock_library.NewMockLibrary(ctrl)
+	mockLib.EXPECT().VM().Return(mockVM).AnyTimes()
+	mockLib.EXPECT().V1Client().Return(rpc).AnyTimes()
+
+	backend, err := newVMRouteBackend(vmRouteBackendConfig{ID: routeRouterVMID}, &xok8s.XoClient{Client: mockLib})
+	require.NoError(t, err)
+
+	entries, err := backend.load(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []routeEntry{r1, r2}, entries)
+
+	// The route table is split into one key per route, the deleted route key is removed
+	err = backend.store(t.Context(), []routeEntry{r1, r3})
+	require.NoError(t, err)
+	require.Len(t, rpc.calls, 1)
+	assert.Equal(t, routeRouterVMID, rpc.calls[0]["id"])
+	assert.Equal(t, map[string]any{
+		routesXenstoreKey:                 nil,
+		routesXenstoreKey + "/" + r1.Name: marshal(r1),
+		routesXenstoreKey + "/" + r2.Name: nil,
+		routesXenstoreKey + "/" + r3.Name: marshal(r3),
+	}, rpc.calls[0]["xenStoreData"])
+
+	// Unchanged routes are not pushed again
+	vm.XenstoreData = map[string]string{
+		routesXenstoreKey + "/" + r1.Name: marshal(r1),
+		routesXenstoreKey + "/" + r3.Name: marshal(r3),
+	}
+	err = backend.store(t.Context(), []routeEntry{r3, r1})
+	require.NoError(t, err)
+	assert.Len(t, rpc.calls, 1)
+}
+
+func TestRouteXenstoreKey(t *testing.T) {
+	assert.Equal(t, routesXenstoreKey+"/kubernetes-uid-1-5d41402a", routeXenstoreKey("kubernetes-uid-1-5d41402a"))
+	assert.Equal(t, routesXenstoreKey+"/prod_eu_uid_1-5d41402a", routeXenstoreKey("prod.eu/uid 1-5d41402a"))
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Topology sources.
+const (
+	// TopologySourceHost is the host running the VM.
+	TopologySourceHost = "host"
+	// TopologySourcePool is the pool of the VM.
+	TopologySourcePool = "pool"
+	// TopologySourceTag is the value of a key=value tag of the VM, its host or its pool.
+	TopologySourceTag = "tag"
+	// TopologySourceCustomField is an XO custom field of the host or the pool.
+	TopologySourceCustomField = "customField"
+)
+
+// Objects the tags and custom fields are read from.
+const (
+	TopologyObjectVM   = "vm"
+	TopologyObjectHost = "host"
+	TopologyObjectPool = "pool"
+)
+
+// customFieldPrefix is the other_config prefix of the XO custom fields.
+const customFieldPrefix = "XenCenter.CustomFields."
+
+// topologyConfig is the Topology section of the cloud config.
+type topologyConfig struct {
+	// Zone defaults to the host UUID.
+	Zone topologySource `yaml:"zone,omitempty"`
+	// Region defaults to the pool UUID.
+	Region topologySource `yaml:"region,omitempty"`
+}
+
+// topologySource resolves a zone or a region.
+type topologySource struct {
+	// Source is host, pool, tag or customField.
+	Source string `yaml:"source,omitempty"`
+	// Object is the object the tag or custom field is read from: vm, host or pool.
+	// Defaults to vm for tags and to host for custom fields.
+	Object string `yaml:"object,omitempty"`
+	// Key is the tag key, "rack" reads the "rack=R12" tag, or the custom field name.
+	Key string `yaml:"key,omitempty"`
+	// NameLabel reports the host or pool name_label instead of its UUID.
+	NameLabel bool `yaml:"nameLabel,omitempty"`
+}
+
+func (c *topologyConfig) validate() error {
+	if err := c.Zone.validate(); err != nil {
+		return fmt.Errorf("zone: %v", err)
+	}
+
+	if err := c.Region.validate(); err != nil {
+		return fmt.Errorf("region: %v", err)
+	}
+
+	return nil
+}
+
+func (s *topologySource) validate() error {
+	switch s.Source {
+	case "", TopologySourceHost, TopologySourcePool:
+		if s.Object != "" || s.Key != "" {
+			return fmt.Errorf("object and key are only used by the %q and %q sources", TopologySourceTag, TopologySourceCustomField)
+		}
+
+		return nil
+	case TopologySourceTag:
+		switch s.Object {
+		case "", TopologyObjectVM, TopologyObjectHost, TopologyObjectPool:
+		default:
+			return fmt.Errorf("object must be %s, %s or %s, got %q", TopologyObjectVM, TopologyObjectHost, TopologyObjectPool, s.Object)
+		}
+	case TopologySourceCustomField:
+		switch s.Object {
+		case "", TopologyObjectHost, TopologyObjectPool:
+		default:
+			return fmt.Errorf("object must be %s or %s, got %q", TopologyObjectHost, TopologyObjectPool, s.Object)
+		}
+	default:
+		return fmt.Errorf("unsupported source %q", s.Source)
+	}
+
+	if s.Key == "" {
+		return fmt.Errorf("key is required with the %q source", s.Source)
+	}
+
+	if s.NameLabel {
+		return fmt.Errorf("nameLabel is only used by the %q and %q sources", TopologySourceHost, TopologySourcePool)
+	}
+
+	return nil
+}
+
+// zone returns the zone of the VM. The host and pool are nil when they could not be fetched.
+func (c *topologyConfig) zone(vm *payloads.VM, host *payloads.Host, pool *payloads.Pool) string {
+	source := c.Zone
+	if source.Source == "" {
+		source.Source = TopologySourceHost
+	}
+
+	return sanitizeToLabel(source.value(vm, host, pool))
+}
+
+// region returns the region of the VM. The host and pool are nil when they could not be fetched.
+func (c *topologyConfig) region(vm *payloads.VM, host *payloads.Host, pool *payloads.Pool) string {
+	source := c.Region
+	if source.Source == "" {
+		source.Source = TopologySourcePool
+	}
+
+	return sanitizeToLabel(source.value(vm, host, pool))
+}
+
+func (s *topologySource) value(vm *payloads.VM, host *payloads.Host, pool *payloads.Pool) string {
+	switch s.Source {
+	case TopologySourceHost:
+		if !s.NameLabel {
+			return vm.Container.String()
+		}
+
+		if host != nil {
+			return host.NameLabel
+		}
+	case TopologySourcePool:
+		if !s.NameLabel {
+			return vm.PoolID.String()
+		}
+
+		if pool != nil {
+			return pool.NameLabel
+		}
+	case TopologySourceTag:
+		switch s.Object {
+		case "", TopologyObjectVM:
+			return tagValue(vm.Tags, s.Key)
+		case TopologyObjectHost:
+			if host != nil {
+				return tagValue(host.Tags, s.Key)
+			}
+		case TopologyObjectPool:
+			if pool != nil {
+				return tagValue(pool.Tags, s.Key)
+			}
+		}
+	case TopologySourceCustomField:
+		switch s.Object {
+		case "", TopologyObjectHost:
+			if host != nil {
+				if value, ok := host.OtherConfig[customFieldPrefix+s.Key].(string); ok {
+					return value
+				}
+			}
+		case TopologyObjectPool:
+			if pool != nil {
+				return pool.OtherConfig[customFieldPrefix+s.Key]
+			}
+		}
+	}
+
+	return ""
+}
+
+// tagValue returns the value of the key=value tag.
+func tagValue(tags []string, key string) string {
+	for _, tag := range tags {
+		if value, ok := strings.CutPrefix(tag, key+"="); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// getHostAndPool returns the host running the VM and its pool, they are nil when they could not be fetched.
+// The failures are reported on the node, or on the CCM when the node is nil.
+func (i *instances) getHostAndPool(ctx context.Context, endpoint *xoEndpoint, node *v1.Node, vmRef *payloads.VM) (*payloads.Host, *payloads.Pool) {
+	hostRef, err := endpoint.getHost(ctx, vmRef.Container)
+	if err != nil {
+		klog.ErrorS(err, "failed to get host info", "hostID", vmRef.Container.String())
+		i.reporter.reportFailure(ctx, node, eventActionGetHost, fmt.Errorf("failed to get host %s: %w", vmRef.Container, err))
+
+		hostRef = nil
+	}
+
+	poolRef, err := endpoint.getPool(ctx, vmRef.PoolID)
+	if err != nil {
+		klog.ErrorS(err, "failed to get pool info", "poolID", vmRef.PoolID.String())
+		i.reporter.reportFailure(ctx, node, eventActionGetPool, fmt.Errorf("failed to get pool %s: %w", vmRef.PoolID, err))
+
+		poolRef = nil
+	}
+
+	return hostRef, poolRef
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+)
+
+func TestTopologyConfigValidate(t *testing.T) {
+	assert.NoError(t, (&topologyConfig{}).validate())
+	assert.NoError(t, (&topologyConfig{
+		Zone:   topologySource{Source: TopologySourceTag, Object: TopologyObjectHost, Key: "rack"},
+		Region: topologySource{Source: TopologySourceCustomField, Object: TopologyObjectPool, Key: "datacenter"},
+	}).validate())
+
+	assert.EqualError(t, (&topologyConfig{Zone: topologySource{Source: "rack"}}).validate(), `zone: unsupported source "rack"`)
+	assert.EqualError(t, (&topologyConfig{Region: topologySource{Source: TopologySourceTag}}).validate(), `region: key is required with the "tag" source`)
+	assert.EqualError(t, (&topologyConfig{Zone: topologySource{Source: TopologySourceCustomField, Object: TopologyObjectVM, Key: "rack"}}).validate(),
+		`zone: object must be host or pool, got "vm"`)
+	assert.EqualError(t, (&topologyConfig{Zone: topologySource{Source: TopologySourcePool, Key: "rack"}}).validate(),
+		`zone: object and key are only used by the "tag" and "customField" sources`)
+}
+
+func TestTopologyZoneAndRegion(t *testing.T) {
+	vm := &payloads.VM{
+		Container: uuid.FromStringOrNil(host1ID),
+		PoolID:    uuid.FromStringOrNil(pool1ID),
+		Tags:      []string{"datacenter=paris"},
+	}
+	host := &payloads.Host{
+		NameLabel:   "XCP host 1",
+		Tags:        []string{"rack=R12"},
+		OtherConfig: map[string]interface{}{customFieldPrefix + "room": "B2"},
+	}
+	pool := &payloads.Pool{
+		NameLabel:   testPool1,
+		OtherConfig: map[string]string{customFieldPrefix + "datacenter": "lyon"},
+	}
+
+	tests := []struct {
+		name           string
+		config         topologyConfig
+		host           *payloads.Host
+		pool           *payloads.Pool
+		expectedZone   string
+		expectedRegion string
+	}{
+		{
+			name:           "default",
+			config:         topologyConfig{},
+			expectedZone:   host1ID,
+			expectedRegion: pool1ID,
+		},
+		{
+			name: "zone is the pool, region is a vm tag",
+			config: topologyConfig{
+				Zone:   topologySource{Source: TopologySourcePool, NameLabel: true},
+				Region: topologySource{Source: TopologySourceTag, Key: "datacenter"},
+			},
+			host:           host,
+			pool:           pool,
+			expectedZone:   testPool1,
+			expectedRegion: "paris",
+		},
+		{
+			name: "zone is a host tag, region is a pool custom field",
+			config: topologyConfig{
+				Zone:   topologySource{Source: TopologySourceTag, Object: TopologyObjectHost, Key: "rack"},
+				Region: topologySource{Source: TopologySourceCustomField, Object: TopologyObjectPool, Key: "datacenter"},
+			},
+			host:           host,
+			pool:           pool,
+			expectedZone:   "R12",
+			expectedRegion: "lyon",
+		},
+		{
+			name: "host name label and custom field",
+			config: topologyConfig{
+				Zone:   topologySource{Source: TopologySourceHost, NameLabel: true},
+				Region: topologySource{Source: TopologySourceCustomField, Key: "room"},
+			},
+			host:           host,
+			pool:           pool,
+			expectedZone:   "XCP-host-1",
+			expectedRegion: "B2",
+		},
+		{
+			name: "host unavailable",
+			config: topologyConfig{
+				Zone:   topologySource{Source: TopologySourceTag, Object: TopologyObjectHost, Key: "rack"},
+				Region: topologySource{Source: TopologySourceTag, Object: TopologyObjectVM, Key: "missing"},
+			},
+			pool: pool,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedZone, tt.config.zone(vm, tt.host, tt.pool))
+			assert.Equal(t, tt.expectedRegion, tt.config.region(vm, tt.host, tt.pool))
+		})
+	}
+}
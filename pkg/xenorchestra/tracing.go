@@ -0,0 +1,116 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/component-base/tracing"
+	tracingapi "k8s.io/component-base/tracing/api/v1"
+	"k8s.io/klog/v2"
+)
+
+// TracerName is the instrumentation scope of the spans of the CCM.
+const TracerName = "github.com/vatesfr/xenorchestra-cloud-controller-manager"
+
+// Attributes of the spans.
+const (
+	attributeNode       = semconv.K8SNodeNameKey
+	attributeProviderID = attribute.Key("k8s.node.provider_id")
+	attributeVMID       = attribute.Key("xenorchestra.vm.id")
+	attributeEndpoint   = attribute.Key("xenorchestra.endpoint")
+	attributeOperation  = attribute.Key("xenorchestra.operation")
+)
+
+// tracingConfig is the Tracing section of the cloud config, the spans are exported with OTLP over gRPC.
+// Without this section the spans are dropped.
+type tracingConfig struct {
+	// Endpoint of the OTLP collector, it defaults to localhost:4317.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// SamplingRatePerMillion is the number of traces sampled per million, none are sampled by default.
+	SamplingRatePerMillion int32 `yaml:"samplingRatePerMillion,omitempty"`
+}
+
+// configuration returns the component-base tracing configuration.
+func (c *tracingConfig) configuration() *tracingapi.TracingConfiguration {
+	if c == nil {
+		return nil
+	}
+
+	config := &tracingapi.TracingConfiguration{SamplingRatePerMillion: &c.SamplingRatePerMillion}
+	if c.Endpoint != "" {
+		config.Endpoint = &c.Endpoint
+	}
+
+	return config
+}
+
+func (c *tracingConfig) validate() error {
+	return tracingapi.ValidateTracingConfiguration(c.configuration(), nil, field.NewPath("tracing")).ToAggregate()
+}
+
+// startTracing sets the global tracer provider of the CCM, and shuts it down with the context.
+func startTracing(ctx context.Context, config *tracingConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	tp, err := tracing.NewProvider(ctx, config.configuration(), nil, []resource.Option{
+		resource.WithAttributes(semconv.ServiceName(ProviderName + "-cloud-controller-manager")),
+	})
+	if err != nil {
+		return err
+	}
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(tracing.Propagators())
+
+	go func() {
+		<-ctx.Done()
+
+		// The context is done, the remaining spans are flushed with a fresh one
+		if err := tp.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			klog.ErrorS(err, "failed to flush the traces")
+		}
+	}()
+
+	klog.InfoS("Tracing enabled", "endpoint", config.Endpoint, "samplingRatePerMillion", config.SamplingRatePerMillion)
+
+	return nil
+}
+
+// startSpan starts a span with the global tracer provider, it is a no-op until tracing is enabled.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records the error of the span, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/mock/gomock"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+)
+
+func TestGuardedRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctrl := gomock.NewController(t)
+
+	g := newRequestGuard("dc1", rateLimitConfig{}, circuitBreakerConfig{Disabled: true})
+	mockVM, lib := guardedMockVM(ctrl, g)
+	id := uuid.FromStringOrNil(vmPool1Node1ID)
+
+	gomock.InOrder(
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(&payloads.VM{ID: id}, nil),
+		mockVM.EXPECT().GetByID(gomock.Any(), id).Return(nil, errUnreachable),
+	)
+
+	// The request span is a child of the caller span
+	ctx, parent := startSpan(t.Context(), "InstanceMetadata")
+
+	_, err := lib.VM().GetByID(ctx, id)
+	require.NoError(t, err)
+
+	_, err = lib.VM().GetByID(ctx, id)
+	require.Error(t, err)
+
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	for _, span := range spans[:2] {
+		assert.Equal(t, "xenorchestra.vm_get", span.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, span.Attributes(), attributeVMID.String(vmPool1Node1ID))
+		assert.Contains(t, span.Attributes(), attributeEndpoint.String("dc1"))
+	}
+
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestTracingConfig(t *testing.T) {
+	cfg, err := readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+`))
+	require.NoError(t, err)
+	assert.Nil(t, cfg.Tracing, "the spans are dropped by default")
+
+	cfg, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+tracing:
+  endpoint: otel-collector.monitoring:4317
+  samplingRatePerMillion: 1000
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &tracingConfig{Endpoint: "otel-collector.monitoring:4317", SamplingRatePerMillion: 1000}, cfg.Tracing)
+
+	_, err = readCloudConfig(strings.NewReader(`
+url: https://example.com
+token: "12ABC"
+tracing:
+  samplingRatePerMillion: 2000000
+`))
+	assert.ErrorContains(t, err, "tracing.samplingRatePerMillion")
+}
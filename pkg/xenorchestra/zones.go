@@ -0,0 +1,75 @@
+/*
+Copyright 2025 Vatesfr
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package xenorchestra
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/vatesfr/xenorchestra-go-sdk/pkg/payloads"
+
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// The legacy Zones interface reports the same topology as InstanceMetadata,
+// the zone and region are resolved with the topology strategies.
+var _ cloudprovider.Zones = &instances{}
+
+// GetZone returns the Zone containing the current failure zone and locality region that the program is running in.
+func (i *instances) GetZone(ctx context.Context) (cloudprovider.Zone, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return cloudprovider.Zone{}, fmt.Errorf("instances.GetZone() failed to get hostname: %v", err)
+	}
+
+	return i.GetZoneByNodeName(ctx, types.NodeName(hostname))
+}
+
+// GetZoneByProviderID returns the Zone containing the current zone and locality region of the node specified by providerID.
+func (i *instances) GetZoneByProviderID(ctx context.Context, providerID string) (cloudprovider.Zone, error) {
+	klog.V(4).InfoS("instances.GetZoneByProviderID() called", "providerID", providerID)
+
+	vmRef, endpoint, err := i.getInstance(ctx, nodeFromProviderID(providerID))
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+
+	return i.instanceZone(ctx, endpoint, vmRef), nil
+}
+
+// GetZoneByNodeName returns the Zone containing the current zone and locality region of the node specified by node name.
+func (i *instances) GetZoneByNodeName(ctx context.Context, nodeName types.NodeName) (cloudprovider.Zone, error) {
+	klog.V(4).InfoS("instances.GetZoneByNodeName() called", "node", nodeName)
+
+	vmRef, _, endpoint, err := i.getInstanceByNodeName(ctx, nodeName)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+
+	return i.instanceZone(ctx, endpoint, vmRef), nil
+}
+
+func (i *instances) instanceZone(ctx context.Context, endpoint *xoEndpoint, vmRef *payloads.VM) cloudprovider.Zone {
+	hostRef, poolRef := i.getHostAndPool(ctx, endpoint, nil, vmRef)
+
+	return cloudprovider.Zone{
+		FailureDomain: i.topology.zone(vmRef, hostRef, poolRef),
+		Region:        i.topology.region(vmRef, hostRef, poolRef),
+	}
+}